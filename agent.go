@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A host with agent: true isn't checked over SSH. "checkhealth agent" runs
+// on it, or on a machine in its network, and every agent.interval (default
+// 30s) runs the check commands the monitor asks for and reports their
+// output to the monitor, which parses it, applies the thresholds and alerts
+// as for SSH. Hardened hosts need no inbound SSH: the agent connects out.
+// Reports are signed with a key derived from agents.secret and the host's
+// name, so an agent can't report for another host; "checkhealth agent key
+// <host>" prints it. The monitor signs its replies with the same key, so an
+// agent only runs commands the monitor sent, and of those only the ones
+// agentAllowedCommands allows.
+
+// agentResult is the output of a check command run by an agent.
+type agentResult struct {
+	Command  string    `json:"command"`
+	Output   string    `json:"output"`
+	Error    string    `json:"error,omitempty"`
+	ExitCode int       `json:"exitCode,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// agentReport is what an agent sends to POST /api/v1/agent/report.
+type agentReport struct {
+	Host    string        `json:"host"`
+	Time    time.Time     `json:"time"`
+	Results []agentResult `json:"results"`
+}
+
+// agentReply is the monitor's answer to a report: the commands the agent is
+// to run from then on. It repeats the report's time, so a signed reply can't
+// be replayed to the agent as the answer to a later report.
+type agentReply struct {
+	Time     time.Time `json:"time"`
+	Commands []string  `json:"commands"`
+}
+
+// agentSignatureHeader carries the hex HMAC-SHA256 of a report's or a
+// reply's body.
+const agentSignatureHeader = "X-Checkhealth-Signature"
+
+// agentKey is the key an agent signs the reports of a host with.
+func agentKey(secret, host string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToLower(host)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signAgentBody(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validAgentSignature(key string, body, signature []byte) bool {
+	want, _ := hex.DecodeString(signAgentBody(key, body))
+	return hmac.Equal(signature, want)
+}
+
+// agentExitError is a command that exited with a non-zero status on an
+// agent's host. Like exec.ExitError, it has the ExitCode.
+type agentExitError struct {
+	code int
+	msg  string
+}
+
+func (e agentExitError) Error() string { return e.msg }
+func (e agentExitError) ExitCode() int { return e.code }
+
+// agentMaxSkew is how far a report's time may be from the monitor's clock.
+const agentMaxSkew = 5 * time.Minute
+
+// agentCommandTTL is how long a command the monitor stopped asking for
+// stays in the agent's list.
+const agentCommandTTL = 10 * time.Minute
+
+type agentHost struct {
+	wanted     map[string]time.Time // command -> when last asked for
+	results    map[string]agentResult
+	lastReport time.Time     // the time of the latest report, by the agent's clock
+	updated    chan struct{} // closed, and replaced, when a report arrives
+}
+
+type agentRegistry struct {
+	mu    sync.Mutex
+	hosts map[string]*agentHost
+}
+
+var agents = &agentRegistry{hosts: map[string]*agentHost{}}
+
+// host must be called with r.mu held.
+func (r *agentRegistry) host(name string) *agentHost {
+	key := strings.ToLower(name)
+	a, ok := r.hosts[key]
+	if !ok {
+		a = &agentHost{wanted: map[string]time.Time{}, results: map[string]agentResult{}, updated: make(chan struct{})}
+		r.hosts[key] = a
+	}
+	return a
+}
+
+// agentStaleAfter is how old an agent's latest output may be before its
+// checks fail: agents.staleAfter, default 2m.
+func agentStaleAfter() time.Duration {
+	return conf().GetDuration("agents.staleAfter")
+}
+
+// output asks for command on a host and returns the agent's latest output
+// of it. A command the agent hasn't run yet is waited for until ctx is
+// done.
+func (r *agentRegistry) output(ctx context.Context, host, command string) (string, error) {
+	for {
+		r.mu.Lock()
+		a := r.host(host)
+		a.wanted[command] = time.Now()
+		res, ok := a.results[command]
+		updated := a.updated
+		r.mu.Unlock()
+		if ok {
+			switch {
+			case time.Since(res.Time) > agentStaleAfter():
+				return "", fmt.Errorf("no report from the agent since %s", res.Time.In(displayLocation()).Format("Jan 2 15:04:05"))
+			case res.ExitCode != 0:
+				return "", agentExitError{res.ExitCode, res.Error}
+			case res.Error != "":
+				return "", errors.New(res.Error)
+			}
+			return res.Output, nil
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return "", ctx.Err()
+			}
+			return "", errors.New("no report from the agent")
+		case <-updated:
+		}
+	}
+}
+
+// lastReports returns the time of the latest report of each host, for the
+// saved state.
+func (r *agentRegistry) lastReports() map[string]time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports := make(map[string]time.Time, len(r.hosts))
+	for name, a := range r.hosts {
+		if !a.lastReport.IsZero() {
+			reports[name] = a.lastReport
+		}
+	}
+	return reports
+}
+
+// restoreLastReport sets the time of the latest report of a host from the
+// saved state, unless a later report has arrived since.
+func (r *agentRegistry) restoreLastReport(host string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a := r.host(host); t.After(a.lastReport) {
+		a.lastReport = t
+	}
+}
+
+// record stores a report and returns the commands wanted of the host.
+func (r *agentRegistry) record(host string, rep agentReport) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a := r.host(host)
+	// A report is never older than the one before, so a captured report
+	// can't be replayed.
+	if !rep.Time.After(a.lastReport) {
+		return nil, fmt.Errorf("report of %s is not newer than the last one", rep.Time.Format(time.RFC3339))
+	}
+	a.lastReport = rep.Time
+	// The agent's clock may be off by up to agentMaxSkew; its results
+	// count as of their arrival.
+	now := time.Now()
+	for _, res := range rep.Results {
+		res.Time = now
+		a.results[res.Command] = res
+	}
+	close(a.updated)
+	a.updated = make(chan struct{})
+	agentLastReport.WithLabelValues(host).Set(float64(now.Unix()))
+
+	commands := []string{}
+	for command, asked := range a.wanted {
+		if now.Sub(asked) > agentCommandTTL {
+			delete(a.wanted, command)
+			delete(a.results, command)
+			continue
+		}
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands, nil
+}
+
+// runHostCommand runs a check command of a host: over ssh, or for a host
+// with an agent, by taking the agent's latest output of it.
+func runHostCommand(ctx context.Context, h Host, command string) (string, error) {
+	if h.Agent {
+		return agents.output(ctx, h.Name, command)
+	}
+	return runSSHCommand(ctx, command)
+}
+
+// agentReportHandler takes the reports of agents. It is authenticated by
+// their signature rather than by http.auth.
+func agentReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLeader() {
+		http.Error(w, fmt.Sprintf("standing by, send to the leader %s", currentLeader()), http.StatusServiceUnavailable)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 8<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var rep agentReport
+	if err := json.Unmarshal(body, &rep); err != nil {
+		http.Error(w, "parsing the report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	h, ok := hostByName(rep.Host)
+	if !ok || !h.Agent {
+		http.Error(w, fmt.Sprintf("no agent host %q", rep.Host), http.StatusNotFound)
+		return
+	}
+	signature, err := hex.DecodeString(r.Header.Get(agentSignatureHeader))
+	key := agentKey(conf().GetString("agents.secret"), h.Name)
+	if err != nil || !validAgentSignature(key, body, signature) {
+		slog.Warn("Agent report with a bad signature", "host", h.Name, "remote", r.RemoteAddr)
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	if skew := time.Since(rep.Time); skew > agentMaxSkew || skew < -agentMaxSkew {
+		http.Error(w, fmt.Sprintf("the report's time is %s off, check the clocks", skew.Round(time.Second)), http.StatusBadRequest)
+		return
+	}
+	commands, err := agents.record(h.Name, rep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	slog.Debug("Agent reported", "host", h.Name, "results", len(rep.Results))
+	reply, err := json.Marshal(agentReply{Time: rep.Time, Commands: commands})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(agentSignatureHeader, signAgentBody(key, reply))
+	w.Write(reply)
+}
+
+// agentRefused is the error reported for a command not in agent.commands.
+const agentRefused = "refused by the agent, the command is not in agent.commands"
+
+// agentAllowedCommands are the commands an agent runs: agent.commands, by
+// default the built-in health check and the commands of the customChecks
+// in the agent's config. With agent.allowAnyCommand it is nil, and the
+// agent runs any command the monitor sends.
+func agentAllowedCommands() []string {
+	if conf().GetBool("agent.allowAnyCommand") {
+		return nil
+	}
+	if commands := conf().GetStringSlice("agent.commands"); len(commands) > 0 {
+		return commands
+	}
+	allowed := []string{healthScript}
+	for _, c := range customChecks() {
+		if c.Command != "" {
+			allowed = append(allowed, c.Command)
+		}
+	}
+	return allowed
+}
+
+// runAgentCommands runs the commands locally. Those not in allowed are
+// refused, unless allowed is nil.
+func runAgentCommands(ctx context.Context, commands, allowed []string) []agentResult {
+	results := make([]agentResult, len(commands))
+	var wg sync.WaitGroup
+	for i, command := range commands {
+		if allowed != nil && !contains(allowed, command) {
+			results[i] = agentResult{Command: command, Error: agentRefused, Time: time.Now()}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output, err := runSSHCommand(ctx, command)
+			res := agentResult{Command: command, Output: output, Time: time.Now()}
+			if err != nil {
+				res.Error = err.Error()
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					res.ExitCode = exitErr.ExitCode()
+				}
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// sendAgentReport sends a report to the first of the monitors that takes
+// it: standby monitors turn it away. A reply that isn't signed with the
+// host's key, or doesn't answer this report, is an error.
+func sendAgentReport(ctx context.Context, monitors []string, key string, rep agentReport) (agentReply, error) {
+	var reply agentReply
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return reply, err
+	}
+	signature := signAgentBody(key, body)
+	for _, url := range monitors {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(url, "/")+"/api/v1/agent/report", bytes.NewReader(body))
+		if err != nil {
+			return reply, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(agentSignatureHeader, signature)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			slog.Warn("Error reporting to the monitor", "monitor", url, "err", err)
+			continue
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(data)))
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				slog.Debug("Monitor stands by", "monitor", url)
+				continue
+			}
+			return reply, err
+		}
+		return verifyAgentReply(key, rep, data, resp.Header.Get(agentSignatureHeader))
+	}
+	if err == nil {
+		err = errors.New("no monitor took the report")
+	}
+	return reply, err
+}
+
+// verifyAgentReply checks the signature of a monitor's reply to rep and
+// reads it.
+func verifyAgentReply(key string, rep agentReport, data []byte, signature string) (agentReply, error) {
+	var reply agentReply
+	sig, err := hex.DecodeString(signature)
+	if err != nil || !validAgentSignature(key, data, sig) {
+		return reply, errors.New("the monitor's reply has a bad signature")
+	}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return reply, err
+	}
+	if !reply.Time.Equal(rep.Time) {
+		return agentReply{}, fmt.Errorf("the monitor's reply is to the report of %s, not of %s", reply.Time.Format(time.RFC3339Nano), rep.Time.Format(time.RFC3339Nano))
+	}
+	return reply, nil
+}
+
+// agentHostName is the host the agent reports for: agent.host, by default
+// the hostname.
+func agentHostName() string {
+	if name := conf().GetString("agent.host"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// runAgent reports to the monitors of agent.monitors with agent.key until
+// ctx is done. Commands the monitor asks for anew are run and reported
+// right away, so their first results aren't an interval late. Only the
+// commands of agentAllowedCommands are run.
+func runAgent(ctx context.Context) error {
+	monitors := conf().GetStringSlice("agent.monitors")
+	key := conf().GetString("agent.key")
+	host := agentHostName()
+	if len(monitors) == 0 || key == "" {
+		return errors.New("agent.monitors and agent.key are required")
+	}
+	interval := conf().GetDuration("agent.interval")
+	allowed := agentAllowedCommands()
+	if allowed == nil {
+		slog.Warn("Running any command the monitor sends, agent.allowAnyCommand is set", "host", host)
+	}
+	slog.Info("Reporting to the monitor", "host", host, "monitors", monitors, "interval", interval, "allowed", len(allowed))
+
+	var commands []string
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, interval)
+		rep := agentReport{Host: host, Results: runAgentCommands(runCtx, commands, allowed)}
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		rep.Time = time.Now()
+		reply, err := sendAgentReport(ctx, monitors, key, rep)
+		if err != nil {
+			slog.Error("Error reporting", "host", host, "err", err)
+		} else {
+			added := !containsAll(commands, reply.Commands)
+			for _, command := range reply.Commands {
+				if allowed != nil && !contains(allowed, command) && !contains(commands, command) {
+					slog.Warn("Refusing a command not in agent.commands", "host", host, "command", command)
+				}
+			}
+			commands = reply.Commands
+			if added {
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// containsAll reports whether list has every element of items.
+func containsAll(list, items []string) bool {
+	for _, item := range items {
+		if !contains(list, item) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const agentTestConfig = `
+hosts:
+  - name: hard1
+    agent: true
+  - name: hard2
+    agent: true
+  - name: plain
+    command: "true"
+agents:
+  secret: "s3cret"
+`
+
+func TestAgentKey(t *testing.T) {
+	if agentKey("s3cret", "hard1") != agentKey("s3cret", "HARD1") {
+		t.Error("the key depends on the case of the host name")
+	}
+	if agentKey("s3cret", "hard1") == agentKey("s3cret", "hard2") {
+		t.Error("two hosts have the same key")
+	}
+	if agentKey("s3cret", "hard1") == agentKey("other", "hard1") {
+		t.Error("two secrets give the same key")
+	}
+}
+
+// postAgentReport sends rep, signed with key, to the report handler.
+func postAgentReport(t *testing.T, key string, rep agentReport) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/agent/report", bytes.NewReader(body))
+	r.Header.Set(agentSignatureHeader, signAgentBody(key, body))
+	w := httptest.NewRecorder()
+	agentReportHandler(w, r)
+	return w
+}
+
+func TestAgentReportHandler(t *testing.T) {
+	useConfig(t, agentTestConfig)
+	now := time.Now()
+	tests := []struct {
+		name     string
+		keyOf    string // the host whose key signs the report
+		host     string
+		time     time.Time
+		wantCode int
+	}{
+		{name: "signed", keyOf: "hard1", host: "hard1", time: now, wantCode: http.StatusOK},
+		{name: "other host's key", keyOf: "hard2", host: "hard1", time: now, wantCode: http.StatusUnauthorized},
+		{name: "unknown host", keyOf: "nope", host: "nope", time: now, wantCode: http.StatusNotFound},
+		{name: "not an agent host", keyOf: "plain", host: "plain", time: now, wantCode: http.StatusNotFound},
+		{name: "clock behind", keyOf: "hard1", host: "hard1", time: now.Add(-10 * time.Minute), wantCode: http.StatusBadRequest},
+		{name: "clock ahead", keyOf: "hard1", host: "hard1", time: now.Add(10 * time.Minute), wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents = &agentRegistry{hosts: map[string]*agentHost{}}
+			key := agentKey("s3cret", tt.keyOf)
+			rep := agentReport{Host: tt.host, Time: tt.time}
+			w := postAgentReport(t, key, rep)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if _, err := verifyAgentReply(key, rep, w.Body.Bytes(), w.Header().Get(agentSignatureHeader)); err != nil {
+				t.Errorf("the reply doesn't verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestAgentReportReplay(t *testing.T) {
+	useConfig(t, agentTestConfig)
+	agents = &agentRegistry{hosts: map[string]*agentHost{}}
+	key := agentKey("s3cret", "hard1")
+	first := agentReport{Host: "hard1", Time: time.Now()}
+	if w := postAgentReport(t, key, first); w.Code != http.StatusOK {
+		t.Fatalf("first report: status %d: %s", w.Code, w.Body)
+	}
+	if w := postAgentReport(t, key, first); w.Code != http.StatusConflict {
+		t.Errorf("replayed report: status %d, want %d", w.Code, http.StatusConflict)
+	}
+	earlier := agentReport{Host: "hard1", Time: first.Time.Add(-time.Second)}
+	if w := postAgentReport(t, key, earlier); w.Code != http.StatusConflict {
+		t.Errorf("older report: status %d, want %d", w.Code, http.StatusConflict)
+	}
+	later := agentReport{Host: "hard1", Time: first.Time.Add(time.Second)}
+	if w := postAgentReport(t, key, later); w.Code != http.StatusOK {
+		t.Errorf("newer report: status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestAgentReportReplayAfterRestart(t *testing.T) {
+	useConfig(t, agentTestConfig)
+	store, err := openBolt(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	series.mu.Lock()
+	series.store = store
+	series.mu.Unlock()
+	t.Cleanup(func() {
+		series.mu.Lock()
+		series.store = nil
+		series.mu.Unlock()
+		store.close()
+	})
+
+	agents = &agentRegistry{hosts: map[string]*agentHost{}}
+	key := agentKey("s3cret", "hard1")
+	rep := agentReport{Host: "hard1", Time: time.Now()}
+	if w := postAgentReport(t, key, rep); w.Code != http.StatusOK {
+		t.Fatalf("report: status %d: %s", w.Code, w.Body)
+	}
+	if err := saveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	agents = &agentRegistry{hosts: map[string]*agentHost{}}
+	if err := restoreState(); err != nil {
+		t.Fatal(err)
+	}
+	if w := postAgentReport(t, key, rep); w.Code != http.StatusConflict {
+		t.Errorf("report replayed after a restart: status %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestVerifyAgentReply(t *testing.T) {
+	key := agentKey("s3cret", "hard1")
+	rep := agentReport{Host: "hard1", Time: time.Now()}
+	body, _ := json.Marshal(agentReply{Time: rep.Time, Commands: []string{"uptime"}})
+	stale, _ := json.Marshal(agentReply{Time: rep.Time.Add(-time.Minute), Commands: []string{"rm -rf /"}})
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		wantErr   bool
+	}{
+		{"signed", body, signAgentBody(key, body), false},
+		{"unsigned", body, "", true},
+		{"not hex", body, "zz", true},
+		{"other key", body, signAgentBody(agentKey("s3cret", "hard2"), body), true},
+		{"tampered", append(bytes.Clone(body[:len(body)-1]), ' ', '}'), signAgentBody(key, body), true},
+		{"reply to another report", stale, signAgentBody(key, stale), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := verifyAgentReply(key, rep, tt.body, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			if err != nil && len(reply.Commands) > 0 {
+				t.Errorf("commands %v returned with the error", reply.Commands)
+			}
+		})
+	}
+}
+
+func TestRunAgentCommands(t *testing.T) {
+	useConfig(t, "")
+	commands := []string{"echo up", "exit 3"}
+	results := runAgentCommands(context.Background(), commands, nil)
+	if len(results) != 2 {
+		t.Fatalf("%d results, want 2", len(results))
+	}
+	if res := results[0]; res.Command != "echo up" || res.Output != "up\n" || res.Error != "" {
+		t.Errorf("result 0 = %+v, want the output", res)
+	}
+	if res := results[1]; res.Command != "exit 3" || res.ExitCode != 3 || res.Error == "" {
+		t.Errorf("result 1 = %+v, want exit code 3 and an error", res)
+	}
+}
+
+func TestRunAgentCommandsAllowlist(t *testing.T) {
+	useConfig(t, "")
+	commands := []string{"echo allowed", "echo refused"}
+	tests := []struct {
+		name    string
+		allowed []string
+		want    []string // the error of each result
+	}{
+		{"any command", nil, []string{"", ""}},
+		{"allowlist", []string{"echo allowed"}, []string{"", agentRefused}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := runAgentCommands(context.Background(), commands, tt.allowed)
+			for i, res := range results {
+				if res.Command != commands[i] || res.Error != tt.want[i] {
+					t.Errorf("result %d = %+v, want error %q", i, res, tt.want[i])
+				}
+				if res.Error == "" && res.Output != commands[i][len("echo "):]+"\n" {
+					t.Errorf("result %d output %q", i, res.Output)
+				}
+			}
+		})
+	}
+}
+
+func TestAgentAllowedCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"default", "customChecks:\n  - name: peers\n    command: \"peers.sh\"\n", []string{healthScript, "peers.sh"}},
+		{"listed", "agent:\n  commands: [uptime]\ncustomChecks:\n  - name: peers\n    command: \"peers.sh\"\n", []string{"uptime"}},
+		{"any command", "agent:\n  allowAnyCommand: true\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, tt.config)
+			if got := agentAllowedCommands(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("agentAllowedCommands() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityCritical:
+		return "CRITICAL"
+	default:
+		return "WARNING"
+	}
+}
+
+// Alert is a single finding produced by a check against one host.
+type Alert struct {
+	Host     string
+	Address  string
+	Check    string
+	Severity Severity
+	Message  string
+	Time     time.Time
+	Resolved bool
+	Source   string // system an alert received by webhook came from
+}
+
+// Key identifies the condition an alert is about, so repeats of the same
+// finding on later cycles can be matched up.
+func (a Alert) Key() string {
+	return a.Host + "/" + a.Check
+}
+
+func (a Alert) String() string {
+	state := a.Severity.String()
+	if a.Resolved {
+		state = "RESOLVED"
+	}
+	// Alerts about no host in particular, e.g. a digest of a whole group,
+	// have only the message.
+	if label := hostLabel(a.Host, a.Address); label != "" {
+		return fmt.Sprintf("[%s] %s: %s", state, label, a.Message)
+	}
+	return fmt.Sprintf("[%s] %s", state, a.Message)
+}
+
+// Text is the alert as sent to people: String followed by when it happened
+// in the given timezone.
+func (a Alert) Text(loc *time.Location) string {
+	return a.String() + "\n" + a.Time.In(loc).Format(timestampLayout)
+}
+
+// raiseAlert records an alert as active and delivers it unless it has been
+// acknowledged or silenced.
+func raiseAlert(a Alert) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	results.record(a.Host, a.Check, false, a.Message, a.Time)
+	if dep, failed := failedDependency(a); failed {
+		slog.Debug("Not alerting while a dependency is failing", "host", a.Host, "check", a.Check, "dependency", dep, "message", a.Message)
+		return
+	}
+	// Other systems wait before alerting themselves, and may not repeat an
+	// alert for hours.
+	if n, need := failures.fail(a.Key()), failuresRequired(a.Check); n < need && a.Source == "" {
+		if _, active := alerts.get(a.Key()); !active {
+			slog.Debug("Check failed, not alerting yet", "host", a.Host, "check", a.Check, "failures", n, "required", need, "message", a.Message)
+			return
+		}
+	}
+	isNew, suppressed := alerts.track(a)
+	if isNew {
+		slog.Warn("Alert raised", "host", a.Host, "check", a.Check, "severity", a.Severity.String(), "message", a.Message)
+		auditAlert("raised", a, "", "")
+		recordAlertEvent("fired", a, "")
+		incidents.raised(a)
+		publishAlert(a)
+		annotateAlert(a)
+		alertsRaised.WithLabelValues(a.Check, strings.ToLower(a.Severity.String())).Inc()
+		if flapping, started := flaps.record(a.Key(), a.Time); started {
+			notifyFlapping(a)
+		} else if flapping {
+			auditAlert("suppressed", a, "", "flapping")
+		}
+	}
+	if suppressed || flaps.isFlapping(a.Key()) {
+		return
+	}
+	if silences.silenced(a, a.Time) {
+		if isNew {
+			auditAlert("suppressed", a, "", "silenced")
+		}
+		return
+	}
+	deliver(a)
+}
+
+// clearAlert is called when a check passes. If an alert was active for it, a
+// RESOLVED notification referencing the original alert is sent.
+func clearAlert(host, check string) {
+	results.record(host, check, true, "", time.Now())
+	failures.reset(host + "/" + check)
+	aa, ok := alerts.clear(host + "/" + check)
+	if !ok {
+		incidents.passed(host, time.Now())
+		return
+	}
+
+	now := time.Now()
+	resolved := aa.Alert
+	resolved.Resolved = true
+	resolved.Time = now
+	resolved.Message = fmt.Sprintf("%s (%s since %s, lasted %s",
+		aa.Message, aa.Severity, localClock(aa.Since, displayLocation()), now.Sub(aa.Since).Round(time.Second))
+	if id, ok := incidents.resolved(host, now); ok {
+		resolved.Message += fmt.Sprintf(", incident #%d", id)
+	}
+	resolved.Message += ")"
+	slog.Info("Alert resolved", "host", host, "check", check, "severity", aa.Severity.String(), "duration", now.Sub(aa.Since).Round(time.Second))
+	auditAlert("resolved", resolved, "", "")
+	recordAlertEvent("resolved", resolved, "")
+	publishAlert(resolved)
+	annotateAlert(resolved)
+	if flapping, started := flaps.record(aa.Key(), now); started {
+		notifyFlapping(resolved)
+		return
+	} else if flapping {
+		return
+	}
+	if silences.silenced(resolved, now) {
+		return
+	}
+	deliver(resolved)
+
+	// Everyone who was paged through escalation hears about the recovery too.
+	chain := escalationChain()
+	for level := 0; level < aa.Level && level < len(chain); level++ {
+		for _, name := range chain[level].Channels {
+			ch, err := channelByName(name)
+			if err != nil {
+				slog.Error("Resolving alert", "host", aa.Host, "check", aa.Check, "err", err)
+				continue
+			}
+			err = notify(name, ch, resolved)
+			if err != nil {
+				slog.Error("Resolving alert failed", "host", aa.Host, "check", aa.Check, "channel", name, "err", err)
+			}
+			auditAlert("sent", resolved, name, errorResult(err))
+		}
+	}
+}
+
+// deliver sends critical alerts to the main chat immediately. Warnings are
+// queued for the morning digest during quiet hours and, when digest mode is
+// enabled, held back until the next digest flush.
+func deliver(a Alert) {
+	routeAlert(a)
+	if a.Severity >= SeverityCritical {
+		sendTelegramAlert(conf().GetInt64("telegramChatID"), conf().GetInt("telegramThreadID"), displayLocation(), a)
+		return
+	}
+	if primaryQuietHours.active(a.Time) {
+		quietQueue("").add(a)
+		auditAlert("queued", a, "quiet hours", "")
+		return
+	}
+	if !conf().GetBool("digest.enabled") {
+		sendTelegramAlert(conf().GetInt64("telegramChatID"), conf().GetInt("telegramThreadID"), displayLocation(), a)
+		return
+	}
+	digest.add(a)
+	auditAlert("queued", a, "digest", "")
+}
+
+type activeAlert struct {
+	Alert
+	Since time.Time
+	Acked bool
+	Level int // escalation steps already sent
+
+	SilencedUntil time.Time
+}
+
+// suppressed reports whether repeats and escalation are currently muted.
+func (aa *activeAlert) suppressed(now time.Time) bool {
+	return aa.Acked || now.Before(aa.SilencedUntil)
+}
+
+// failureCounter counts the consecutive failed samples of each check, so an
+// alert can wait for several bad samples in a row.
+type failureCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+var failures = &failureCounter{counts: map[string]int{}}
+
+func (c *failureCounter) fail(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[key]++
+	return c.counts[key]
+}
+
+func (c *failureCounter) reset(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.counts, key)
+}
+
+// failuresRequired is how many consecutive failures a check needs before it
+// alerts: consecutiveFailures.<check>, else consecutiveFailures.default,
+// else 1.
+func failuresRequired(check string) int {
+	key := "consecutiveFailures." + check
+	if !conf().IsSet(key) {
+		key = "consecutiveFailures.default"
+	}
+	if n := conf().GetInt(key); n > 1 {
+		return n
+	}
+	return 1
+}
+
+type alertStore struct {
+	mu     sync.Mutex
+	active map[string]*activeAlert
+}
+
+var alerts = &alertStore{active: map[string]*activeAlert{}}
+
+// track records an alert and reports whether it is new and whether it has
+// already been acknowledged or silenced.
+func (s *alertStore) track(a Alert) (isNew, suppressed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aa, ok := s.active[a.Key()]
+	if !ok {
+		aa = &activeAlert{Since: a.Time}
+		s.active[a.Key()] = aa
+	}
+	aa.Alert = a
+	return !ok, aa.suppressed(a.Time)
+}
+
+// restore puts back an alert saved by an earlier run.
+func (s *alertStore) restore(aa activeAlert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active[aa.Key()] = &aa
+}
+
+// replace makes the active alerts those of list, as saved by another
+// monitor.
+func (s *alertStore) replace(list []activeAlert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active = make(map[string]*activeAlert, len(list))
+	for i := range list {
+		s.active[list[i].Key()] = &list[i]
+	}
+}
+
+func (s *alertStore) get(key string) (activeAlert, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aa, ok := s.active[key]
+	if !ok {
+		return activeAlert{}, false
+	}
+	return *aa, true
+}
+
+func (s *alertStore) clear(key string) (activeAlert, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aa, ok := s.active[key]
+	if !ok {
+		return activeAlert{}, false
+	}
+	delete(s.active, key)
+	return *aa, true
+}
+
+func (s *alertStore) ack(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aa, ok := s.active[key]
+	if !ok {
+		return false
+	}
+	aa.Acked = true
+	return true
+}
+
+func (s *alertStore) silence(key string, until time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aa, ok := s.active[key]
+	if !ok {
+		return false
+	}
+	aa.SilencedUntil = until
+	return true
+}
+
+func (s *alertStore) list() []activeAlert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]activeAlert, 0, len(s.active))
+	for _, aa := range s.active {
+		list = append(list, *aa)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
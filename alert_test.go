@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestAlertString(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Alert
+		want string
+	}{
+		{"host", Alert{Host: "web1", Severity: SeverityCritical, Message: "down"}, "[CRITICAL] web1: down"},
+		{"address", Alert{Host: "web1", Address: "10.0.0.1", Message: "disk 91%"}, "[WARNING] web1 (10.0.0.1): disk 91%"},
+		{"resolved", Alert{Host: "web1", Message: "down", Resolved: true}, "[RESOLVED] web1: down"},
+		{"no host", Alert{Message: "3 alerts overnight"}, "[WARNING] 3 alerts overnight"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
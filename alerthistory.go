@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// alertRecord is an alert firing, resolving or being acknowledged, as kept
+// in the alert history.
+type alertRecord struct {
+	Time     time.Time       `json:"time"`
+	Event    string          `json:"event"` // fired, resolved or acked
+	Host     string          `json:"host"`
+	Check    string          `json:"check"`
+	Severity string          `json:"severity"`
+	Message  string          `json:"message"`
+	By       string          `json:"by,omitempty"` // who acknowledged it
+	Payload  json.RawMessage `json:"payload"`      // the alert as raised or resolved
+}
+
+// alertRetention is how long the alert history is kept:
+// history.alertRetention, default 90 days.
+func alertRetention() time.Duration {
+	if d := conf().GetDuration("history.alertRetention"); d > 0 {
+		return d
+	}
+	return 90 * 24 * time.Hour
+}
+
+// recordAlertEvent adds an alert event to the history.
+func recordAlertEvent(event string, a Alert, by string) {
+	store := series.storage()
+	if store == nil {
+		return
+	}
+	payload, err := json.Marshal(a)
+	if err != nil {
+		slog.Error("Error encoding alert", "host", a.Host, "check", a.Check, "err", err)
+		return
+	}
+	t := a.Time
+	if event == "acked" || t.IsZero() {
+		t = time.Now()
+	}
+	r := alertRecord{Time: t, Event: event, Host: a.Host, Check: a.Check, Severity: strings.ToLower(a.Severity.String()),
+		Message: a.Message, By: by, Payload: payload}
+	if err := store.addAlertEvent(r); err != nil {
+		slog.Error("Error recording alert", "host", a.Host, "check", a.Check, "err", err)
+	}
+}
+
+// recordAck adds the acknowledgement of an active alert to the history.
+func recordAck(key, by string) {
+	if aa, ok := alerts.get(key); ok {
+		recordAlertEvent("acked", aa.Alert, by)
+	}
+}
+
+// alertHistoryFilter selects alert events; zero fields match everything.
+type alertHistoryFilter struct {
+	host, check string
+	from, to    time.Time
+}
+
+func queryAlertHistory(f alertHistoryFilter) ([]alertRecord, error) {
+	store := series.storage()
+	if store == nil {
+		return nil, nil
+	}
+	return store.alertEvents(f)
+}
+
+// parseAlertHistoryFilter reads host, check, from and to (an RFC 3339 time
+// or a duration before now) from a request or the CLI flags.
+func parseAlertHistoryFilter(host, check, from, to string) (alertHistoryFilter, error) {
+	f := alertHistoryFilter{host: host, check: check}
+	now := time.Now()
+	for name, arg := range map[string]struct {
+		v string
+		t *time.Time
+	}{"from": {from, &f.from}, "to": {to, &f.to}} {
+		if arg.v == "" {
+			continue
+		}
+		t, err := parseTimeArg(arg.v, now)
+		if err != nil {
+			return f, fmt.Errorf("%s: %w", name, err)
+		}
+		*arg.t = t
+	}
+	return f, nil
+}
+
+// apiAlertHistoryHandler serves the alert history filtered by host, check,
+// from and to.
+func apiAlertHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAlertHistoryFilter(r.FormValue("host"), r.FormValue("check"), r.FormValue("from"), r.FormValue("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := queryAlertHistory(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if scope := requestScope(r); scope != nil {
+		visible := []alertRecord{}
+		for _, rec := range records {
+			if scope.allowsHost(rec.Host) {
+				visible = append(visible, rec)
+			}
+		}
+		records = visible
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// printAlertHistory writes alert events as a table in the display timezone.
+func printAlertHistory(w io.Writer, records []alertRecord) error {
+	loc := displayLocation()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tEVENT\tHOST\tCHECK\tSEVERITY\tMESSAGE")
+	for _, r := range records {
+		message := r.Message
+		if r.By != "" {
+			message = "by " + r.By
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.In(loc).Format("2006-01-02 15:04:05"), r.Event, r.Host, r.Check, r.Severity, message)
+	}
+	return tw.Flush()
+}
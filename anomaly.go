@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// anomalyMetrics are the metrics a baseline can be learned for, with the
+// name used in alerts.
+var anomalyMetrics = map[string]string{
+	"cpu":    "CPU usage",
+	"memory": "Memory usage",
+	"load1":  "Load",
+	"load5":  "Load (5m)",
+	"load15": "Load (15m)",
+	"net_rx": "Network receive",
+	"net_tx": "Network transmit",
+}
+
+func anomalyMetricNames() []string {
+	names := make([]string, 0, len(anomalyMetrics))
+	for name := range anomalyMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// anomalyCheck is the name of the alert for a metric deviating from its
+// baseline, e.g. cpu-anomaly.
+func anomalyCheck(metric string) string {
+	return metric + "-anomaly"
+}
+
+// anomalyConfig is the anomalies block: every host learns the mean and
+// standard deviation of each metric per hour of the day (in the display
+// timezone) from its history over window, and a warning is raised while a
+// value is more than deviations standard deviations away from the mean of
+// its hour.
+type anomalyConfig struct {
+	Metrics    []string
+	Window     time.Duration
+	Deviations float64
+	MinSamples int
+}
+
+func anomalySettings() (anomalyConfig, bool) {
+	if !conf().GetBool("anomalies.enabled") {
+		return anomalyConfig{}, false
+	}
+	cfg := anomalyConfig{
+		Metrics:    conf().GetStringSlice("anomalies.metrics"),
+		Window:     conf().GetDuration("anomalies.window"),
+		Deviations: conf().GetFloat64("anomalies.deviations"),
+		MinSamples: conf().GetInt("anomalies.minSamples"),
+	}
+	if len(cfg.Metrics) == 0 {
+		cfg.Metrics = []string{"cpu", "load1", "net_rx", "net_tx"}
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 7 * 24 * time.Hour
+	}
+	if cfg.Deviations <= 0 {
+		cfg.Deviations = 3
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 30
+	}
+	return cfg, true
+}
+
+// hourStats is the baseline of a metric in one hour of the day.
+type hourStats struct {
+	Count        int
+	Mean, StdDev float64
+}
+
+// baseline is a metric's statistics for each hour of the day.
+type baseline struct {
+	computed time.Time
+	hours    [24]hourStats
+}
+
+// baselines caches the learned baselines by host/metric; they are
+// recomputed from the history once an hour.
+var baselines = struct {
+	sync.Mutex
+	m map[string]baseline
+}{m: map[string]baseline{}}
+
+// learnBaseline computes the hourly statistics of a host's metric from the
+// samples between from and to. Hours are counted from the UTC offset of loc
+// at to.
+func learnBaseline(host, metric string, from, to time.Time, loc *time.Location) (baseline, error) {
+	b := baseline{computed: to}
+	store := series.storage()
+	if store == nil {
+		return b, nil
+	}
+	_, offset := to.In(loc).Zone()
+	var err error
+	b.hours, err = store.hourly(host, metric, from, to, offset)
+	return b, err
+}
+
+// baselineFor returns the cached baseline of a host's metric, learning it
+// again when it is more than an hour old. The latest hour is left out, so
+// an ongoing anomaly doesn't become part of its own baseline.
+func baselineFor(host, metric string, cfg anomalyConfig, now time.Time) baseline {
+	key := strings.ToLower(host) + "/" + metric
+	baselines.Lock()
+	b, ok := baselines.m[key]
+	baselines.Unlock()
+	if ok && now.Sub(b.computed) < time.Hour {
+		return b
+	}
+	to := now.Add(-time.Hour)
+	b, err := learnBaseline(host, metric, to.Add(-cfg.Window), to, displayLocation())
+	if err != nil {
+		slog.Error("Error learning baseline", "host", host, "metric", metric, "err", err)
+	}
+	b.computed = now
+	baselines.Lock()
+	baselines.m[key] = b
+	baselines.Unlock()
+	return b
+}
+
+// formatMetric formats a value of a metric for an alert.
+func formatMetric(metric string, v float64) string {
+	switch {
+	case metricNames[metric] != "":
+		return fmt.Sprintf("%.1f%%", v)
+	case strings.HasPrefix(metric, "net_"):
+		return formatBytes(v) + "/s"
+	default:
+		return formatValue(v)
+	}
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+func formatBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for math.Abs(v) >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return formatValue(v) + " " + units[i]
+}
+
+// checkAnomalies raises or clears the anomaly alerts of a host for its
+// latest values.
+func checkAnomalies(h Host, values map[string]float64) {
+	cfg, on := anomalySettings()
+	if !on {
+		return
+	}
+	now := time.Now()
+	hour := now.In(displayLocation()).Hour()
+	for _, metric := range cfg.Metrics {
+		check := anomalyCheck(metric)
+		v, ok := values[metric]
+		if !ok {
+			continue
+		}
+		if !checkEnabled(h, check) {
+			clearAlert(h.Name, check)
+			continue
+		}
+		s := baselineFor(h.Name, metric, cfg, now).hours[hour]
+		if s.Count < cfg.MinSamples {
+			continue
+		}
+		// A metric that barely moves would alert on any change, so the
+		// deviation is at least 5% of the mean.
+		stddev := math.Max(s.StdDev, math.Abs(s.Mean)*0.05)
+		if stddev == 0 || math.Abs(v-s.Mean) <= cfg.Deviations*stddev {
+			clearAlert(h.Name, check)
+			continue
+		}
+		direction := "above"
+		if v < s.Mean {
+			direction = "below"
+		}
+		raiseAlert(Alert{
+			Host:     h.Name,
+			Address:  h.Address,
+			Check:    check,
+			Severity: SeverityWarning,
+			Message: fmt.Sprintf("%s %s is %.1f standard deviations %s its usual %s ± %s at %02d:00",
+				anomalyMetrics[metric], formatMetric(metric, v), math.Abs(v-s.Mean)/stddev, direction,
+				formatMetric(metric, s.Mean), formatMetric(metric, s.StdDev), hour),
+		})
+	}
+}
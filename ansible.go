@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// ansibleInventory is the parsed form of an Ansible INI or YAML inventory.
+type ansibleInventory struct {
+	hosts    []string // in file order
+	hostVars map[string]map[string]string
+	groups   map[string]*ansibleGroup
+	order    []string // group names in file order
+}
+
+type ansibleGroup struct {
+	hosts    []string
+	vars     map[string]string
+	children []string
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{hostVars: map[string]map[string]string{}, groups: map[string]*ansibleGroup{}}
+}
+
+func (inv *ansibleInventory) group(name string) *ansibleGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &ansibleGroup{vars: map[string]string{}}
+		inv.groups[name] = g
+		inv.order = append(inv.order, name)
+	}
+	return g
+}
+
+func (inv *ansibleInventory) addHost(group, name string, vars map[string]string) {
+	if _, ok := inv.hostVars[name]; !ok {
+		inv.hosts = append(inv.hosts, name)
+		inv.hostVars[name] = map[string]string{}
+	}
+	for k, v := range vars {
+		inv.hostVars[name][k] = v
+	}
+	g := inv.group(group)
+	g.hosts = append(g.hosts, name)
+}
+
+// discoverAnsible reads the Ansible inventory at inventory.ansible.file.
+// Each inventory host becomes a host named after its inventory name, with
+// ansible_host, ansible_user, ansible_port and ansible_ssh_private_key_file
+// (from host or group vars) used for the ssh command. Its first group is the
+// host group and all groups it belongs to, including parent groups, are tags.
+func discoverAnsible(cfg *viper.Viper) ([]Host, error) {
+	path := cfg.GetString("file")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inv *ansibleInventory
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		inv, err = parseAnsibleYAML(data)
+	default:
+		inv, err = parseAnsibleINI(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	parents := map[string][]string{}
+	for name, g := range inv.groups {
+		for _, child := range g.children {
+			parents[child] = append(parents[child], name)
+		}
+	}
+	var depth func(group string, seen map[string]bool) int
+	depth = func(group string, seen map[string]bool) int {
+		if seen[group] {
+			return 0
+		}
+		seen[group] = true
+		d := 0
+		for _, p := range parents[group] {
+			if pd := depth(p, seen) + 1; pd > d {
+				d = pd
+			}
+		}
+		return d
+	}
+
+	var hosts []Host
+	for _, name := range inv.hosts {
+		// Groups the host is in, directly or through children, in file order.
+		var direct []string
+		for _, gname := range inv.order {
+			for _, hn := range inv.groups[gname].hosts {
+				if hn == name {
+					direct = append(direct, gname)
+					break
+				}
+			}
+		}
+		member := map[string]bool{}
+		var walk func(group string)
+		walk = func(group string) {
+			if member[group] {
+				return
+			}
+			member[group] = true
+			for _, p := range parents[group] {
+				walk(p)
+			}
+		}
+		for _, g := range direct {
+			walk(g)
+		}
+
+		// Variable precedence: all, then groups from parents to children,
+		// then the host's own vars.
+		groups := make([]string, 0, len(member))
+		for g := range member {
+			groups = append(groups, g)
+		}
+		sort.SliceStable(groups, func(i, j int) bool {
+			di, dj := depth(groups[i], map[string]bool{}), depth(groups[j], map[string]bool{})
+			if di != dj {
+				return di < dj
+			}
+			return groups[i] < groups[j]
+		})
+		vars := map[string]string{}
+		if all, ok := inv.groups["all"]; ok {
+			for k, v := range all.vars {
+				vars[k] = v
+			}
+		}
+		for _, g := range groups {
+			for k, v := range inv.groups[g].vars {
+				vars[k] = v
+			}
+		}
+		for k, v := range inv.hostVars[name] {
+			vars[k] = v
+		}
+
+		h := Host{Name: name, Address: vars["ansible_host"]}
+		if h.Address == "" {
+			h.Address = name
+		}
+		for _, g := range direct {
+			if g != "all" && g != "ungrouped" {
+				h.Group = g
+				break
+			}
+		}
+		for _, g := range groups {
+			if g != "all" && g != "ungrouped" {
+				h.Tags = append(h.Tags, g)
+			}
+		}
+		h.Command, err = hostCommand(cfg, h, map[string]string{
+			"User":         vars["ansible_user"],
+			"Port":         vars["ansible_port"],
+			"IdentityFile": vars["ansible_ssh_private_key_file"],
+		})
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// parseAnsibleINI parses the INI inventory format: host lines with inline
+// vars under [group] sections, plus [group:vars] and [group:children].
+func parseAnsibleINI(data []byte) (*ansibleInventory, error) {
+	inv := newAnsibleInventory()
+	section, kind := "ungrouped", ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section, kind, _ = strings.Cut(line[1:len(line)-1], ":")
+			inv.group(section)
+			continue
+		}
+
+		fields := splitAnsibleFields(line)
+		switch kind {
+		case "vars":
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value", lineNo)
+			}
+			inv.group(section).vars[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+		case "children":
+			g := inv.group(section)
+			g.children = append(g.children, fields[0])
+			inv.group(fields[0])
+		case "":
+			vars := map[string]string{}
+			for _, f := range fields[1:] {
+				key, value, ok := strings.Cut(f, "=")
+				if !ok {
+					return nil, fmt.Errorf("line %d: expected key=value, got %q", lineNo, f)
+				}
+				vars[key] = unquote(value)
+			}
+			names, err := expandHostPattern(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			for _, name := range names {
+				inv.addHost(section, name, vars)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown section type %q", lineNo, kind)
+		}
+	}
+	return inv, scanner.Err()
+}
+
+// splitAnsibleFields splits a host line on whitespace, keeping quoted values
+// together.
+func splitAnsibleFields(line string) []string {
+	var fields []string
+	var b strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			b.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			b.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if b.Len() > 0 {
+				fields = append(fields, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() > 0 {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+var hostRange = regexp.MustCompile(`\[([0-9]+):([0-9]+)\]`)
+
+// expandHostPattern expands a numeric range such as web[01:03].example.com.
+func expandHostPattern(pattern string) ([]string, error) {
+	m := hostRange.FindStringSubmatchIndex(pattern)
+	if m == nil {
+		return []string{pattern}, nil
+	}
+	startText, endText := pattern[m[2]:m[3]], pattern[m[4]:m[5]]
+	start, _ := strconv.Atoi(startText)
+	end, _ := strconv.Atoi(endText)
+	if end < start {
+		return nil, fmt.Errorf("invalid host range %q", pattern)
+	}
+	width := 0
+	if strings.HasPrefix(startText, "0") && len(startText) > 1 {
+		width = len(startText)
+	}
+	var names []string
+	for i := start; i <= end; i++ {
+		rest, err := expandHostPattern(pattern[m[1]:])
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rest {
+			names = append(names, fmt.Sprintf("%s%0*d%s", pattern[:m[0]], width, i, r))
+		}
+	}
+	return names, nil
+}
+
+type ansibleYAMLGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*ansibleYAMLGroup      `yaml:"children"`
+}
+
+// parseAnsibleYAML parses the YAML inventory format, where every group has
+// optional hosts, vars and children mappings.
+func parseAnsibleYAML(data []byte) (*ansibleInventory, error) {
+	var top map[string]*ansibleYAMLGroup
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	inv := newAnsibleInventory()
+	var add func(name string, g *ansibleYAMLGroup) error
+	add = func(name string, g *ansibleYAMLGroup) error {
+		group := inv.group(name)
+		if g == nil {
+			return nil
+		}
+		for k, v := range g.Vars {
+			group.vars[k] = cast.ToString(v)
+		}
+		hostNames := make([]string, 0, len(g.Hosts))
+		for h := range g.Hosts {
+			hostNames = append(hostNames, h)
+		}
+		sort.Strings(hostNames)
+		for _, pattern := range hostNames {
+			vars := map[string]string{}
+			for k, v := range g.Hosts[pattern] {
+				vars[k] = cast.ToString(v)
+			}
+			names, err := expandHostPattern(pattern)
+			if err != nil {
+				return err
+			}
+			for _, n := range names {
+				inv.addHost(name, n, vars)
+			}
+		}
+		for _, child := range sortedYAMLGroups(g.Children) {
+			group.children = append(group.children, child)
+			if err := add(child, g.Children[child]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range sortedYAMLGroups(top) {
+		if err := add(name, top[name]); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+func sortedYAMLGroups(groups map[string]*ansibleYAMLGroup) []string {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"checkhealth/client"
+)
+
+// checkResult is the outcome of the latest sample of one check on a host.
+// Stale is set by markStale for the status API.
+type checkResult struct {
+	Check   string    `json:"check"`
+	OK      bool      `json:"ok"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+	Stale   bool      `json:"stale,omitempty"`
+}
+
+// hostResults keeps the latest check results and health check values of
+// every host for the status API.
+type hostResults struct {
+	mu       sync.Mutex
+	checks   map[string]map[string]checkResult // host -> check -> result
+	values   map[string]map[string]float64
+	lastSeen map[string]time.Time
+}
+
+var results = &hostResults{
+	checks:   map[string]map[string]checkResult{},
+	values:   map[string]map[string]float64{},
+	lastSeen: map[string]time.Time{},
+}
+
+// record stores the result of a check in the history and exports it as
+// checkhealth_check_up.
+func (r *hostResults) record(host, check string, ok bool, message string, t time.Time) {
+	up := 0.0
+	if ok {
+		up = 1
+	}
+	checkUp.WithLabelValues(host, check).Set(up)
+	exportResult(host, check, ok, message, t)
+	series.recordResult(host, check, ok, message, t)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(host)
+	if r.checks[key] == nil {
+		r.checks[key] = map[string]checkResult{}
+	}
+	previous, seen := r.checks[key][check]
+	r.checks[key][check] = checkResult{Check: check, OK: ok, Message: message, Time: t}
+	if !seen || previous.OK != ok || previous.Message != message {
+		events.publish("result", host, struct {
+			Host string `json:"host"`
+			checkResult
+		}{host, r.checks[key][check]})
+	}
+}
+
+// sample stores the values of a host's latest successful health check and
+// adds them to the metric history.
+func (r *hostResults) sample(host string, values map[string]float64) {
+	now := time.Now()
+	for name, v := range values {
+		series.record(host, name, v, now)
+	}
+
+	events.publish("sample", host, map[string]interface{}{"host": host, "time": now, "values": values})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(host)
+	r.values[key] = values
+	r.lastSeen[key] = now
+}
+
+// list returns the latest result of each check of a host.
+func (r *hostResults) list(host string) []checkResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []checkResult{}
+	for _, c := range r.checks[strings.ToLower(host)] {
+		list = append(list, c)
+	}
+	return list
+}
+
+// restore sets a check result loaded from the history.
+func (r *hostResults) restore(host string, c checkResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(host)
+	if r.checks[key] == nil {
+		r.checks[key] = map[string]checkResult{}
+	}
+	r.checks[key][c.Check] = c
+}
+
+// restoreValue sets a health check value loaded from the history.
+func (r *hostResults) restoreValue(host, metric string, value float64, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(host)
+	if r.values[key] == nil {
+		r.values[key] = map[string]float64{}
+	}
+	r.values[key][metric] = value
+	if t.After(r.lastSeen[key]) {
+		r.lastSeen[key] = t
+	}
+}
+
+// change describes how far a percentage moved since the host's previous
+// health check, e.g. " (+4% in 10m)", or is empty when it didn't move a
+// whole point or there was no previous check. It is called before the new
+// values are stored with sample.
+func (r *hostResults) change(host, metric string, value float64, now time.Time) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(host)
+	previous, ok := r.values[key][metric]
+	if !ok || math.Abs(value-previous) < 0.5 {
+		return ""
+	}
+	ago := now.Sub(r.lastSeen[key]).Round(time.Second)
+	if ago <= 0 {
+		return ""
+	}
+	// 10m0s reads as 10m and 2h0m0s as 2h.
+	text := ago.String()
+	if ago >= time.Minute {
+		ago = ago.Round(time.Minute)
+		text = strings.TrimSuffix(ago.String(), "0s")
+	}
+	if ago >= time.Hour {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return fmt.Sprintf(" (%+.0f%% in %s)", value-previous, text)
+}
+
+// hostStatus is a host as returned by /api/v1/hosts.
+type hostStatus struct {
+	Name       string               `json:"name"`
+	Address    string               `json:"address,omitempty"`
+	Group      string               `json:"group,omitempty"`
+	Tags       []string             `json:"tags,omitempty"`
+	Agent      bool                 `json:"agent,omitempty"`
+	LastSeen   *time.Time           `json:"lastSeen,omitempty"`
+	Values     map[string]float64   `json:"values,omitempty"`
+	Checks     []checkResult        `json:"checks"`
+	Thresholds map[string]Threshold `json:"thresholds"`
+	Alerts     []hostAlert          `json:"alerts"`
+}
+
+// hostAlert is an active alert of a host in /api/v1/hosts.
+type hostAlert struct {
+	Check         string     `json:"check"`
+	Severity      string     `json:"severity"`
+	Message       string     `json:"message"`
+	Since         time.Time  `json:"since"`
+	Acked         bool       `json:"acked"`
+	SilencedUntil *time.Time `json:"silencedUntil,omitempty"`
+}
+
+func (r *hostResults) status(h Host, active []activeAlert) hostStatus {
+	s := hostStatus{
+		Name:       h.Name,
+		Address:    h.Address,
+		Group:      h.Group,
+		Tags:       h.Tags,
+		Agent:      h.Agent,
+		Checks:     []checkResult{},
+		Thresholds: thresholdsFor(h),
+		Alerts:     []hostAlert{},
+	}
+	r.mu.Lock()
+	key := strings.ToLower(h.Name)
+	if t, ok := r.lastSeen[key]; ok {
+		s.LastSeen = &t
+	}
+	s.Values = r.values[key]
+	r.mu.Unlock()
+	s.Checks = r.list(h.Name)
+	markStale(h, s.Checks, time.Time{}, time.Now())
+	sort.Slice(s.Checks, func(i, j int) bool { return s.Checks[i].Check < s.Checks[j].Check })
+
+	for _, aa := range active {
+		if strings.EqualFold(aa.Host, h.Name) {
+			a := hostAlert{
+				Check:    aa.Check,
+				Severity: strings.ToLower(aa.Severity.String()),
+				Message:  aa.Message,
+				Since:    aa.Since,
+				Acked:    aa.Acked,
+			}
+			if time.Now().Before(aa.SilencedUntil) {
+				a.SilencedUntil = &aa.SilencedUntil
+			}
+			s.Alerts = append(s.Alerts, a)
+		}
+	}
+	return s
+}
+
+// openAPIHandler serves the OpenAPI specification of the API, shipped with
+// the Go client.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(client.Spec)
+}
+
+func apiHostsHandler(w http.ResponseWriter, r *http.Request) {
+	active := alerts.list()
+	scope := requestScope(r)
+	list := []hostStatus{}
+	for _, h := range configuredHosts() {
+		if scope.allows(h.Group) {
+			list = append(list, results.status(h, active))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func apiHostHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := scopedHost(r)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no host %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results.status(h, alerts.list()))
+}
+
+// apiCheckHostHandler runs every enabled check of one host right away,
+// regardless of its schedule, and returns the host's status afterwards.
+func apiCheckHostHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := scopedHost(r)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no host %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	slog.Info("Running checks on request", "host", h.Name, "remote", r.RemoteAddr)
+	// The same checks as the scheduler runs: every check type, then the
+	// custom checks with a schedule of their own.
+	names := make([]string, 0, len(checkRunners))
+	for name := range checkRunners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		runRecovered(r.Context(), h, name, checkRunners[name])
+	}
+	for _, c := range customChecks() {
+		if c.Schedule != "" && c.appliesTo(h) {
+			runRecovered(r.Context(), h, c.Name, c.run)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results.status(h, alerts.list()))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEvent is one line of the alert audit log: an alert being raised,
+// suppressed, queued, delivered (or not), escalated, acknowledged or
+// resolved.
+type auditEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Alert    string    `json:"alert,omitempty"`
+	Severity string    `json:"severity,omitempty"`
+	Channel  string    `json:"channel,omitempty"`
+	Result   string    `json:"result,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+func auditPath() string {
+	return conf().GetString("audit.file")
+}
+
+func recordAudit(e auditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	f, err := os.OpenFile(auditPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.Error("Error opening audit log", "err", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(e); err != nil {
+		slog.Error("Error writing audit log", "err", err)
+	}
+}
+
+// auditAlert records an event about a specific alert.
+func auditAlert(event string, a Alert, channel, result string) {
+	recordAudit(auditEvent{
+		Event:    event,
+		Alert:    a.Key(),
+		Severity: a.Severity.String(),
+		Channel:  channel,
+		Result:   result,
+		Message:  a.Message,
+	})
+}
+
+// errorResult is the audit result of a delivery. The error is recorded
+// without the bot token, which a failed Telegram request carries.
+func errorResult(err error) string {
+	if err != nil {
+		return "failed: " + redactToken(err)
+	}
+	return "ok"
+}
+
+type auditFilter struct {
+	host, alert, event string
+	since, until       time.Time
+}
+
+func (f auditFilter) matches(e auditEvent) bool {
+	if f.host != "" && !strings.HasPrefix(e.Alert, f.host+"/") {
+		return false
+	}
+	if f.alert != "" && e.Alert != f.alert {
+		return false
+	}
+	if f.event != "" && e.Event != f.event {
+		return false
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && e.Time.After(f.until) {
+		return false
+	}
+	return true
+}
+
+func queryAudit(f auditFilter) ([]auditEvent, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	file, err := os.Open(auditPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []auditEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e auditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if f.matches(e) {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// auditHandler serves the audit log filtered by alert (host/check), event,
+// and an RFC 3339 since/until range.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	f := auditFilter{alert: r.FormValue("alert"), event: r.FormValue("event")}
+	for name, t := range map[string]*time.Time{"since": &f.since, "until": &f.until} {
+		if v := r.FormValue(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, name+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	events, err := queryAudit(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if scope := requestScope(r); scope != nil {
+		var visible []auditEvent
+		for _, e := range events {
+			if host, _, ok := strings.Cut(e.Alert, "/"); ok && scope.allowsHost(host) {
+				visible = append(visible, e)
+			}
+		}
+		events = visible
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// publicPaths are served without authentication or IP allowlist, so probes
+// keep working. The status page is added when it's enabled.
+var publicPaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// parseAllowIP parses an http.allowIPs entry: an address or a CIDR range.
+func parseAllowIP(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", s)
+		}
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 128
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+// ipAllowed reports whether the client of r is in http.allowIPs, if set.
+func ipAllowed(r *http.Request) bool {
+	allowed := conf().GetStringSlice("http.allowIPs")
+	if len(allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, s := range allowed {
+		if ipnet, err := parseAllowIP(s); err == nil && ip != nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalSecret(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authScope is an entry of http.auth.scoped: a bearer token, or a user and
+// password, that may only see and act on the hosts of some groups.
+type authScope struct {
+	Token    string
+	User     string
+	Password string
+	Groups   []string
+}
+
+func authScopes() []authScope {
+	return authScopesIn(conf())
+}
+
+func authScopesIn(cfg *viper.Viper) []authScope {
+	var scopes []authScope
+	if err := cfg.UnmarshalKey("http.auth.scoped", &scopes); err != nil {
+		slog.Error("Error reading http.auth.scoped", "err", err)
+	}
+	return scopes
+}
+
+// groupScope lists the host groups a request may access; nil means all.
+type groupScope []string
+
+func (s groupScope) allows(group string) bool {
+	if s == nil {
+		return true
+	}
+	for _, g := range s {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHost looks up a host named in an alert, silence or event, including
+// hosts only known from received alerts.
+func (s groupScope) allowsHost(name string) bool {
+	if s == nil {
+		return true
+	}
+	h, ok := hostByName(name)
+	if !ok {
+		h, ok = externalHost(name)
+	}
+	return ok && s.allows(h.Group)
+}
+
+type scopeKey struct{}
+
+// requestScope is the scope requireAuth granted to r.
+func requestScope(r *http.Request) groupScope {
+	s, _ := r.Context().Value(scopeKey{}).(groupScope)
+	return s
+}
+
+// scopedHost looks up the host named in the path of r, treating hosts
+// outside the request's scope as missing.
+func scopedHost(r *http.Request) (Host, bool) {
+	h, ok := hostByName(r.PathValue("name"))
+	if !ok || !requestScope(r).allows(h.Group) {
+		return Host{}, false
+	}
+	return h, true
+}
+
+// scopedPaths are what scoped tokens and users may access. The handlers
+// filter what they return by the request's scope.
+var scopedPaths = []string{"/alerts", "/silences", "/audit", "/groups", "/api/openapi.json", "/api/v1/alerts", "/api/v1/hosts", "/api/v1/events", "/api/v1/incidents", "/api/v1/sla", "/dashboard"}
+
+// authenticate checks that r carries one of http.auth.tokens as a bearer
+// token or the credentials of one of http.auth.users, which may access
+// everything, or those of an http.auth.scoped entry, which may only access
+// its groups. Without any configured every request is accepted.
+func authenticate(r *http.Request) (groupScope, bool) {
+	tokens := conf().GetStringSlice("http.auth.tokens")
+	users := conf().GetStringMapString("http.auth.users")
+	scopes := authScopes()
+	if len(tokens) == 0 && len(users) == 0 && len(scopes) == 0 {
+		return nil, true
+	}
+	token, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	user, password, hasUser := r.BasicAuth()
+	if hasToken {
+		for _, t := range tokens {
+			if t != "" && equalSecret(token, t) {
+				return nil, true
+			}
+		}
+	}
+	if hasUser {
+		// viper lower-cases map keys, so user names are case-insensitive.
+		if p, exists := users[strings.ToLower(user)]; exists && p != "" && equalSecret(password, p) {
+			return nil, true
+		}
+	}
+	for _, s := range scopes {
+		switch {
+		case hasToken && s.Token != "" && equalSecret(token, s.Token),
+			hasUser && s.User != "" && s.Password != "" && strings.EqualFold(user, s.User) && equalSecret(password, s.Password):
+			return groupScope(append([]string{}, s.Groups...)), true
+		}
+	}
+	return nil, false
+}
+
+// requireAuth protects every endpoint but the probes with the IP allowlist
+// and the tokens or users of http.auth.
+func requireAuth(next http.Handler) http.Handler {
+	if !conf().IsSet("http.auth") {
+		slog.Warn("HTTP API is unauthenticated, set http.auth to protect it")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ipAllowed(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		scope, ok := authenticate(r)
+		if !ok {
+			if len(conf().GetStringMapString("http.auth.users")) > 0 || len(authScopes()) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="checkhealth"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if scope != nil {
+			allowed := false
+			for _, p := range scopedPaths {
+				allowed = allowed || r.URL.Path == p || strings.HasPrefix(r.URL.Path, p+"/")
+			}
+			if !allowed {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), scopeKey{}, scope))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
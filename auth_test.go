@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const authTestConfig = `
+hosts:
+  - name: val1
+    command: "true"
+    group: validators
+  - name: rpc1
+    command: "true"
+    group: rpc
+http:
+  auth:
+    tokens: ["admin-token"]
+    users:
+      ops: "ops-password"
+    scoped:
+      - token: "val-token"
+        groups: ["validators"]
+      - user: "partner"
+        password: "partner-password"
+        groups: ["RPC"]
+`
+
+func TestRequireAuth(t *testing.T) {
+	useConfig(t, authTestConfig)
+	var scope groupScope
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		scope = requestScope(r)
+	})
+	mux.HandleFunc("/api/v1/hosts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		scope = requestScope(r)
+		if _, ok := scopedHost(r); !ok {
+			http.NotFound(w, r)
+		}
+	})
+	handler := requireAuth(mux)
+
+	tests := []struct {
+		name      string
+		path      string
+		token     string
+		user      string
+		password  string
+		wantCode  int
+		wantScope string // the groups, comma-separated; "" for all
+	}{
+		{name: "probe", path: "/healthz", wantCode: http.StatusOK},
+		{name: "no credentials", path: "/api/v1/hosts", wantCode: http.StatusUnauthorized},
+		{name: "admin token", path: "/api/v1/monitor", token: "admin-token", wantCode: http.StatusOK},
+		{name: "wrong token", path: "/api/v1/hosts", token: "admin", wantCode: http.StatusUnauthorized},
+		{name: "user", path: "/api/v1/monitor", user: "ops", password: "ops-password", wantCode: http.StatusOK},
+		{name: "user name case", path: "/api/v1/monitor", user: "OPS", password: "ops-password", wantCode: http.StatusOK},
+		{name: "wrong password", path: "/api/v1/hosts", user: "ops", password: "ops", wantCode: http.StatusUnauthorized},
+		{name: "scoped token", path: "/api/v1/hosts", token: "val-token", wantCode: http.StatusOK, wantScope: "validators"},
+		{name: "scoped token, host in scope", path: "/api/v1/hosts/val1", token: "val-token", wantCode: http.StatusOK, wantScope: "validators"},
+		{name: "scoped token, host out of scope", path: "/api/v1/hosts/rpc1", token: "val-token", wantCode: http.StatusNotFound, wantScope: "validators"},
+		{name: "scoped token, unscoped path", path: "/api/v1/monitor", token: "val-token", wantCode: http.StatusForbidden},
+		{name: "scoped user", path: "/api/v1/hosts/rpc1", user: "partner", password: "partner-password", wantCode: http.StatusOK, wantScope: "RPC"},
+		{name: "scoped user, wrong password", path: "/api/v1/hosts", user: "partner", password: "ops-password", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope = groupScope{"unset"}
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Code == http.StatusOK && tt.path != "/healthz" {
+				if got := strings.Join(scope, ","); got != tt.wantScope {
+					t.Errorf("scope %q, want %q", got, tt.wantScope)
+				}
+			}
+		})
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	useConfig(t, `
+http:
+  allowIPs: ["10.1.0.0/16", "2001:db8::1"]
+`)
+	tests := []struct {
+		remote string
+		want   bool
+	}{
+		{"10.1.2.3:40000", true},
+		{"10.2.0.1:40000", false},
+		{"[2001:db8::1]:40000", true},
+		{"[2001:db8::2]:40000", false},
+		{"not an address", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/alerts", nil)
+		r.RemoteAddr = tt.remote
+		if got := ipAllowed(r); got != tt.want {
+			t.Errorf("ipAllowed(%q) = %v, want %v", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticateWithoutAuth(t *testing.T) {
+	useConfig(t, "")
+	scope, ok := authenticate(httptest.NewRequest(http.MethodGet, "/api/v1/hosts", nil))
+	if !ok || scope != nil {
+		t.Fatalf("authenticate = %v, %v, want everything allowed", scope, ok)
+	}
+}
+
+func TestGroupScopeAllowsHost(t *testing.T) {
+	useConfig(t, authTestConfig)
+	tests := []struct {
+		scope groupScope
+		host  string
+		want  bool
+	}{
+		{nil, "val1", true},
+		{nil, "unknown", true},
+		{groupScope{"validators"}, "val1", true},
+		{groupScope{"Validators"}, "VAL1", true},
+		{groupScope{"validators"}, "rpc1", false},
+		{groupScope{"validators"}, "unknown", false},
+		{groupScope{}, "val1", false},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.allowsHost(tt.host); got != tt.want {
+			t.Errorf("%v.allowsHost(%q) = %v, want %v", tt.scope, tt.host, got, tt.want)
+		}
+	}
+}
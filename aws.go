@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+type ec2Instance struct {
+	InstanceId       string
+	PrivateIpAddress string
+	PublicIpAddress  string
+	VpcId            string
+	Tags             []struct{ Key, Value string }
+}
+
+// discoverEC2 lists running instances matching inventory.aws.filters using
+// the aws CLI, so the usual credential chain (profiles, SSO, instance roles)
+// applies.
+func discoverEC2(cfg *viper.Viper) ([]Host, error) {
+	args := []string{"ec2", "describe-instances", "--output", "json"}
+	if region := cfg.GetString("region"); region != "" {
+		args = append(args, "--region", region)
+	}
+	if profile := cfg.GetString("profile"); profile != "" {
+		args = append(args, "--profile", profile)
+	}
+	filters := []string{"Name=instance-state-name,Values=running"}
+	configured := cfg.GetStringMapString("filters")
+	var names []string
+	for name := range configured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		filters = append(filters, fmt.Sprintf("Name=%s,Values=%s", name, configured[name]))
+	}
+	// Tag keys are case-sensitive but viper lower-cases map keys, so tag
+	// filters are given as "Key=value" strings instead.
+	for _, f := range cfg.GetStringSlice("tagFilters") {
+		key, value, _ := strings.Cut(f, "=")
+		filters = append(filters, fmt.Sprintf("Name=tag:%s,Values=%s", key, value))
+	}
+	args = append(args, "--filters")
+	args = append(args, filters...)
+
+	out, err := exec.Command("aws", args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("aws ec2 describe-instances: %s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, err
+	}
+
+	var resp struct {
+		Reservations []struct {
+			Instances []ec2Instance
+		}
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("aws ec2 describe-instances: %w", err)
+	}
+
+	nameTag := cfg.GetString("nameTag")
+	if nameTag == "" {
+		nameTag = "Name"
+	}
+	groupTag := cfg.GetString("groupTag")
+
+	var hosts []Host
+	for _, r := range resp.Reservations {
+		for _, inst := range r.Instances {
+			h := Host{Name: inst.InstanceId, Address: inst.PrivateIpAddress}
+			if cfg.GetBool("usePublicIP") {
+				h.Address = inst.PublicIpAddress
+			}
+			if h.Address == "" {
+				continue
+			}
+			for _, t := range inst.Tags {
+				switch {
+				case t.Key == nameTag && t.Value != "":
+					h.Name = t.Value
+				case t.Key == groupTag:
+					h.Group = t.Value
+				default:
+					h.Tags = append(h.Tags, t.Key+"="+t.Value)
+				}
+			}
+			if inst.VpcId != "" {
+				h.Tags = append(h.Tags, "vpc="+inst.VpcId)
+			}
+			if h.Command, err = discoveredHostCommand(cfg, h); err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts, nil
+}
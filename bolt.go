@@ -0,0 +1,737 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore keeps the history in a bbolt file. The samples of each tier are
+// in buckets by host (in lower case) and metric, keyed by the big-endian
+// Unix millisecond time, and the check results likewise by host and check.
+// The alert history and host events are keyed by time and a sequence
+// number, the incidents by ID. The hosts bucket maps the lower-case host
+// names to how they were written.
+type boltStore struct {
+	db *bolt.DB
+}
+
+var boltBuckets = []string{"hosts", "samples", "samples_5m", "samples_1h", "results", "alert_history", "host_events", "incidents", "state", "leases"}
+
+var sampleTiers = []string{"samples", "samples_5m", "samples_1h"}
+
+func openBolt(path string) (*boltStore, error) {
+	// The file is locked while open, so a second process fails instead of
+	// waiting for the monitor to exit.
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use, stop the monitor first", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func timeKey(t time.Time) []byte {
+	return msKey(t.UnixMilli())
+}
+
+func msKey(ms int64) []byte {
+	if ms < 0 {
+		ms = 0
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(ms))
+	return b
+}
+
+func keyMs(k []byte) int64 {
+	return int64(binary.BigEndian.Uint64(k[:8]))
+}
+
+// nested returns the bucket at the path of names, or nil.
+func nested(tx *bolt.Tx, names ...string) *bolt.Bucket {
+	b := tx.Bucket([]byte(names[0]))
+	for _, name := range names[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket([]byte(name))
+	}
+	return b
+}
+
+// createNested returns the bucket at the path of names below a top-level
+// bucket, creating it if needed.
+func createNested(tx *bolt.Tx, names ...string) (*bolt.Bucket, error) {
+	b := tx.Bucket([]byte(names[0]))
+	for _, name := range names[1:] {
+		var err error
+		if b, err = b.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// subBuckets calls fn with the name and bucket of every bucket in b.
+func subBuckets(b *bolt.Bucket, fn func(name string, b *bolt.Bucket) error) error {
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return fn(string(k), b.Bucket(k))
+	})
+}
+
+// hostKey is the bucket name of a host, remembering how it was written.
+func hostKey(tx *bolt.Tx, host string) (string, error) {
+	key := strings.ToLower(host)
+	hosts := tx.Bucket([]byte("hosts"))
+	if hosts.Get([]byte(key)) == nil {
+		if err := hosts.Put([]byte(key), []byte(host)); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+func hostName(tx *bolt.Tx, key string) string {
+	if name := tx.Bucket([]byte("hosts")).Get([]byte(key)); name != nil {
+		return string(name)
+	}
+	return key
+}
+
+// aggregate is a sample or a rollup bucket of samples. Raw samples are
+// stored as their value alone.
+type aggregate struct {
+	avg, min, max float64
+	count         int64
+}
+
+func decodeAggregate(v []byte) aggregate {
+	f := func(i int) float64 { return math.Float64frombits(binary.BigEndian.Uint64(v[i*8:])) }
+	if len(v) < 32 {
+		return aggregate{f(0), f(0), f(0), 1}
+	}
+	return aggregate{f(0), f(1), f(2), int64(binary.BigEndian.Uint64(v[24:]))}
+}
+
+func (a aggregate) encode() []byte {
+	b := make([]byte, 32)
+	binary.BigEndian.PutUint64(b, math.Float64bits(a.avg))
+	binary.BigEndian.PutUint64(b[8:], math.Float64bits(a.min))
+	binary.BigEndian.PutUint64(b[16:], math.Float64bits(a.max))
+	binary.BigEndian.PutUint64(b[24:], uint64(a.count))
+	return b
+}
+
+func (a aggregate) merge(o aggregate) aggregate {
+	if a.count == 0 {
+		return o
+	}
+	total := a.count + o.count
+	return aggregate{
+		avg:   (a.avg*float64(a.count) + o.avg*float64(o.count)) / float64(total),
+		min:   math.Min(a.min, o.min),
+		max:   math.Max(a.max, o.max),
+		count: total,
+	}
+}
+
+// scan calls fn for the keys of b from from up to before to; zero times
+// are unbounded.
+func scan(b *bolt.Bucket, from, to time.Time, fn func(ms int64, v []byte) error) error {
+	if b == nil {
+		return nil
+	}
+	c := b.Cursor()
+	k, v := c.First()
+	if !from.IsZero() {
+		k, v = c.Seek(timeKey(from))
+	}
+	for ; k != nil; k, v = c.Next() {
+		ms := keyMs(k)
+		if !to.IsZero() && ms >= to.UnixMilli() {
+			break
+		}
+		if err := fn(ms, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) addSample(host, metric string, value float64, t time.Time) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		key, err := hostKey(tx, host)
+		if err != nil {
+			return err
+		}
+		b, err := createNested(tx, "samples", key, metric)
+		if err != nil {
+			return err
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, math.Float64bits(value))
+		return b.Put(timeKey(t), v)
+	})
+}
+
+func (s *boltStore) addResult(host string, r checkResult) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		key, err := hostKey(tx, host)
+		if err != nil {
+			return err
+		}
+		b, err := createNested(tx, "results", key, r.Check)
+		if err != nil {
+			return err
+		}
+		v := []byte{0}
+		if r.OK {
+			v[0] = 1
+		}
+		return b.Put(timeKey(r.Time), append(v, r.Message...))
+	})
+}
+
+func (s *boltStore) points(host, metric string, from time.Time) ([]point, error) {
+	var points []point
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, tier := range sampleTiers {
+			err := scan(nested(tx, tier, strings.ToLower(host), metric), from, time.Time{}, func(ms int64, v []byte) error {
+				points = append(points, point{Time: time.UnixMilli(ms), Value: decodeAggregate(v).avg})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, err
+}
+
+func (s *boltStore) metrics(host string) ([]string, error) {
+	seen := map[string]bool{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, tier := range sampleTiers {
+			subBuckets(nested(tx, tier, strings.ToLower(host)), func(name string, _ *bolt.Bucket) error {
+				seen[name] = true
+				return nil
+			})
+		}
+		return nil
+	})
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, err
+}
+
+func (s *boltStore) stats(metric string, from, to time.Time) ([]metricStats, error) {
+	type acc struct {
+		metricStats
+		sum         float64
+		first, last int64
+	}
+	byHost := map[string]*acc{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, tier := range sampleTiers {
+			err := subBuckets(tx.Bucket([]byte(tier)), func(key string, hb *bolt.Bucket) error {
+				return scan(hb.Bucket([]byte(metric)), from, to, func(ms int64, v []byte) error {
+					ag := decodeAggregate(v)
+					a, ok := byHost[key]
+					if !ok {
+						a = &acc{metricStats: metricStats{Host: hostName(tx, key), Min: ag.min, Max: ag.max}, first: ms, last: ms}
+						a.First, a.Last = ag.avg, ag.avg
+						byHost[key] = a
+					}
+					a.Count += int(ag.count)
+					a.sum += ag.avg * float64(ag.count)
+					a.Min, a.Max = math.Min(a.Min, ag.min), math.Max(a.Max, ag.max)
+					if ms < a.first {
+						a.first, a.First = ms, ag.avg
+					}
+					if ms >= a.last {
+						a.last, a.Last = ms, ag.avg
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	keys := make([]string, 0, len(byHost))
+	for key := range byHost {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var list []metricStats
+	for _, key := range keys {
+		a := byHost[key]
+		a.Avg = a.sum / float64(a.Count)
+		list = append(list, a.metricStats)
+	}
+	return list, err
+}
+
+func (s *boltStore) hourly(host, metric string, from, to time.Time, offset int) ([24]hourStats, error) {
+	var counts [24]int
+	var sums, squares [24]float64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scan(nested(tx, "samples", strings.ToLower(host), metric), from, to, func(ms int64, v []byte) error {
+			value := decodeAggregate(v).avg
+			hour := hourOfDay(ms, offset)
+			counts[hour]++
+			sums[hour] += value
+			squares[hour] += value * value
+			return nil
+		})
+	})
+	var hours [24]hourStats
+	for h := range hours {
+		if n := counts[h]; n > 0 {
+			hours[h] = newHourStats(n, sums[h]/float64(n), squares[h]/float64(n))
+		}
+	}
+	return hours, err
+}
+
+func (s *boltStore) size() (int, error) {
+	n := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return subBuckets(tx.Bucket([]byte("samples")), func(_ string, hb *bolt.Bucket) error {
+			return subBuckets(hb, func(_ string, mb *bolt.Bucket) error {
+				n += mb.Stats().KeyN
+				return nil
+			})
+		})
+	})
+	return n, err
+}
+
+func decodeResult(host, check string, ms int64, v []byte) hostResult {
+	return hostResult{Host: host, checkResult: checkResult{Check: check, OK: v[0] == 1, Message: string(v[1:]), Time: time.UnixMilli(ms)}}
+}
+
+// eachResults calls fn with the host name, check and bucket of the results
+// of every host and check.
+func eachResults(tx *bolt.Tx, fn func(host, check string, b *bolt.Bucket) error) error {
+	return subBuckets(tx.Bucket([]byte("results")), func(key string, hb *bolt.Bucket) error {
+		host := hostName(tx, key)
+		return subBuckets(hb, func(check string, b *bolt.Bucket) error {
+			return fn(host, check, b)
+		})
+	})
+}
+
+func (s *boltStore) latestResults(from, to time.Time) ([]hostResult, error) {
+	var list []hostResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return eachResults(tx, func(host, check string, b *bolt.Bucket) error {
+			c := b.Cursor()
+			var k, v []byte
+			if to.IsZero() {
+				k, v = c.Last()
+			} else if k, v = c.Seek(timeKey(to)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+			if k == nil || !from.IsZero() && keyMs(k) < from.UnixMilli() {
+				return nil
+			}
+			list = append(list, decodeResult(host, check, keyMs(k), v))
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (s *boltStore) results(from, to time.Time) ([]hostResult, error) {
+	var list []hostResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return eachResults(tx, func(host, check string, b *bolt.Bucket) error {
+			return scan(b, from, to, func(ms int64, v []byte) error {
+				list = append(list, decodeResult(host, check, ms, v))
+				return nil
+			})
+		})
+	})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list, err
+}
+
+func (s *boltStore) latestValues(metrics []string) ([]hostSample, error) {
+	var list []hostSample
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return subBuckets(tx.Bucket([]byte("samples")), func(key string, hb *bolt.Bucket) error {
+			for _, metric := range metrics {
+				b := hb.Bucket([]byte(metric))
+				if b == nil {
+					continue
+				}
+				if k, v := b.Cursor().Last(); k != nil {
+					list = append(list, hostSample{Host: hostName(tx, key), Metric: metric,
+						point: point{Time: time.UnixMilli(keyMs(k)), Value: decodeAggregate(v).avg}})
+				}
+			}
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (s *boltStore) rollup(tiers []rollupTier, now time.Time) (int64, error) {
+	var rolled int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, t := range tiers {
+			cutoff := t.cutoff(now)
+			size := t.bucket.Milliseconds()
+			err := subBuckets(tx.Bucket([]byte(t.from)), func(key string, hb *bolt.Bucket) error {
+				return subBuckets(hb, func(metric string, mb *bolt.Bucket) error {
+					buckets := map[int64]aggregate{}
+					var old [][]byte
+					scan(mb, time.Time{}, cutoff, func(ms int64, v []byte) error {
+						start := ms / size * size
+						buckets[start] = buckets[start].merge(decodeAggregate(v))
+						old = append(old, msKey(ms))
+						return nil
+					})
+					if len(old) == 0 {
+						return nil
+					}
+					dst, err := createNested(tx, t.to, key, metric)
+					if err != nil {
+						return err
+					}
+					for start, a := range buckets {
+						k := msKey(start)
+						if v := dst.Get(k); v != nil {
+							a = decodeAggregate(v).merge(a)
+						}
+						if err := dst.Put(k, a.encode()); err != nil {
+							return err
+						}
+					}
+					for _, k := range old {
+						if err := mb.Delete(k); err != nil {
+							return err
+						}
+					}
+					rolled += int64(len(old))
+					return nil
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return rolled, err
+}
+
+// deleteBefore deletes the keys of b before the time.
+func deleteBefore(b *bolt.Bucket, before time.Time) (int64, error) {
+	var old [][]byte
+	scan(b, time.Time{}, before, func(ms int64, v []byte) error {
+		old = append(old, msKey(ms))
+		return nil
+	})
+	for _, k := range old {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(old)), nil
+}
+
+func (s *boltStore) prune(table string, before time.Time) (int64, error) {
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		switch table {
+		case "samples", "samples_5m", "samples_1h", "results":
+			return subBuckets(tx.Bucket([]byte(table)), func(_ string, hb *bolt.Bucket) error {
+				return subBuckets(hb, func(_ string, b *bolt.Bucket) error {
+					deleted, err := deleteBefore(b, before)
+					n += deleted
+					return err
+				})
+			})
+		case "alert_history", "host_events":
+			// The keys start with the time, so prefix deletion works the
+			// same.
+			var old [][]byte
+			c := tx.Bucket([]byte(table)).Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k[:8], timeKey(before)) < 0; k, _ = c.Next() {
+				old = append(old, k)
+			}
+			b := tx.Bucket([]byte(table))
+			for _, k := range old {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			n = int64(len(old))
+			return nil
+		case "incidents":
+			b := tx.Bucket([]byte(table))
+			var old [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				var inc incident
+				if err := json.Unmarshal(v, &inc); err != nil {
+					return err
+				}
+				if inc.End != nil && inc.End.Before(before) {
+					old = append(old, k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range old {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			n = int64(len(old))
+			return nil
+		}
+		return fmt.Errorf("unknown table %q", table)
+	})
+	return n, err
+}
+
+func (s *boltStore) addAlertEvent(r alertRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("alert_history"))
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(timeKey(r.Time), seq)
+		return b.Put(key, data)
+	})
+}
+
+func (s *boltStore) alertEvents(f alertHistoryFilter) ([]alertRecord, error) {
+	records := []alertRecord{}
+	to := f.to
+	if !to.IsZero() {
+		to = to.Add(time.Millisecond) // to is inclusive
+	}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scan(tx.Bucket([]byte("alert_history")), f.from, to, func(_ int64, v []byte) error {
+			var r alertRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if (f.host == "" || strings.EqualFold(r.Host, f.host)) && (f.check == "" || r.Check == f.check) {
+				records = append(records, r)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (s *boltStore) addHostEvent(e hostEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("host_events"))
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(binary.BigEndian.AppendUint64(timeKey(e.Time), seq), data)
+	})
+}
+
+func (s *boltStore) hostEvents(host string, from, to time.Time) ([]hostEvent, error) {
+	var list []hostEvent
+	if !to.IsZero() {
+		to = to.Add(time.Millisecond) // to is inclusive
+	}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scan(tx.Bucket([]byte("host_events")), from, to, func(_ int64, v []byte) error {
+			var e hostEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if strings.EqualFold(e.Host, host) {
+				list = append(list, e)
+			}
+			return nil
+		})
+	})
+	return list, err
+}
+
+// putIncident stores an incident without its alerts, which come from the
+// alert history.
+func putIncident(tx *bolt.Tx, inc incident) error {
+	inc.Alerts, inc.Duration = nil, 0
+	data, err := json.Marshal(inc)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("incidents")).Put(msKey(inc.ID), data)
+}
+
+func (s *boltStore) addIncident(inc *incident) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		id, err := tx.Bucket([]byte("incidents")).NextSequence()
+		if err != nil {
+			return err
+		}
+		inc.ID = int64(id)
+		return putIncident(tx, *inc)
+	})
+}
+
+func (s *boltStore) updateIncident(inc incident) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putIncident(tx, inc)
+	})
+}
+
+func (s *boltStore) incidents(f incidentFilter) ([]incident, error) {
+	list := []incident{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("incidents")).ForEach(func(k, v []byte) error {
+			var inc incident
+			if err := json.Unmarshal(v, &inc); err != nil {
+				return err
+			}
+			switch {
+			case f.id != 0 && inc.ID != f.id,
+				f.open && inc.End != nil,
+				f.host != "" && !strings.EqualFold(inc.Host, f.host),
+				!f.from.IsZero() && inc.End != nil && inc.End.Before(f.from),
+				!f.to.IsZero() && inc.Start.After(f.to):
+				return nil
+			}
+			list = append(list, inc)
+			return nil
+		})
+	})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list, err
+}
+
+func (s *boltStore) putState(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("state")).Put([]byte(key), value)
+	})
+}
+
+func (s *boltStore) getState(key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("state")).Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltStore) listState(prefix string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("state")).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			values[string(k)] = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return values, err
+}
+
+func (s *boltStore) deleteState(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("state")).Delete([]byte(key))
+	})
+}
+
+// A lease is stored as its expiry in Unix milliseconds, big-endian,
+// followed by the holder.
+func (s *boltStore) claimLease(name, holder string, now, until time.Time) (string, bool, error) {
+	var previous string
+	held := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("leases"))
+		if v := b.Get([]byte(name)); len(v) >= 8 {
+			previous = string(v[8:])
+			if previous != holder && int64(binary.BigEndian.Uint64(v)) > now.UnixMilli() {
+				return nil
+			}
+		}
+		v := binary.BigEndian.AppendUint64(nil, uint64(until.UnixMilli()))
+		held = true
+		return b.Put([]byte(name), append(v, holder...))
+	})
+	return previous, held && err == nil, err
+}
+
+func (s *boltStore) releaseLease(name, holder string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("leases"))
+		if v := b.Get([]byte(name)); len(v) >= 8 && string(v[8:]) == holder {
+			return b.Put([]byte(name), append(make([]byte, 8), holder...))
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) backup(path string) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0o600)
+	})
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Notifier delivers an alert to one destination, giving up when ctx is
+// done.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// notifyTimeout bounds each notification sent through a channel.
+const notifyTimeout = 10 * time.Second
+
+// notify sends an alert through the channel called name within
+// notifyTimeout, counting failures. It doesn't take the context of the
+// check that raised the alert, so an alert found just before the check's
+// deadline still goes out. A standby monitor sends nothing.
+func notify(name string, ch Notifier, a Alert) error {
+	if !isLeader() {
+		slog.Debug("Standing by, not sending", "channel", name, "alert", a.Key())
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	err := ch.Notify(ctx, a)
+	if err != nil {
+		countNotifyFailure(name)
+	}
+	return err
+}
+
+type telegramChannel struct {
+	chatID   int64
+	threadID int
+	loc      *time.Location
+}
+
+func (c telegramChannel) Notify(ctx context.Context, a Alert) error {
+	sendTelegramAlert(c.chatID, c.threadID, c.loc, a)
+	return nil
+}
+
+type pagerDutyChannel struct {
+	routingKey string
+}
+
+func (c pagerDutyChannel) Notify(ctx context.Context, a Alert) error {
+	severity := "warning"
+	if a.Severity >= SeverityCritical {
+		severity = "critical"
+	}
+	action := "trigger"
+	if a.Resolved {
+		action = "resolve"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"routing_key":  c.routingKey,
+		"event_action": action,
+		"dedup_key":    a.Key(),
+		"payload": map[string]interface{}{
+			"summary":  a.Message,
+			"source":   a.Host,
+			"severity": severity,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://events.pagerduty.com/v2/enqueue", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pagerduty returned %s", resp.Status)
+	}
+	return nil
+}
+
+type twilioChannel struct {
+	accountSID, authToken, from string
+	to                          []string
+	loc                         *time.Location
+}
+
+func (c twilioChannel) Notify(ctx context.Context, a Alert) error {
+	endpoint := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", c.accountSID)
+	for _, to := range c.to {
+		form := url.Values{"From": {c.from}, "To": {to}, "Body": {a.Text(c.loc)}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(c.accountSID, c.authToken)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("twilio returned %s for %s", resp.Status, to)
+		}
+	}
+	return nil
+}
+
+// channelByName builds the notifier configured under channels.<name>.
+func channelByName(name string) (Notifier, error) {
+	return channelByNameIn(conf(), name)
+}
+
+func channelByNameIn(config *viper.Viper, name string) (Notifier, error) {
+	cfg := config.Sub("channels." + name)
+	if cfg == nil {
+		return nil, fmt.Errorf("channel %q is not configured", name)
+	}
+
+	// Chats can show times in their own timezone.
+	loc := loadLocation(cfg.GetString("timezone"), displayLocation())
+
+	var ch Notifier
+	switch cfg.GetString("type") {
+	case "telegram":
+		ch = telegramChannel{chatID: cfg.GetInt64("chatID"), threadID: cfg.GetInt("threadID"), loc: loc}
+	case "pagerduty":
+		ch = pagerDutyChannel{routingKey: cfg.GetString("routingKey")}
+	case "twilio":
+		ch = twilioChannel{
+			accountSID: cfg.GetString("accountSID"),
+			authToken:  cfg.GetString("authToken"),
+			from:       cfg.GetString("from"),
+			to:         cfg.GetStringSlice("to"),
+			loc:        loc,
+		}
+	default:
+		return nil, fmt.Errorf("channel %q has unknown type %q", name, cfg.GetString("type"))
+	}
+
+	// Telegram channels only queue the message; the outbox traces the send.
+	if cfg.GetString("type") != "telegram" {
+		ch = tracedChannel{Notifier: ch, name: name}
+	}
+
+	hours, err := parseQuietHours(cfg.Sub("quietHours"))
+	if err != nil {
+		return nil, fmt.Errorf("channel %q: %w", name, err)
+	}
+	if hours != nil {
+		ch = quietChannel{Notifier: ch, name: name, hours: hours}
+	}
+	return ch, nil
+}
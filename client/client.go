@@ -0,0 +1,262 @@
+// Package client is a Go client of the checkhealth HTTP API described by
+// openapi.json, which the monitor also serves on /api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec is the OpenAPI specification of the API.
+//
+//go:embed openapi.json
+var Spec []byte
+
+// Client calls the API of a checkhealth monitor.
+type Client struct {
+	// BaseURL is where the monitor serves HTTP, e.g. http://localhost:8002.
+	BaseURL string
+	// Token is sent as a bearer token, if set; else Username and Password
+	// are used for basic auth, if set.
+	Token              string
+	Username, Password string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// New returns a client of the monitor at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Error is a response with an unexpected status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("checkhealth: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func hostPath(name string, suffix string) string {
+	return "/api/v1/hosts/" + url.PathEscape(name) + suffix
+}
+
+// Hosts returns the status of every host.
+func (c *Client) Hosts(ctx context.Context) ([]HostStatus, error) {
+	var hosts []HostStatus
+	err := c.do(ctx, http.MethodGet, "/api/v1/hosts", nil, nil, &hosts)
+	return hosts, err
+}
+
+// Host returns the status of one host.
+func (c *Client) Host(ctx context.Context, name string) (HostStatus, error) {
+	var host HostStatus
+	err := c.do(ctx, http.MethodGet, hostPath(name, ""), nil, nil, &host)
+	return host, err
+}
+
+// CheckHost runs every enabled check of a host now and returns its status
+// afterwards.
+func (c *Client) CheckHost(ctx context.Context, name string) (HostStatus, error) {
+	var host HostStatus
+	err := c.do(ctx, http.MethodPost, hostPath(name, "/check"), nil, nil, &host)
+	return host, err
+}
+
+// History returns a host's metric and alert history between from and to.
+func (c *Client) History(ctx context.Context, name string, from, to time.Time) (History, error) {
+	q := url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}}
+	var h History
+	err := c.do(ctx, http.MethodGet, hostPath(name, "/history"), q, nil, &h)
+	return h, err
+}
+
+// Timeline returns the events of a host between from and to, oldest first.
+func (c *Client) Timeline(ctx context.Context, name string, from, to time.Time) ([]HostEvent, error) {
+	q := url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}}
+	var events []HostEvent
+	err := c.do(ctx, http.MethodGet, hostPath(name, "/timeline"), q, nil, &events)
+	return events, err
+}
+
+// Monitor returns the monitor's own health.
+func (c *Client) Monitor(ctx context.Context) (MonitorStatus, error) {
+	var st MonitorStatus
+	err := c.do(ctx, http.MethodGet, "/api/v1/monitor", nil, nil, &st)
+	return st, err
+}
+
+// Alerts returns the active alerts.
+func (c *Client) Alerts(ctx context.Context) ([]ActiveAlert, error) {
+	var alerts []ActiveAlert
+	err := c.do(ctx, http.MethodGet, "/alerts", nil, nil, &alerts)
+	return alerts, err
+}
+
+// AlertHistory returns the fired, resolved and acknowledged alerts.
+func (c *Client) AlertHistory(ctx context.Context, q AlertHistoryQuery) ([]AlertRecord, error) {
+	query := url.Values{}
+	if q.Host != "" {
+		query.Set("host", q.Host)
+	}
+	if q.Check != "" {
+		query.Set("check", q.Check)
+	}
+	if !q.From.IsZero() {
+		query.Set("from", q.From.Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		query.Set("to", q.To.Format(time.RFC3339))
+	}
+	var records []AlertRecord
+	err := c.do(ctx, http.MethodGet, "/api/v1/alerts/history", query, nil, &records)
+	return records, err
+}
+
+// Incidents returns the incidents of host (all hosts if empty) that
+// overlap from to to, latest first; zero times are unbounded.
+func (c *Client) Incidents(ctx context.Context, host string, from, to time.Time) ([]Incident, error) {
+	q := url.Values{}
+	if host != "" {
+		q.Set("host", host)
+	}
+	if !from.IsZero() {
+		q.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.Format(time.RFC3339))
+	}
+	var list []Incident
+	err := c.do(ctx, http.MethodGet, "/api/v1/incidents", q, nil, &list)
+	return list, err
+}
+
+// Incident returns an incident with the alert history of its host while it
+// lasted.
+func (c *Client) Incident(ctx context.Context, id int64) (Incident, error) {
+	var inc Incident
+	err := c.do(ctx, http.MethodGet, "/api/v1/incidents/"+strconv.FormatInt(id, 10), nil, nil, &inc)
+	return inc, err
+}
+
+// Availability returns the availability of the hosts and groups between
+// from and to.
+func (c *Client) Availability(ctx context.Context, from, to time.Time) (SLAReport, error) {
+	q := url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}}
+	var report SLAReport
+	err := c.do(ctx, http.MethodGet, "/api/v1/sla", q, nil, &report)
+	return report, err
+}
+
+// Ack acknowledges the active alert of a host's check.
+func (c *Client) Ack(ctx context.Context, host, check string) error {
+	return c.do(ctx, http.MethodPost, "/alerts/ack", url.Values{"key": {host + "/" + check}}, nil, nil)
+}
+
+// Silences returns the silences.
+func (c *Client) Silences(ctx context.Context) ([]Silence, error) {
+	var silences []Silence
+	err := c.do(ctx, http.MethodGet, "/silences", nil, nil, &silences)
+	return silences, err
+}
+
+// AddSilence adds a silence and returns it with its ID.
+func (c *Client) AddSilence(ctx context.Context, s NewSilence) (Silence, error) {
+	var added Silence
+	err := c.do(ctx, http.MethodPost, "/silences", nil, s, &added)
+	return added, err
+}
+
+// DeleteSilence removes a silence.
+func (c *Client) DeleteSilence(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/silences", url.Values{"id": {id}}, nil, nil)
+}
+
+// Audit returns the audit log events matching q.
+func (c *Client) Audit(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	query := url.Values{}
+	if q.Alert != "" {
+		query.Set("alert", q.Alert)
+	}
+	if q.Event != "" {
+		query.Set("event", q.Event)
+	}
+	if !q.Since.IsZero() {
+		query.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		query.Set("until", q.Until.Format(time.RFC3339))
+	}
+	var events []AuditEvent
+	err := c.do(ctx, http.MethodGet, "/audit", query, nil, &events)
+	return events, err
+}
+
+// Groups returns the host groups and their degraded hosts.
+func (c *Client) Groups(ctx context.Context) ([]GroupStatus, error) {
+	var groups []GroupStatus
+	err := c.do(ctx, http.MethodGet, "/groups", nil, nil, &groups)
+	return groups, err
+}
+
+// SendAlerts raises or resolves alerts through the monitor's pipeline, as
+// the custom webhook source.
+func (c *Client) SendAlerts(ctx context.Context, alerts ...IncomingAlert) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/webhooks/custom", nil, alerts, nil)
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Threshold is the warning, critical and clear level of a usage metric in
+// percent; zero levels are unset.
+type Threshold struct {
+	Warning  float64 `json:"warning"`
+	Critical float64 `json:"critical"`
+	Clear    float64 `json:"clear"`
+}
+
+// CheckResult is the outcome of the latest sample of a check on a host.
+type CheckResult struct {
+	Check   string    `json:"check"`
+	OK      bool      `json:"ok"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+	Stale   bool      `json:"stale,omitempty"` // more than twice the check's interval old
+}
+
+// HostAlert is an active alert of a host.
+type HostAlert struct {
+	Check         string     `json:"check"`
+	Severity      string     `json:"severity"` // warning or critical
+	Message       string     `json:"message"`
+	Since         time.Time  `json:"since"`
+	Acked         bool       `json:"acked"`
+	SilencedUntil *time.Time `json:"silencedUntil,omitempty"`
+}
+
+// HostStatus is a host with its latest values, check results and alerts.
+type HostStatus struct {
+	Name       string               `json:"name"`
+	Address    string               `json:"address,omitempty"`
+	Group      string               `json:"group,omitempty"`
+	Tags       []string             `json:"tags,omitempty"`
+	Agent      bool                 `json:"agent,omitempty"` // reports through an agent rather than SSH
+	LastSeen   *time.Time           `json:"lastSeen,omitempty"`
+	Values     map[string]float64   `json:"values,omitempty"`
+	Checks     []CheckResult        `json:"checks"`
+	Thresholds map[string]Threshold `json:"thresholds"`
+	Alerts     []HostAlert          `json:"alerts"`
+}
+
+// Point is one value of a metric at a time.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// AuditEvent is an entry of the alert audit log.
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Alert    string    `json:"alert,omitempty"` // host/check
+	Severity string    `json:"severity,omitempty"`
+	Channel  string    `json:"channel,omitempty"`
+	Result   string    `json:"result,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// AuditQuery filters the audit log; zero fields match everything.
+type AuditQuery struct {
+	Alert        string // host/check
+	Event        string
+	Since, Until time.Time
+}
+
+// History is a host's metric and alert history over a time range.
+type History struct {
+	Host    string             `json:"host"`
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Metrics map[string][]Point `json:"metrics"`
+	Alerts  []AuditEvent       `json:"alerts"`
+}
+
+// AlertRecord is an alert firing, resolving or being acknowledged.
+type AlertRecord struct {
+	Time     time.Time       `json:"time"`
+	Event    string          `json:"event"` // fired, resolved or acked
+	Host     string          `json:"host"`
+	Check    string          `json:"check"`
+	Severity string          `json:"severity"`
+	Message  string          `json:"message"`
+	By       string          `json:"by,omitempty"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// AlertHistoryQuery filters the alert history; zero fields match
+// everything.
+type AlertHistoryQuery struct {
+	Host, Check string
+	From, To    time.Time
+}
+
+// HostEvent is an entry of a host's timeline: a reboot, a service starting,
+// stopping or restarting, a watched file changing, or an alert.
+type HostEvent struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Kind     string    `json:"kind"` // reboot, service, file, or fired, resolved or acked
+	Check    string    `json:"check,omitempty"`
+	Severity string    `json:"severity,omitempty"`
+	Message  string    `json:"message"`
+}
+
+// MonitorStatus is the monitor's own health.
+type MonitorStatus struct {
+	Instance             string           `json:"instance"`
+	Leader               string           `json:"leader,omitempty"` // with ha.enabled, the instance that runs the checks
+	Version              string           `json:"version"`
+	Started              time.Time        `json:"started"`
+	UptimeSeconds        float64          `json:"uptimeSeconds"`
+	Goroutines           int              `json:"goroutines"`
+	HeapBytes            uint64           `json:"heapBytes"`
+	SysBytes             uint64           `json:"sysBytes"`
+	Loops                int              `json:"loops"`
+	Overdue              []string         `json:"overdue"`     // loops behind schedule
+	Overrunning          []string         `json:"overrunning"` // loops whose last cycle took longer than their interval
+	SSHSessionsActive    int              `json:"sshSessionsActive"`
+	SSHSessionsWaiting   int              `json:"sshSessionsWaiting"`
+	QueuedNotifications  int              `json:"queuedNotifications"`
+	NotificationFailures map[string]int64 `json:"notificationFailures"` // by channel, since the start
+}
+
+// Incident is a stretch of time a host had at least one active alert.
+type Incident struct {
+	ID              int64         `json:"id"`
+	Host            string        `json:"host"`
+	Start           time.Time     `json:"start"`
+	End             *time.Time    `json:"end,omitempty"` // nil while ongoing
+	DurationSeconds float64       `json:"durationSeconds"`
+	Severity        string        `json:"severity"`
+	Checks          []string      `json:"checks"`
+	Alerts          []AlertRecord `json:"alerts,omitempty"` // only from Incident
+}
+
+// Availability is the share of time a host, or on average the hosts of a
+// group, had all of its checks passing.
+type Availability struct {
+	Host           string  `json:"host,omitempty"`
+	Group          string  `json:"group,omitempty"`
+	Availability   float64 `json:"availability"` // percent of the time with check results
+	UpSeconds      float64 `json:"upSeconds"`
+	DownSeconds    float64 `json:"downSeconds"`
+	UnknownSeconds float64 `json:"unknownSeconds"`
+}
+
+// SLAReport is the availability of the hosts and groups over a period.
+type SLAReport struct {
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Hosts  []Availability `json:"hosts"`
+	Groups []Availability `json:"groups"`
+}
+
+// ActiveAlert is an alert that hasn't resolved yet.
+type ActiveAlert struct {
+	Host          string
+	Address       string
+	Check         string
+	Severity      int // 0 warning, 1 critical
+	Message       string
+	Time          time.Time
+	Resolved      bool
+	Source        string // system an alert received by webhook came from
+	Since         time.Time
+	Acked         bool
+	Level         int // escalation steps already sent
+	SilencedUntil time.Time
+}
+
+// Silence mutes the alerts of a host and/or check for a time range. Empty
+// Host or Check match everything.
+type Silence struct {
+	ID      string    `json:"id,omitempty"`
+	Host    string    `json:"host"`
+	Check   string    `json:"check"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Comment string    `json:"comment"`
+}
+
+// NewSilence is a silence to add. Start defaults to now, and Duration may
+// be given instead of End.
+type NewSilence struct {
+	Silence
+	Duration string `json:"duration,omitempty"`
+}
+
+// GroupStatus is a host group with its degraded hosts.
+type GroupStatus struct {
+	Group    string   `json:"group"`
+	Hosts    int      `json:"hosts"`
+	Degraded []string `json:"degraded"`
+}
+
+// IncomingAlert is an alert raised or resolved by another system.
+type IncomingAlert struct {
+	Host     string    `json:"host"`
+	Group    string    `json:"group,omitempty"`
+	Check    string    `json:"check"`
+	Severity string    `json:"severity,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Resolved bool      `json:"resolved,omitempty"`
+	Time     time.Time `json:"time"` // now if zero
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+var (
+	configPath   string
+	profile      string
+	logLevelName string
+	logFormat    string
+	initForce    bool
+	docJSON      bool
+
+	exportFrom, exportTo, exportFormat string
+	exportServer, exportToken          string
+
+	historyHost, historyCheck, historyFrom, historyTo string
+	historyJSON                                       bool
+
+	stateForce bool
+)
+
+var rootCmd = &cobra.Command{
+	Use:          "checkhealth",
+	Short:        "Monitor servers over SSH and alert to Telegram",
+	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.Name() == "version" || cmd.Name() == "init" || cmd.Name() == "doc" {
+			return nil
+		}
+		if err := setupLogging(logLevelName, logFormat); err != nil {
+			return err
+		}
+		initConfig(configPath, profile)
+		logLevelFlagSet = cmd.Flags().Changed("log-level")
+		if err := configureLogging(cmd.Name() == "run" || cmd.Name() == "agent" || !cmd.HasParent()); err != nil {
+			return err
+		}
+		if cmd.Name() == "check" || cmd.Name() == "host" {
+			discoverInventory()
+			return loadInventoryFile()
+		}
+		return nil
+	},
+	// Running without a subcommand keeps the old behaviour of starting the
+	// daemon.
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDaemon()
+	},
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the monitor daemon",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDaemon()
+	},
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check <host>",
+	Short: "Check one host once and print a report",
+	Long:  "Check one host once and print a report. The host is given by name (\"Server 2\"), number (2), or part of its SSH command such as the IP address.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		h, ok := findHost(args[0])
+		if !ok {
+			return fmt.Errorf("no host matches %q", args[0])
+		}
+		return printHostReport(cmd.Context(), h)
+	},
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runValidate())
+	},
+}
+
+var sendTestCmd = &cobra.Command{
+	Use:   "send-test",
+	Short: "Send a test alert to the Telegram chat",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		m := outboundMessage{
+			ChatID:   conf().GetInt64("telegramChatID"),
+			ThreadID: conf().GetInt("telegramThreadID"),
+			Text:     fmt.Sprintf("Test alert from checkhealth %s at %s", version, time.Now().Format(time.RFC1123)),
+		}
+		if err := deliverTelegram(m); err != nil {
+			return fmt.Errorf("sending test alert: %w", err)
+		}
+		fmt.Println("Test alert sent.")
+		return nil
+	},
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Interactively create a starter config file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runInit(configPath, initForce)
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Describe the configuration",
+}
+
+var configDocCmd = &cobra.Command{
+	Use:   "doc",
+	Short: "Print every config option with its type, default and description",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printConfigDoc(docJSON)
+	},
+}
+
+var configHostCmd = &cobra.Command{
+	Use:   "host <host>",
+	Short: "Print the effective configuration of a host",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		h, ok := findHost(args[0])
+		if !ok {
+			return fmt.Errorf("no host matches %q", args[0])
+		}
+		return printHostConfig(h, docJSON)
+	},
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export <host>",
+	Short: "Export a host's metric and alert history as JSON or CSV",
+	Long:  "Export a host's metric and alert history as JSON or CSV. The history is fetched from the running monitor's API; --from and --to take an RFC 3339 time or a duration before now such as 168h.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		server := exportServer
+		if server == "" {
+			server = localServer()
+		}
+		return fetchHistory(server, exportToken, args[0], exportFrom, exportTo, exportFormat)
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the fired, resolved and acknowledged alerts",
+	Long:  "Show the fired, resolved and acknowledged alerts from the history database. --from and --to take an RFC 3339 time or a duration before now such as 168h.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := parseAlertHistoryFilter(historyHost, historyCheck, historyFrom, historyTo)
+		if err != nil {
+			return err
+		}
+		if err := openHistory(); err != nil {
+			return err
+		}
+		records, err := queryAlertHistory(f)
+		if err != nil {
+			return err
+		}
+		if historyJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(records)
+		}
+		return printAlertHistory(os.Stdout, records)
+	},
+}
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Export or import the persisted state",
+	Long:  "Export or import the persisted state: the history database with the active alerts, their acknowledgements and the silences, the audit log, and the delivery queue. Use it to move the monitor to another machine.",
+}
+
+var stateExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Write the persisted state to a .tar.gz archive",
+	Long:  "Write the persisted state to a .tar.gz archive. The monitor may be running, but the alerts and silences it changed in the last 30 seconds are only included once it has stopped.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := exportState(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("State exported to %s.\n", args[0])
+		return nil
+	},
+}
+
+var stateImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Restore the persisted state from an archive written by state export",
+	Long:  "Restore the persisted state from an archive written by state export, to the paths in the config file. Stop the monitor first; existing files are only replaced with --force.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		restored, err := importState(args[0], stateForce)
+		for _, path := range restored {
+			fmt.Printf("Restored %s\n", path)
+		}
+		return err
+	},
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run the checks of this host and report them to the monitor",
+	Long:  "Run the check commands the monitor asks for on this host every agent.interval and report their output to the monitors of agent.monitors, signed with agent.key. The host needs agent: true in the monitor's config.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runAgent(ctx)
+	},
+}
+
+var agentKeyCmd = &cobra.Command{
+	Use:   "key <host>",
+	Short: "Print the key the agent of a host signs its reports with",
+	Long:  "Print the key the agent of a host signs its reports with, derived from agents.secret of the monitor's config. Set it as agent.key on the host.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		secret := conf().GetString("agents.secret")
+		if secret == "" {
+			return fmt.Errorf("agents.secret is not set")
+		}
+		fmt.Println(agentKey(secret, args[0]))
+		return nil
+	},
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("checkhealth %s\n", version)
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "config file (default ./config.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&profile, "profile", "p", os.Getenv("CHECKHEALTH_PROFILE"), "config profile to apply (e.g. prod, staging)")
+	rootCmd.PersistentFlags().StringVar(&logLevelName, "log-level", "info", "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", os.Getenv("CHECKHEALTH_LOG_FORMAT"), "log format: text (the default) or json")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "overwrite an existing config file")
+	configCmd.PersistentFlags().BoolVar(&docJSON, "json", false, "print JSON")
+	configCmd.AddCommand(configDocCmd, configHostCmd)
+	exportCmd.Flags().StringVar(&exportFrom, "from", "24h", "start of the range")
+	exportCmd.Flags().StringVar(&exportTo, "to", "", "end of the range (default now)")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "json", "json or csv")
+	exportCmd.Flags().StringVar(&exportServer, "server", "", "URL of the monitor (default from http.listen)")
+	historyCmd.Flags().StringVar(&historyHost, "host", "", "only alerts of this host")
+	historyCmd.Flags().StringVar(&historyCheck, "check", "", "only alerts of this check")
+	historyCmd.Flags().StringVar(&historyFrom, "from", "24h", "start of the range")
+	historyCmd.Flags().StringVar(&historyTo, "to", "", "end of the range (default now)")
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "print JSON")
+	stateImportCmd.Flags().BoolVar(&stateForce, "force", false, "replace existing files")
+	stateCmd.AddCommand(stateExportCmd, stateImportCmd)
+	agentCmd.AddCommand(agentKeyCmd)
+	exportCmd.Flags().StringVar(&exportToken, "token", os.Getenv("CHECKHEALTH_TOKEN"), "API token, if http.auth is set")
+	rootCmd.AddCommand(runCmd, checkCmd, validateCmd, sendTestCmd, initCmd, configCmd, exportCmd, historyCmd, stateCmd, agentCmd, versionCmd)
+}
+
+// findHost resolves a host by name, number, or a substring of its command.
+func findHost(query string) (Host, bool) {
+	hosts := configuredHosts()
+	if n, err := strconv.Atoi(query); err == nil {
+		if n < 1 || n > len(hosts) {
+			return Host{}, false
+		}
+		return hosts[n-1], true
+	}
+	for _, h := range hosts {
+		if strings.EqualFold(h.Name, query) || strings.Contains(h.Command, query) {
+			return h, true
+		}
+	}
+	return Host{}, false
+}
+
+func printHostReport(ctx context.Context, h Host) error {
+	host := h.Label()
+	if h.Agent {
+		return fmt.Errorf("%s reports through its agent, see GET /api/v1/hosts/%s on the monitor", host, h.Name)
+	}
+	start := time.Now()
+	output, err := runSSHCommand(ctx, h.Command)
+	if err != nil {
+		return fmt.Errorf("%s: running SSH command: %w", host, err)
+	}
+	slog.Debug("SSH output", "host", h.Name, "output", output)
+	cpu, mem, disk, uptime, err := parseSSHOutput(output)
+	if err != nil {
+		return fmt.Errorf("%s: parsing SSH output: %w", host, err)
+	}
+
+	fmt.Printf("%s (checked in %s)\n", host, time.Since(start).Round(time.Millisecond))
+	if h.Group != "" || len(h.Tags) > 0 {
+		fmt.Printf("  Group: %s  Tags: %s\n", h.Group, strings.Join(h.Tags, ", "))
+	}
+	fmt.Printf("  Uptime: %s\n", strings.TrimSpace(uptime))
+	thresholds := thresholdsFor(h)
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{{"cpu", cpu}, {"memory", mem}, {"disk", disk}} {
+		t := thresholds[m.name]
+		status := "OK"
+		switch {
+		case !checkEnabled(h, m.name):
+			status = "DISABLED"
+		case t.Critical > 0 && m.value > t.Critical:
+			status = "CRITICAL"
+		case t.Warning > 0 && m.value > t.Warning:
+			status = "WARNING"
+		}
+		fmt.Printf("  %-13s %6.2f%%  %-8s (warning %.0f%%, critical %.0f%%)\n", metricNames[m.name]+":", m.value, status, t.Warning, t.Critical)
+	}
+	for _, c := range customChecks() {
+		if !c.appliesTo(h) {
+			continue
+		}
+		value, err := c.value(ctx, h)
+		switch {
+		case err != nil:
+			fmt.Printf("  %s: ERROR %v\n", c.Name, err)
+		case c.failing(value):
+			fmt.Printf("  %s: %s  %s (%s %s)\n", c.Name, value, strings.ToUpper(c.Severity), c.Operator, c.Threshold)
+		default:
+			fmt.Printf("  %s: %s  OK\n", c.Name, value)
+		}
+	}
+	return nil
+}
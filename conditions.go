@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Knetic/govaluate"
+	"github.com/spf13/viper"
+)
+
+// condition is an alert rule written as an expression over a host's latest
+// sample, e.g. "cpu > 90 && load1 > cores * 2". Variables named
+// <metric>_<duration>_ago, such as disk_1h_ago, refer to earlier samples.
+type condition struct {
+	Name     string
+	When     string
+	Group    string
+	Tags     []string
+	Severity string // "warning" (default) or "critical"
+	Message  string // template with .Host, .Name and every variable
+
+	DependsOn []string
+}
+
+// conditionVars are the variables available in condition expressions.
+var conditionVars = []string{"cpu", "memory", "disk", "load1", "load5", "load15", "cores", "net_rx", "net_tx"}
+
+var historicVar = regexp.MustCompile(`^([a-z0-9]+)_([0-9]+[smhd])_ago$`)
+
+func conditions() []condition {
+	return conditionsIn(conf())
+}
+
+func conditionsIn(cfg *viper.Viper) []condition {
+	var cs []condition
+	if err := cfg.UnmarshalKey("conditions", &cs); err != nil {
+		slog.Error("Error reading conditions", "err", err)
+	}
+	return cs
+}
+
+// parseLookback parses the duration of a historic variable; unlike
+// time.ParseDuration it accepts days.
+func parseLookback(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		return time.Duration(days) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(s)
+}
+
+func (c condition) validate() (field string, err error) {
+	if c.Name == "" {
+		return "name", errors.New("is required")
+	}
+	if c.Severity != "" && !strings.EqualFold(c.Severity, "warning") && !strings.EqualFold(c.Severity, "critical") {
+		return "severity", errors.New("must be warning or critical")
+	}
+	expr, err := govaluate.NewEvaluableExpression(c.When)
+	if err != nil {
+		return "when", err
+	}
+	for _, name := range expr.Vars() {
+		if m := historicVar.FindStringSubmatch(name); m != nil {
+			name = m[1]
+		}
+		if !contains(conditionVars, name) {
+			return "when", fmt.Errorf("unknown variable %q, expected one of %s", name, strings.Join(conditionVars, ", "))
+		}
+	}
+	if _, err := template.New("message").Parse(c.Message); err != nil {
+		return "message", err
+	}
+	return "", nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sample is one set of condition variables taken from a health check.
+type sample struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+// sampleHistory keeps each host's recent samples for as long as the
+// conditions look back.
+type sampleHistory struct {
+	mu    sync.Mutex
+	hosts map[string][]sample
+}
+
+var history = &sampleHistory{hosts: map[string][]sample{}}
+
+func (s *sampleHistory) add(host string, smp sample, keep time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples := append(s.hosts[host], smp)
+	i := 0
+	for i < len(samples)-1 && samples[i].Time.Before(smp.Time.Add(-keep)) {
+		i++
+	}
+	s.hosts[host] = samples[i:]
+}
+
+func (s *sampleHistory) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, samples := range s.hosts {
+		n += len(samples)
+	}
+	return n
+}
+
+// at returns the newest sample of a host taken at or before t.
+func (s *sampleHistory) at(host string, t time.Time) (sample, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples := s.hosts[host]
+	for i := len(samples) - 1; i >= 0; i-- {
+		if !samples[i].Time.After(t) {
+			return samples[i], true
+		}
+	}
+	return sample{}, false
+}
+
+// maxLookback is the longest lookback used by any condition.
+func maxLookback(cs []condition) time.Duration {
+	var longest time.Duration
+	for _, c := range cs {
+		expr, err := govaluate.NewEvaluableExpression(c.When)
+		if err != nil {
+			continue
+		}
+		for _, name := range expr.Vars() {
+			if m := historicVar.FindStringSubmatch(name); m != nil {
+				if d, err := parseLookback(m[2]); err == nil && d > longest {
+					longest = d
+				}
+			}
+		}
+	}
+	return longest
+}
+
+// evaluateConditions records a host's sample and raises or clears the alert
+// of every condition that applies to the host.
+func evaluateConditions(h Host, values map[string]float64) {
+	cs := conditions()
+	if len(cs) == 0 {
+		return
+	}
+	now := time.Now()
+	history.add(h.Name, sample{Time: now, Values: values}, maxLookback(cs)+time.Minute)
+
+	for _, c := range cs {
+		if c.Group != "" && !strings.EqualFold(c.Group, h.Group) {
+			continue
+		}
+		if !hostHasTags(h, c.Tags) || !checkEnabled(h, c.Name) {
+			continue
+		}
+		c.evaluate(h, now, values)
+	}
+}
+
+func hostHasTags(h Host, tags []string) bool {
+	for _, tag := range tags {
+		if !h.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c condition) evaluate(h Host, now time.Time, values map[string]float64) {
+	expr, err := govaluate.NewEvaluableExpression(c.When)
+	if err != nil {
+		slog.Error("Invalid condition", "check", c.Name, "err", err)
+		return
+	}
+	params := map[string]interface{}{}
+	for _, name := range expr.Vars() {
+		m := historicVar.FindStringSubmatch(name)
+		if m == nil {
+			v, ok := values[name]
+			if !ok {
+				slog.Debug("Condition has no value", "host", h.Name, "check", c.Name, "metric", name)
+				return
+			}
+			params[name] = v
+			continue
+		}
+		d, _ := parseLookback(m[2])
+		past, ok := history.at(h.Name, now.Add(-d))
+		if !ok {
+			slog.Debug("Condition needs more history", "host", h.Name, "check", c.Name, "window", m[2])
+			return
+		}
+		v, ok := past.Values[m[1]]
+		if !ok {
+			return
+		}
+		params[name] = v
+	}
+
+	result, err := expr.Evaluate(params)
+	if err != nil {
+		slog.Error("Error evaluating condition", "host", h.Name, "check", c.Name, "err", err)
+		return
+	}
+	if matched, _ := result.(bool); !matched {
+		clearAlert(h.Name, c.Name)
+		return
+	}
+
+	severity := SeverityWarning
+	if strings.EqualFold(c.Severity, "critical") {
+		severity = SeverityCritical
+	}
+	text := c.Message
+	if text == "" {
+		text = "{{.Name}}: {{.When}}"
+	}
+	data := map[string]interface{}{"Host": h.Label(), "Name": c.Name, "When": c.When}
+	for k, v := range params {
+		data[k] = v
+	}
+	var b strings.Builder
+	tmpl, err := template.New("message").Parse(text)
+	if err == nil {
+		err = tmpl.Execute(&b, data)
+	}
+	if err != nil {
+		slog.Error("Invalid condition message", "check", c.Name, "err", err)
+	}
+	raiseAlert(Alert{Host: h.Name, Address: h.Address, Check: c.Name, Severity: severity, Message: b.String()})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// current is the config in effect. Loading reads a config into an instance
+// of its own, and a reload swaps it in whole once it validates, so nothing
+// reading the config sees it empty or half read. The instance in effect is
+// never written to.
+var current atomic.Pointer[viper.Viper]
+
+// conf returns the config in effect.
+func conf() *viper.Viper {
+	if v := current.Load(); v != nil {
+		return v
+	}
+	return viper.GetViper()
+}
+
+// initConfig loads path, or config.yaml from the working directory when path
+// is empty, and applies the named profile if one is given.
+func initConfig(path, profile string) {
+	v, err := readConfig(path, profile)
+	if err != nil {
+		fatal("Error reading config file", "err", err)
+	}
+	current.Store(v)
+}
+
+// readConfig reads a config into a new instance.
+func readConfig(path, profile string) (*viper.Viper, error) {
+	v := viper.New()
+	setDefaults(v)
+	if path != "" {
+		v.SetConfigFile(path)
+	} else {
+		v.SetConfigName("config")
+		v.AddConfigPath(".")
+	}
+	v.SetConfigType("yaml")
+
+	// Every key can be overridden from the environment, e.g.
+	// CHECKHEALTH_TELEGRAMBOTTOKEN or CHECKHEALTH_DIGEST_WINDOW.
+	v.SetEnvPrefix("checkhealth")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	v.BindEnv("telegramBotToken", "CHECKHEALTH_TELEGRAMBOTTOKEN", "TELEGRAM_BOT_TOKEN")
+	v.BindEnv("telegramChatID", "CHECKHEALTH_TELEGRAMCHATID", "TELEGRAM_CHAT_ID")
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(v.ConfigFileUsed())
+	if err != nil {
+		return nil, err
+	}
+	data, err = interpolate(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	if err := loadIncludes(v); err != nil {
+		return nil, fmt.Errorf("included config: %w", err)
+	}
+	if err := applyProfile(v, profile); err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	if err := loadSecretDir(v); err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	return v, nil
+}
+
+// setDefaults registers the defaults of the keys that have one. They are set
+// while a config is read, never on the instance in effect: that would write
+// to it while the checks and handlers read it.
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("ssh.maxSessions", 20)
+	v.SetDefault("checkJitter", "2s")
+	v.SetDefault("audit.file", "audit.log")
+	v.SetDefault("agents.staleAfter", "2m")
+	v.SetDefault("agent.interval", "30s")
+	v.SetDefault("ha.lease", "30s")
+	v.SetDefault("history.database", "history.db")
+	v.SetDefault("delivery.queueFile", "outbox.json")
+	v.SetDefault("delivery.deadLetterFile", "deadletter.log")
+	v.SetDefault("delivery.maxAttempts", 10)
+	v.SetDefault("delivery.minBackoff", 5*time.Second)
+	v.SetDefault("delivery.maxBackoff", 10*time.Minute)
+	v.SetDefault("log.maxSize", 100)
+	v.SetDefault("log.maxBackups", 7)
+}
+
+// applyProfile merges profiles.<name> over the rest of the config. Maps
+// such as thresholds are merged key by key while lists such as hosts are
+// replaced, so profiles share channel definitions but keep separate host
+// sets.
+func applyProfile(v *viper.Viper, name string) error {
+	if name == "" {
+		return nil
+	}
+	cfg := v.Sub("profiles." + name)
+	if cfg == nil {
+		var names []string
+		for p := range v.GetStringMap("profiles") {
+			names = append(names, p)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	if err := v.MergeConfigMap(cfg.AllSettings()); err != nil {
+		return err
+	}
+	slog.Info("Using profile", "profile", name)
+	return nil
+}
+
+// appendedKeys are the lists that included files add to instead of
+// replacing.
+var appendedKeys = []string{"SSHCommands", "hosts", "routes", "customChecks", "conditions"}
+
+// loadIncludes merges the files matching the include globs, resolved
+// relative to the main config file, in order. Hosts, routes, customChecks,
+// conditions and SSHCommands are appended; any other key overrides the
+// value read before it, so e.g. a channels.yaml can hold all channel
+// definitions.
+func loadIncludes(v *viper.Viper) error {
+	dir := filepath.Dir(v.ConfigFileUsed())
+	for _, pattern := range v.GetStringSlice("include") {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			if err := includeFile(v, path); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			slog.Debug("Included config", "path", path)
+		}
+	}
+	return nil
+}
+
+func includeFile(into *viper.Viper, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if data, err = interpolate(data); err != nil {
+		return err
+	}
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	settings := v.AllSettings()
+	lists := map[string][]interface{}{}
+	for _, key := range appendedKeys {
+		if !v.IsSet(key) {
+			continue
+		}
+		items, ok := v.Get(key).([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be a list", key)
+		}
+		existing, _ := into.Get(key).([]interface{})
+		lists[key] = append(existing, items...)
+		delete(settings, strings.ToLower(key))
+	}
+	if err := into.MergeConfigMap(settings); err != nil {
+		return err
+	}
+	for key, items := range lists {
+		into.Set(key, items)
+	}
+	return nil
+}
+
+var configRef = regexp.MustCompile(`\$?\$\{([^}]+)\}`)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolate replaces ${VAR} references in the config file's values with
+// the value of the environment variable, and ${vault:path#key} or
+// ${sops:file#key} with the secret from that provider, so secrets don't have
+// to be stored in plaintext. Appending "|file" to a secret reference writes
+// it to a private file and substitutes the path instead, e.g. for "ssh -i".
+// $${VAR} is left as a literal ${VAR} for commands that need it. Comments
+// are not touched.
+func interpolate(data []byte) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if err := interpolateNode(&root); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(&root)
+}
+
+func interpolateNode(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		value, err := interpolateValue(n.Value)
+		n.Value = value
+		return err
+	}
+	for _, c := range n.Content {
+		if err := interpolateNode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func interpolateValue(s string) (string, error) {
+	var firstErr error
+	out := configRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		expr := configRef.FindStringSubmatch(ref)[1]
+
+		if envName.MatchString(expr) {
+			value, ok := os.LookupEnv(expr)
+			if !ok {
+				slog.Warn("Config references unset environment variable", "variable", expr)
+			}
+			return value
+		}
+
+		provider, _, _ := strings.Cut(expr, ":")
+		if _, ok := secretProviders[provider]; !ok {
+			return ref // shell syntax such as ${VAR:-default}
+		}
+		secretRef, asFile := strings.CutSuffix(expr, "|file")
+		value, err := resolveSecret(secretRef)
+		if err == nil && asFile {
+			value, err = writeSecretFile(value)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return ref
+		}
+		return value
+	})
+	return out, firstErr
+}
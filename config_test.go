@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// testConfig reads the YAML text into a config as readConfig does. The
+// audit log goes to a temporary directory unless text sets one.
+func testConfig(t *testing.T, text string) *viper.Viper {
+	t.Helper()
+	v := viper.New()
+	setDefaults(v)
+	v.SetDefault("audit.file", filepath.Join(t.TempDir(), "audit.log"))
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// useConfig makes the YAML text the config in effect for the rest of a
+// test.
+func useConfig(t *testing.T, text string) *viper.Viper {
+	t.Helper()
+	v := testConfig(t, text)
+	previous := current.Load()
+	current.Store(v)
+	t.Cleanup(func() { current.Store(previous) })
+	return v
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+)
+
+// configOption documents one config key for "checkhealth config doc".
+type configOption struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description"`
+}
+
+// configOptions lists the top-level settings. The fields of list entries
+// such as hosts[] are added from their structs by listOptions.
+var configOptions = []configOption{
+	{"telegramBotToken", "string", "$TELEGRAM_BOT_TOKEN", "Telegram bot token"},
+	{"telegramChatID", "int", "$TELEGRAM_CHAT_ID", "chat that receives alerts"},
+	{"telegramThreadID", "int", "0", "default forum topic in the chat"},
+	{"telegramTopics", "map[host]int", "", "forum topic per host"},
+	{"timezone", "string", "local zone", "timezone of timestamps in messages"},
+	{"include", "[]glob", "", "extra config files merged into this one"},
+	{"profiles.<name>", "map", "", "settings merged over the rest when selected with --profile"},
+	{"exporters.influxdb.url", "string", "", "InfluxDB to write every sample to"},
+	{"exporters.influxdb.bucket", "string", "", "InfluxDB 2 bucket (with org and token)"},
+	{"exporters.influxdb.database", "string", "", "InfluxDB 1 database (with username and password)"},
+	{"exporters.influxdb.measurement", "string", "checkhealth", "measurement of the points"},
+	{"exporters.remoteWrite.url", "string", "", "Prometheus remote-write receiver for samples and check results"},
+	{"exporters.remoteWrite.bearerToken", "string", "", "bearer token, or username and password for basic auth"},
+	{"exporters.remoteWrite.labels", "map", "", "labels added to every series, e.g. instance"},
+	{"exporters.statsd.address", "host:port", "", "StatsD server to send samples and check results to over UDP"},
+	{"exporters.statsd.dogstatsd", "bool", "false", "tag metrics and send service checks the DogStatsD way"},
+	{"exporters.statsd.prefix", "string", "checkhealth", "prefix of the metric names"},
+	{"exporters.statsd.tags", "[]string", "", "extra DogStatsD tags, e.g. env:prod"},
+	{"exporters.<name>.flushInterval", "duration", "10s", "how long samples are batched before they are sent"},
+	{"opentelemetry.endpoint", "host:port", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTLP/HTTP collector for traces and metrics"},
+	{"opentelemetry.insecure", "bool", "false", "send OTLP over plain HTTP"},
+	{"opentelemetry.headers", "map", "", "extra headers, e.g. for authentication"},
+	{"opentelemetry.serviceName", "string", "checkhealth", "service.name of the telemetry"},
+	{"opentelemetry.interval", "duration", "1m", "how often metrics are exported"},
+	{"webhook.criticalSeverities", "[]string", "critical, page, emergency", "severity labels of alerts received on /api/v1/webhooks/<source> that are critical; others are warnings"},
+	{"statusPage.enabled", "bool", "false", "serve a public status page on /status and /status.json without authentication"},
+	{"statusPage.title", "string", "Status", "heading of the status page"},
+	{"statusPage.names", "map", "", "public names of hosts; others are shown as Node 1, Node 2, ..."},
+	{"heartbeat.url", "URL", "", "dead man's switch to ping while the monitor runs, e.g. https://hc-ping.com/<uuid>"},
+	{"heartbeat.loop", "string", "health", "check (a check type or a scheduled custom check) whose completion on any host triggers the ping"},
+	{"heartbeat.interval", "duration", "1m", "minimum time between pings"},
+	{"pushgateway.url", "URL", "", "Prometheus Pushgateway to push the metrics to after the checks, at most every 10s"},
+	{"pushgateway.job", "string", "checkhealth", "job label of the pushed metrics"},
+	{"pushgateway.grouping", "map", "", "more grouping labels, e.g. instance"},
+	{"pushgateway.username", "string", "", "basic auth user of the Pushgateway"},
+	{"pushgateway.password", "string", "", "basic auth password of the Pushgateway"},
+	{"grafana.url", "URL", "", "Grafana to annotate alerts on, e.g. https://grafana.example.com"},
+	{"grafana.token", "string", "", "service account token allowed to write annotations"},
+	{"grafana.dashboards", "[]string", "", "UIDs of the dashboards to annotate; organization-wide without any"},
+	{"grafana.tags", "[]string", "", "extra tags of the annotations, next to checkhealth, host, check and severity"},
+	{"http.listen", "string", ":8002", "listen address of the HTTP API and dashboard"},
+	{"http.tls.cert", "path", "", "TLS certificate (PEM); serves HTTPS together with key"},
+	{"http.tls.key", "path", "", "TLS private key (PEM)"},
+	{"http.tls.selfSigned", "bool", "false", "serve HTTPS with a certificate generated at startup"},
+	{"http.tls.hosts", "[]string", "hostname, localhost", "names and addresses of the self-signed certificate"},
+	{"http.shutdownTimeout", "duration", "30s", "how long stopping waits for HTTP requests and check cycles in progress, then for queued notifications"},
+	{"http.debug", "bool", "false", "serve pprof under /debug/pprof/ and expvar under /debug/vars"},
+	{"http.auth.tokens", "[]string", "", "bearer tokens accepted by the HTTP API"},
+	{"http.auth.users", "map[user]password", "", "basic auth users of the HTTP API and dashboard"},
+	{"http.auth.scoped", "list", "", "tokens or users (token, or user and password) limited to the hosts of some groups"},
+	{"http.allowIPs", "[]string", "", "addresses and CIDR ranges allowed to use the HTTP API"},
+	{"kubernetes.enabled", "bool", "false", "reload the config when the mounted ConfigMap or Secret changes"},
+	{"kubernetes.secretDir", "path", "", "directory of a mounted Secret; each file sets the config key it is named after"},
+	{"SSHCommands", "[]string", "", "health check commands of hosts named \"Server N\""},
+	{"ssh.user", "string", "", "ssh user for hosts given only by address"},
+	{"ssh.port", "int", "22", "ssh port for hosts given only by address"},
+	{"ssh.identityFile", "path", "", "ssh key for hosts given only by address"},
+	{"ssh.maxSessions", "int", "20", "check commands that may run at once across all hosts; 0 for no limit"},
+	{"agents.secret", "string", "", "secret the keys of agent hosts are derived from; enables POST /api/v1/agent/report"},
+	{"agents.staleAfter", "duration", "2m", "how old an agent's latest report may be before the host's checks fail"},
+	{"agent.monitors", "[]string", "", "for checkhealth agent: URLs of the monitors to report to, tried in turn"},
+	{"agent.host", "string", "hostname", "for checkhealth agent: name of the host the agent reports for"},
+	{"agent.key", "string", "", "for checkhealth agent: key printed by checkhealth agent key <host>"},
+	{"agent.interval", "duration", "30s", "for checkhealth agent: how often the check commands run and are reported"},
+	{"agent.commands", "[]string", "health check and customChecks commands", "for checkhealth agent: the only commands the agent runs; refused ones are logged"},
+	{"agent.allowAnyCommand", "bool", "false", "for checkhealth agent: run any command the monitor sends instead of only agent.commands"},
+	{"ssh.command", "template", "ssh [-i key] [-p port] user@address script", "health check command for hosts given only by address"},
+	{"groups.<name>", "map", "", "group settings: thresholds, checks, checkIntervals, channels, commands, ssh"},
+	{"checkJitter", "duration", "2s", "most a check starts late at random, at most half a fixed interval"},
+	{"checkIntervals.<check>", "schedule", "10s", "schedule of a check type (health, custom, timeline) or default"},
+	{"checkTimeouts.<check>", "duration", "", "deadline of a cycle of a check type, custom check or default; defaults to its interval, at least 10s"},
+	{"thresholds.<metric>.warning", "percent", "80", "warning level of cpu, memory or disk"},
+	{"thresholds.<metric>.critical", "percent", "0 (off)", "critical level"},
+	{"thresholds.<metric>.clear", "percent", "below warning", "level at which an alert clears"},
+	{"hostThresholds.<host>.<metric>", "map", "", "threshold overrides for one host"},
+	{"consecutiveFailures.<check>", "int", "1", "failed samples in a row before alerting, or default"},
+	{"dependencies.<check>", "[]string", "[ssh]", "checks (or host/check) that must pass for the check to alert"},
+	{"history.backend", "string", "sqlite", "history store: sqlite, bolt for a bbolt file that needs no cgo, or postgres to share it between monitors"},
+	{"history.database", "path", "history.db", "SQLite database or bbolt file of the check results and metric history, or the PostgreSQL connection URL"},
+	{"history.instance", "string", "hostname", "name the active alerts of this monitor are saved under in a shared store"},
+	{"history.retention", "duration", "168h", "how long check results and metric history are kept"},
+	{"history.rollups.enabled", "bool", "true", "downsample samples past the retention instead of deleting them"},
+	{"history.rollups.fiveMinute", "duration", "720h", "how long 5-minute aggregates are kept"},
+	{"history.rollups.hourly", "duration", "8760h", "how long hourly aggregates are kept"},
+	{"ha.enabled", "bool", "false", "elect a leader among the monitors sharing the history store; the others stand by to take over"},
+	{"ha.lease", "duration", "30s", "how long the leader's lease lasts without renewal, and so how soon a standby takes over"},
+	{"history.alertRetention", "duration", "2160h", "how long fired, resolved and acknowledged alerts, incidents and timeline events are kept"},
+	{"forecast.enabled", "bool", "false", "warn when disk or memory is predicted to reach its critical threshold (or 100%)"},
+	{"forecast.metrics", "[]string", "[disk, memory]", "metrics whose trend is predicted"},
+	{"forecast.window", "duration", "24h", "history the trend is fitted to"},
+	{"forecast.horizon", "duration", "72h", "how far ahead a crossing raises the <metric>-trend alert"},
+	{"anomalies.enabled", "bool", "false", "warn when a value deviates from the host's baseline for the hour of the day"},
+	{"anomalies.metrics", "[]string", "[cpu, load1, net_rx, net_tx]", "metrics a baseline is learned for"},
+	{"anomalies.window", "duration", "168h", "history the baselines are learned from"},
+	{"anomalies.deviations", "float", "3", "standard deviations from the mean that raise the <metric>-anomaly alert"},
+	{"anomalies.minSamples", "int", "30", "samples an hour of the baseline needs before it is used"},
+	{"timeline.services", "[]string", "", "systemd services whose starts, stops and restarts are added to the hosts' timelines"},
+	{"timeline.files", "[]string", "", "files whose changes are added to the hosts' timelines"},
+	{"sla.checks", "[]string", "all but trend and anomaly", "checks whose failures count as downtime"},
+	{"sla.maxGap", "duration", "5m", "how long a check result counts for; longer gaps are unknown"},
+	{"sla.report", "bool", "false", "send the availability of the previous month to the main chat"},
+	{"sla.reportSchedule", "schedule", "0 9 1 * *", "when the monthly availability report is sent"},
+	{"flapping.window", "duration", "30m", "window for counting state changes"},
+	{"flapping.changes", "int", "0 (off)", "state changes within window that count as flapping"},
+	{"digest.enabled", "bool", "false", "group WARNING alerts into digests"},
+	{"digest.window", "schedule", "15m", "when digests are sent"},
+	{"summary.schedule", "schedule", "", "when the fleet summary is sent"},
+	{"summary.reports", "list", "", "reports sent on their own schedule to a chat"},
+	{"quietHours.start", "HH:MM", "", "start of quiet hours"},
+	{"quietHours.end", "HH:MM", "", "end of quiet hours"},
+	{"quietHours.timezone", "string", "local zone", "timezone of quiet hours"},
+	{"channels.<name>.type", "string", "", "telegram, pagerduty or twilio"},
+	{"channels.<name>.timezone", "string", "timezone", "timezone of timestamps sent to the channel"},
+	{"channels.<name>.quietHours", "map", "", "quiet hours of the channel"},
+	{"secrets.vault.address", "url", "$VAULT_ADDR", "Vault server for ${vault:...} references"},
+	{"secrets.vault.token", "string", "$VAULT_TOKEN", "Vault token"},
+	{"secrets.vault.namespace", "string", "", "Vault namespace"},
+	{"secrets.sops.binary", "path", "sops", "sops binary for ${sops:...} references"},
+	{"delivery.queueFile", "path", "outbox.json", "persistent queue of outgoing messages"},
+	{"delivery.deadLetterFile", "path", "deadletter.log", "messages that could not be delivered"},
+	{"delivery.minBackoff", "duration", "5s", "first retry delay, or as long as Telegram asks to wait when rate-limited"},
+	{"delivery.maxBackoff", "duration", "10m", "longest retry delay"},
+	{"delivery.maxAttempts", "int", "10", "attempts before a message is dead-lettered; messages Telegram rejects (400, 403) are at once"},
+	{"audit.file", "path", "audit.log", "audit log of alert events"},
+	{"inventory.file", "path", "", "hosts file reloaded on change"},
+	{"inventory.ansible.file", "path", "", "Ansible INI or YAML inventory"},
+	{"inventory.aws.region", "string", "", "EC2 region to discover instances in"},
+	{"inventory.consul.service", "string", "", "Consul service whose instances are monitored"},
+	{"inventory.etcd.prefix", "string", "", "etcd prefix holding JSON host objects"},
+	{"inventory.<source>.refresh", "duration", "5m", "how often a discovered inventory is refreshed"},
+}
+
+// fieldDocs describes the fields of list entries.
+var fieldDocs = map[string]string{
+	"hosts[].name":               "display name used in alerts",
+	"hosts[].address":            "address shown in alerts and used for ssh",
+	"hosts[].command":            "health check command, built from ssh settings if empty",
+	"hosts[].group":              "group of the host, for routes, scopes and summaries; groups.<name> settings apply if set",
+	"hosts[].tags":               "tags for routing and custom checks",
+	"hosts[].agent":              "checked by a checkhealth agent on the host instead of over ssh",
+	"hosts[].checks":             "checks turned on or off",
+	"hosts[].user":               "ssh user",
+	"hosts[].port":               "ssh port",
+	"hosts[].identityFile":       "ssh key",
+	"hosts[].commands":           "command overrides per custom check",
+	"hosts[].thresholds":         "threshold overrides per metric",
+	"hosts[].checkIntervals":     "interval overrides per check",
+	"hosts[].channels":           "channels that get all of the host's alerts",
+	"customChecks[].name":        "check name used in alerts and settings",
+	"customChecks[].command":     "command run on the host",
+	"customChecks[].group":       "only run on hosts of this group",
+	"customChecks[].tags":        "only run on hosts with these tags",
+	"customChecks[].schedule":    "own schedule instead of checkIntervals.custom",
+	"customChecks[].dependsOn":   "checks that must pass for this one to alert",
+	"customChecks[].parser":      "regex (default), json or exitcode",
+	"customChecks[].pattern":     "regex with a value group",
+	"customChecks[].path":        "dotted JSON path",
+	"customChecks[].operator":    ">, >=, <, <=, == or != (default)",
+	"customChecks[].threshold":   "value compared with",
+	"customChecks[].severity":    "warning (default) or critical",
+	"customChecks[].message":     "alert message template",
+	"conditions[].name":          "check name used in alerts and settings",
+	"conditions[].when":          "expression that raises the alert while true",
+	"conditions[].group":         "only evaluate for hosts of this group",
+	"conditions[].tags":          "only evaluate for hosts with these tags",
+	"conditions[].severity":      "warning (default) or critical",
+	"conditions[].message":       "alert message template",
+	"conditions[].dependsOn":     "checks that must pass for this one to alert",
+	"summary.reports[].name":     "report name, shown in its title",
+	"summary.reports[].schedule": "when the report is sent",
+	"summary.reports[].period":   "time covered, e.g. 24h or 7d (default 24h)",
+	"summary.reports[].chatID":   "chat the report is sent to (default telegramChatID)",
+	"summary.reports[].threadID": "forum topic in the chat",
+	"summary.reports[].sections": "fleet, top, alerts, disk and checks (default all)",
+	"summary.reports[].top":      "hosts or alerts listed in top and alerts (default 3)",
+	"summary.reports[].checks":   "numeric custom checks shown in checks, e.g. missed blocks",
+	"routes[].group":             "match hosts of this group",
+	"routes[].tags":              "match hosts with these tags",
+	"routes[].severity":          "minimum severity, warning (default) or critical",
+	"routes[].channels":          "channels to notify",
+	"escalation[].after":         "time unacknowledged before this step",
+	"escalation[].channels":      "channels to notify",
+	"silences[].id":              "silence ID",
+	"silences[].host":            "silenced host, empty for all",
+	"silences[].check":           "silenced check, empty for all",
+	"silences[].start":           "start of the silence",
+	"silences[].end":             "end of the silence",
+	"silences[].comment":         "why the silence was added",
+}
+
+// listOptions documents the fields of each list entry type.
+var listOptions = []struct {
+	key string
+	typ reflect.Type
+}{
+	{"hosts[]", reflect.TypeOf(Host{})},
+	{"customChecks[]", reflect.TypeOf(customCheck{})},
+	{"conditions[]", reflect.TypeOf(condition{})},
+	{"summary.reports[]", reflect.TypeOf(summaryReport{})},
+	{"routes[]", reflect.TypeOf(route{})},
+	{"escalation[]", reflect.TypeOf(escalationStep{})},
+	{"silences[]", reflect.TypeOf(Silence{})},
+}
+
+// allConfigOptions returns configOptions followed by the list entry fields,
+// whose names and types come from the structs they are decoded into.
+func allConfigOptions() []configOption {
+	options := append([]configOption{}, configOptions...)
+	for _, l := range listOptions {
+		for i := 0; i < l.typ.NumField(); i++ {
+			f := l.typ.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" {
+				name = lowerFirst(f.Name)
+			}
+			key := l.key + "." + name
+			options = append(options, configOption{Key: key, Type: typeName(f.Type), Description: fieldDocs[key]})
+		}
+	}
+	return options
+}
+
+func lowerFirst(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+func typeName(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return "duration"
+	case t == reflect.TypeOf(time.Time{}):
+		return "time"
+	case t.Kind() == reflect.Slice:
+		return "[]" + typeName(t.Elem())
+	case t.Kind() == reflect.Map:
+		return "map[" + typeName(t.Key()) + "]" + typeName(t.Elem())
+	}
+	return t.Kind().String()
+}
+
+// printConfigDoc prints every option, as a table or as JSON.
+func printConfigDoc(asJSON bool) error {
+	options := allConfigOptions()
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(options)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, o := range options {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.Key, o.Type, o.Default, o.Description)
+	}
+	return w.Flush()
+}
+
+// hostConfig is the effective configuration of one host after layering the
+// global, group and host settings.
+type hostConfig struct {
+	Host         Host                 `json:"host"`
+	Thresholds   map[string]Threshold `json:"thresholds"`
+	Checks       map[string]bool      `json:"checks"`
+	Intervals    map[string]string    `json:"intervals"`
+	Channels     []string             `json:"channels"`
+	Dependencies map[string][]string  `json:"dependencies"`
+}
+
+func effectiveHostConfig(h Host) hostConfig {
+	hc := hostConfig{
+		Host:         h,
+		Thresholds:   thresholdsFor(h),
+		Checks:       map[string]bool{},
+		Intervals:    map[string]string{},
+		Channels:     hostChannels(h),
+		Dependencies: map[string][]string{},
+	}
+	checks := append([]string{"ssh", "parse"}, hostChecks...)
+	for _, c := range customChecks() {
+		checks = append(checks, c.Name)
+	}
+	for _, c := range conditions() {
+		checks = append(checks, c.Name)
+	}
+	for _, check := range checks {
+		hc.Checks[check] = checkEnabled(h, check)
+		hc.Dependencies[check] = checkDependencies(check)
+	}
+	for _, check := range []string{"health", "custom"} {
+		key := "checkIntervals." + check
+		if !conf().IsSet(key) {
+			key = "checkIntervals.default"
+		}
+		hc.Intervals[check] = conf().GetString(key)
+		if hc.Intervals[check] == "" {
+			hc.Intervals[check] = defaultCheckInterval.String()
+		}
+	}
+	for _, check := range checks {
+		if spec := hostCheckSchedule(h, check); spec != "" {
+			hc.Intervals[check] = spec
+		}
+	}
+	return hc
+}
+
+// printHostConfig prints the effective configuration of a host.
+func printHostConfig(h Host, asJSON bool) error {
+	hc := effectiveHostConfig(h)
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(hc)
+	}
+	fmt.Printf("%s\n  Command: %s\n", h.Label(), h.Command)
+	if h.Group != "" || len(h.Tags) > 0 {
+		fmt.Printf("  Group: %s  Tags: %s\n", h.Group, strings.Join(h.Tags, ", "))
+	}
+	fmt.Println("  Thresholds:")
+	for _, metric := range []string{"cpu", "memory", "disk"} {
+		t := hc.Thresholds[metric]
+		fmt.Printf("    %-7s warning %.0f%%, critical %.0f%%, clear %.0f%%\n", metric, t.Warning, t.Critical, t.Clear)
+	}
+	fmt.Println("  Checks:")
+	names := make([]string, 0, len(hc.Checks))
+	for name := range hc.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state := "on"
+		if !hc.Checks[name] {
+			state = "off"
+		}
+		line := fmt.Sprintf("    %-16s %-4s", name, state)
+		if spec, ok := hc.Intervals[name]; ok {
+			line += " every " + spec
+		}
+		if deps := hc.Dependencies[name]; len(deps) > 0 {
+			line += " depends on " + strings.Join(deps, ", ")
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	fmt.Printf("  Intervals: health %s, custom %s\n", hc.Intervals["health"], hc.Intervals["custom"])
+	if len(hc.Channels) > 0 {
+		fmt.Printf("  Channels: %s\n", strings.Join(hc.Channels, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// discoverConsul lists the instances of inventory.consul.service from the
+// Consul catalog. The service's metadata key groupMeta becomes the host
+// group and its tags the host tags.
+func discoverConsul(cfg *viper.Viper) ([]Host, error) {
+	address := strings.TrimSuffix(cfg.GetString("address"), "/")
+	if address == "" {
+		address = "http://127.0.0.1:8500"
+	}
+	query := url.Values{}
+	if dc := cfg.GetString("datacenter"); dc != "" {
+		query.Set("dc", dc)
+	}
+	for _, tag := range cfg.GetStringSlice("tags") {
+		query.Add("tag", tag)
+	}
+	endpoint := fmt.Sprintf("%s/v1/catalog/service/%s?%s", address, url.PathEscape(cfg.GetString("service")), query.Encode())
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := cfg.GetString("token"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("consul: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul: catalog returned %s", resp.Status)
+	}
+
+	var entries []struct {
+		Node           string
+		Address        string
+		ServiceID      string
+		ServiceAddress string
+		ServiceTags    []string
+		ServiceMeta    map[string]string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("consul: %w", err)
+	}
+
+	var hosts []Host
+	for _, e := range entries {
+		h := Host{Name: e.Node, Address: e.ServiceAddress, Tags: e.ServiceTags}
+		if h.Address == "" {
+			h.Address = e.Address
+		}
+		if key := cfg.GetString("groupMeta"); key != "" {
+			h.Group = e.ServiceMeta[key]
+		}
+		if err := checkDiscoveredHost(h); err != nil {
+			slog.Warn("Skipping a Consul service instance", "node", e.Node, "service", e.ServiceID, "err", err)
+			continue
+		}
+		if h.Command, err = discoveredHostCommand(cfg, h); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+var (
+	discoveredName  = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+)
+
+// checkDiscoveredHost rejects a host from a catalog that others can write
+// to, such as Consul or etcd, unless its address is an IP address or host
+// name and its name and group only use letters, digits, dots, underscores
+// and dashes. The values end up in the check command.
+func checkDiscoveredHost(h Host) error {
+	if net.ParseIP(h.Address) == nil && (len(h.Address) > 253 || !hostnamePattern.MatchString(h.Address)) {
+		return fmt.Errorf("address %q is not an IP address or host name", h.Address)
+	}
+	if !discoveredName.MatchString(h.Name) {
+		return fmt.Errorf("name %q has characters other than letters, digits, '.', '_' and '-'", h.Name)
+	}
+	if h.Group != "" && !discoveredName.MatchString(h.Group) {
+		return fmt.Errorf("group %q has characters other than letters, digits, '.', '_' and '-'", h.Group)
+	}
+	return nil
+}
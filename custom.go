@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// customCheck is a user-defined check from the customChecks section: a
+// command run on each matching host, a parser that extracts a value from
+// its output, and the condition under which it alerts.
+type customCheck struct {
+	Name      string
+	Command   string
+	Group     string
+	Tags      []string
+	Schedule  string // own schedule instead of checkIntervals.custom
+	DependsOn []string
+
+	Parser  string // "regex" (default), "json" or "exitcode"
+	Pattern string // regex with a "value" named group, or the first group
+	Path    string // dotted JSON path, e.g. "data.peers.0.height"
+
+	Operator  string // >, >=, <, <=, == or !=
+	Threshold string
+	Severity  string // "warning" (default) or "critical"
+	Message   string // template with .Host, .Name, .Value, .Operator, .Threshold
+}
+
+const defaultCustomMessage = "{{.Name}} is {{.Value}} ({{.Operator}} {{.Threshold}})"
+
+var customOperators = map[string]bool{">": true, ">=": true, "<": true, "<=": true, "==": true, "!=": true}
+
+func customChecks() []customCheck {
+	return customChecksIn(conf())
+}
+
+func customChecksIn(cfg *viper.Viper) []customCheck {
+	var checks []customCheck
+	if err := cfg.UnmarshalKey("customChecks", &checks); err != nil {
+		slog.Error("Error reading customChecks", "err", err)
+	}
+	for i := range checks {
+		if checks[i].Operator == "" {
+			checks[i].Operator = "!="
+		}
+		if checks[i].Severity == "" {
+			checks[i].Severity = "warning"
+		}
+		if checks[i].Parser == "exitcode" && checks[i].Threshold == "" {
+			checks[i].Threshold = "0"
+		}
+	}
+	return checks
+}
+
+// validate reports the first problem with a custom check definition and the
+// field it concerns.
+func (c customCheck) validate() (field string, err error) {
+	switch {
+	case c.Name == "":
+		return "name", errors.New("is required")
+	case c.Command == "":
+		return "command", errors.New("is required")
+	case c.Operator != "" && !customOperators[c.Operator]:
+		return "operator", fmt.Errorf("unknown operator %q, expected one of >, >=, <, <=, ==, !=", c.Operator)
+	case c.Severity != "" && !strings.EqualFold(c.Severity, "warning") && !strings.EqualFold(c.Severity, "critical"):
+		return "severity", fmt.Errorf("must be warning or critical")
+	}
+	switch c.Parser {
+	case "", "regex":
+		if _, err := regexp.Compile(c.Pattern); err != nil || c.Pattern == "" {
+			return "pattern", fmt.Errorf("a regular expression is required: %v", err)
+		}
+	case "json":
+		if c.Path == "" {
+			return "path", errors.New("is required for the json parser")
+		}
+	case "exitcode":
+	default:
+		return "parser", fmt.Errorf("unknown parser %q, expected regex, json or exitcode", c.Parser)
+	}
+	if c.Schedule != "" {
+		if _, err := parseSchedule(c.Schedule); err != nil {
+			return "schedule", err
+		}
+	}
+	if _, err := template.New("message").Parse(c.Message); err != nil {
+		return "message", err
+	}
+	return "", nil
+}
+
+func (c customCheck) appliesTo(h Host) bool {
+	if c.Group != "" && !strings.EqualFold(c.Group, h.Group) {
+		return false
+	}
+	for _, tag := range c.Tags {
+		if !h.HasTag(tag) {
+			return false
+		}
+	}
+	return checkEnabled(h, c.Name)
+}
+
+// value runs the check on a host and extracts the value to compare.
+func (c customCheck) value(ctx context.Context, h Host) (string, error) {
+	output, err := runHostCommand(ctx, h, remoteCommand(h, h.checkCommand(c.Name, c.Command)))
+	if c.Parser == "exitcode" {
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) {
+			return strconv.Itoa(exitErr.ExitCode()), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return "0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if c.Parser == "json" {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(output), &doc); err != nil {
+			return "", fmt.Errorf("output is not JSON: %w", err)
+		}
+		for _, part := range strings.Split(c.Path, ".") {
+			switch node := doc.(type) {
+			case map[string]interface{}:
+				doc = node[part]
+			case []interface{}:
+				i, err := strconv.Atoi(part)
+				if err != nil || i < 0 || i >= len(node) {
+					return "", fmt.Errorf("%s: no element %q", c.Path, part)
+				}
+				doc = node[i]
+			default:
+				doc = nil
+			}
+			if doc == nil {
+				return "", fmt.Errorf("%s not found in output", c.Path)
+			}
+		}
+		return cast.ToString(doc), nil
+	}
+
+	re := regexp.MustCompile(c.Pattern)
+	m := re.FindStringSubmatch(output)
+	if m == nil {
+		return "", fmt.Errorf("output does not match %s", c.Pattern)
+	}
+	if i := re.SubexpIndex("value"); i > 0 {
+		return m[i], nil
+	}
+	if len(m) > 1 {
+		return m[1], nil
+	}
+	return m[0], nil
+}
+
+// failing compares the value with the threshold, numerically if both are
+// numbers and as strings otherwise.
+func (c customCheck) failing(value string) bool {
+	op := c.Operator
+	v, verr := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	t, terr := strconv.ParseFloat(strings.TrimSpace(c.Threshold), 64)
+	if verr != nil || terr != nil {
+		switch op {
+		case "==":
+			return strings.TrimSpace(value) == c.Threshold
+		case "!=":
+			return strings.TrimSpace(value) != c.Threshold
+		}
+		return false
+	}
+	switch op {
+	case ">":
+		return v > t
+	case ">=":
+		return v >= t
+	case "<":
+		return v < t
+	case "<=":
+		return v <= t
+	case "==":
+		return v == t
+	default:
+		return v != t
+	}
+}
+
+func (c customCheck) run(ctx context.Context, h Host) {
+	start := time.Now()
+	value, err := c.value(ctx, h)
+	observeCheck(h.Name, c.Name, start, err)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		raiseAlert(Alert{Host: h.Name, Address: h.Address, Check: c.Name, Severity: SeverityWarning, Message: fmt.Sprintf("%s failed: %v", c.Name, err)})
+		return
+	}
+	slog.Debug("Custom check value", "host", h.Name, "check", c.Name, "value", value)
+	if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+		series.record(h.Name, c.Name, v, time.Now())
+		exportSample(h, map[string]float64{c.Name: v}, time.Now())
+	}
+	if !c.failing(value) {
+		clearAlert(h.Name, c.Name)
+		return
+	}
+
+	severity := SeverityWarning
+	if strings.EqualFold(c.Severity, "critical") {
+		severity = SeverityCritical
+	}
+	text := c.Message
+	if text == "" {
+		text = defaultCustomMessage
+	}
+	var b strings.Builder
+	tmpl, err := template.New("message").Parse(text)
+	if err == nil {
+		err = tmpl.Execute(&b, map[string]string{
+			"Host":      h.Label(),
+			"Name":      c.Name,
+			"Value":     value,
+			"Operator":  c.Operator,
+			"Threshold": c.Threshold,
+		})
+	}
+	if err != nil {
+		slog.Error("Invalid custom check message", "check", c.Name, "err", err)
+	}
+	raiseAlert(Alert{Host: h.Name, Address: h.Address, Check: c.Name, Severity: severity, Message: b.String()})
+}
+
+// runHostCustomChecks is the "custom" check type: it runs the custom checks
+// that apply to a host and don't have their own schedule.
+func runHostCustomChecks(ctx context.Context, h Host) {
+	for _, c := range customChecks() {
+		if ctx.Err() != nil {
+			return
+		}
+		if c.Schedule == "" && c.appliesTo(h) {
+			c.run(ctx, h)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hostState summarizes a host for the dashboard: "down" when it can't be
+// reached or has a critical alert, "degraded" with only warnings, else "ok".
+func hostState(h Host, active []activeAlert) string {
+	state := "ok"
+	for _, aa := range active {
+		if !strings.EqualFold(aa.Host, h.Name) {
+			continue
+		}
+		if aa.Check == "ssh" || aa.Severity >= SeverityCritical {
+			return "down"
+		}
+		state = "degraded"
+	}
+	return state
+}
+
+// chartRanges are the time ranges a host's charts can show.
+var chartRanges = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"1y", 365 * 24 * time.Hour},
+}
+
+const chartWidth, chartHeight = 600.0, 120.0
+
+// chart is one metric of a host rendered as an SVG polyline.
+type chart struct {
+	Metric string
+	Latest string
+	SVG    template.HTML
+}
+
+// renderChart draws points between from and to. Usage metrics are drawn on
+// a 0-100% scale with their warning and critical levels; other metrics are
+// scaled to their largest value.
+func renderChart(points []point, from, to time.Time, t *Threshold) template.HTML {
+	top := 100.0
+	if t == nil {
+		top = 0
+		for _, p := range points {
+			if p.Value > top {
+				top = p.Value
+			}
+		}
+		if top == 0 {
+			top = 1
+		}
+	}
+	x := func(ts time.Time) float64 {
+		return chartWidth * float64(ts.Sub(from)) / float64(to.Sub(from))
+	}
+	y := func(v float64) float64 {
+		return chartHeight - chartHeight*v/top
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %.0f %.0f" width="%.0f" height="%.0f" class="chart">`, chartWidth, chartHeight, chartWidth, chartHeight)
+	if t != nil {
+		for _, level := range []struct {
+			value float64
+			class string
+		}{{t.Warning, "warning"}, {t.Critical, "critical"}} {
+			if level.value > 0 {
+				fmt.Fprintf(&b, `<line x1="0" x2="%.0f" y1="%.1f" y2="%.1f" class="%s"/>`, chartWidth, y(level.value), y(level.value), level.class)
+			}
+		}
+	}
+	var coords []string
+	for _, p := range points {
+		coords = append(coords, fmt.Sprintf("%.1f,%.1f", x(p.Time), y(p.Value)))
+	}
+	fmt.Fprintf(&b, `<polyline points="%s"/>`, strings.Join(coords, " "))
+	fmt.Fprintf(&b, `<text x="2" y="12">%s</text></svg>`, formatValue(top))
+	return template.HTML(b.String())
+}
+
+func formatValue(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>checkhealth</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 4px 12px; text-align: left; border-bottom: 1px solid #ddd; }
+.ok { color: #2a7; } .degraded { color: #c80; } .down { color: #c22; } .stale { color: #888; }
+.chart { background: #fafafa; border: 1px solid #ddd; }
+.chart polyline { fill: none; stroke: #36c; stroke-width: 1.5; }
+.chart line.warning { stroke: #c80; stroke-dasharray: 4; }
+.chart line.critical { stroke: #c22; stroke-dasharray: 4; }
+.chart text { font-size: 10px; fill: #888; }
+</style>
+<script>
+// Redraw the page from the server whenever a check reports something new.
+var events = new EventSource("/api/v1/events"), pending;
+["result", "sample", "alert"].forEach(function (type) {
+  events.addEventListener(type, function () {
+    clearTimeout(pending);
+    pending = setTimeout(function () {
+      fetch(location.href).then(function (r) { return r.text(); }).then(function (html) {
+        document.body.innerHTML = new DOMParser().parseFromString(html, "text/html").body.innerHTML;
+      });
+    }, 1000);
+  });
+});
+</script></head><body>
+{{if .Host}}
+<p><a href="/dashboard">All hosts</a></p>
+<h1>{{.Host.Name}} <span class="{{.State}}">{{.State}}</span></h1>
+{{if .Stale}}<p class="stale">No recent result of {{.Stale}}</p>{{end}}
+<p>{{range .Ranges}}{{if eq . $.Range}}<b>{{.}}</b>{{else}}<a href="?range={{.}}">{{.}}</a>{{end}} {{end}}</p>
+{{range .Charts}}<h3>{{.Metric}} <small>{{.Latest}}</small></h3>{{.SVG}}
+{{else}}<p>No samples yet.</p>{{end}}
+{{if .Incidents}}<h2>Incidents</h2>
+<table><tr><th>#</th><th>Start</th><th>End</th><th>Duration</th><th>Severity</th><th>Checks</th></tr>
+{{range .Incidents}}<tr><td><a href="/api/v1/incidents/{{.ID}}">{{.ID}}</a></td><td>{{.Start}}</td><td>{{.End}}</td><td>{{.Duration}}</td><td>{{.Severity}}</td><td>{{.Checks}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Timeline}}<h2>Timeline</h2>
+<table><tr><th>Time</th><th>Event</th><th>Check</th><th></th></tr>
+{{range .Timeline}}<tr><td>{{.Time}}</td><td>{{.Kind}}</td><td>{{.Check}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>{{end}}
+{{else}}
+<h1>Hosts</h1>
+<table><tr><th>Host</th><th>Group</th><th>State</th><th>CPU</th><th>Memory</th><th>Disk</th><th>Last seen</th></tr>
+{{range .Hosts}}<tr><td><a href="/dashboard/hosts/{{.Name}}">{{.Name}}</a></td><td>{{.Group}}</td><td class="{{.State}}">{{.State}}</td>
+<td>{{index .Values "cpu"}}</td><td>{{index .Values "memory"}}</td><td>{{index .Values "disk"}}</td><td>{{.LastSeen}}{{if .Stale}} <span class="stale">stale</span>{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+</body></html>
+`))
+
+// timelineRow is an event on a host's dashboard page.
+type timelineRow struct {
+	Time, Kind, Check, Message string
+}
+
+// incidentRow is an incident on a host's dashboard page.
+type incidentRow struct {
+	ID                                     int64
+	Start, End, Duration, Severity, Checks string
+}
+
+type dashboardRow struct {
+	Name, Group, State, LastSeen string
+	Stale                        bool
+	Values                       map[string]string
+}
+
+// staleChecks lists the stale results of a host status.
+func staleChecks(s hostStatus) string {
+	var names []string
+	for _, c := range s.Checks {
+		if c.Stale {
+			names = append(names, c.Check)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	active := alerts.list()
+	loc := displayLocation()
+	scope := requestScope(r)
+	var rows []dashboardRow
+	for _, h := range configuredHosts() {
+		if !scope.allows(h.Group) {
+			continue
+		}
+		s := results.status(h, active)
+		row := dashboardRow{Name: h.Name, Group: h.Group, State: hostState(h, active), Values: map[string]string{}}
+		for _, metric := range []string{"cpu", "memory", "disk"} {
+			if v, ok := s.Values[metric]; ok {
+				row.Values[metric] = formatValue(v) + "%"
+			}
+		}
+		if s.LastSeen != nil {
+			row.LastSeen = localClock(*s.LastSeen, loc)
+		}
+		row.Stale = staleChecks(s) != ""
+		rows = append(rows, row)
+	}
+	render(w, map[string]interface{}{"Hosts": rows})
+}
+
+func dashboardHostHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := scopedHost(r)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no host %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	rng := chartRanges[0]
+	var names []string
+	for _, cr := range chartRanges {
+		names = append(names, cr.Name)
+		if cr.Name == r.FormValue("range") {
+			rng = cr
+		}
+	}
+
+	to := time.Now()
+	from := to.Add(-rng.Duration)
+	thresholds := thresholdsFor(h)
+	metrics := series.metrics(h.Name)
+	sort.Slice(metrics, func(i, j int) bool {
+		// Usage metrics first, in their usual order, then the rest by name.
+		oi, oj := metricOrder(metrics[i]), metricOrder(metrics[j])
+		if oi != oj {
+			return oi < oj
+		}
+		return metrics[i] < metrics[j]
+	})
+	var charts []chart
+	for _, metric := range metrics {
+		points := series.query(h.Name, metric, from)
+		if len(points) == 0 {
+			continue
+		}
+		var t *Threshold
+		latest := formatValue(points[len(points)-1].Value)
+		if th, ok := thresholds[metric]; ok {
+			t = &th
+			latest += "%"
+		}
+		charts = append(charts, chart{Metric: metric, Latest: latest, SVG: renderChart(points, from, to, t)})
+	}
+	active := alerts.list()
+	render(w, map[string]interface{}{
+		"Host":      h,
+		"State":     hostState(h, active),
+		"Stale":     staleChecks(results.status(h, active)),
+		"Range":     rng.Name,
+		"Ranges":    names,
+		"Charts":    charts,
+		"Incidents": incidentRows(h, from, to),
+		"Timeline":  timelineRows(h, from, to),
+	})
+}
+
+// incidentRows lists the incidents of a host between from and to, latest
+// first.
+func incidentRows(h Host, from, to time.Time) []incidentRow {
+	list, err := listIncidents(h.Name, from, to)
+	if err != nil {
+		slog.Error("Error listing incidents", "host", h.Name, "err", err)
+		return nil
+	}
+	loc := displayLocation()
+	rows := make([]incidentRow, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		inc := list[i]
+		row := incidentRow{
+			ID:       inc.ID,
+			Start:    inc.Start.In(loc).Format("Jan 2 15:04"),
+			End:      "ongoing",
+			Duration: formatSeconds(inc.Duration),
+			Severity: inc.Severity,
+			Checks:   strings.Join(inc.Checks, ", "),
+		}
+		if inc.End != nil {
+			row.End = inc.End.In(loc).Format("Jan 2 15:04")
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// timelineRows lists the timeline of a host between from and to, latest
+// first.
+func timelineRows(h Host, from, to time.Time) []timelineRow {
+	list, err := hostTimeline(h.Name, from, to)
+	if err != nil {
+		slog.Error("Error listing timeline", "host", h.Name, "err", err)
+		return nil
+	}
+	loc := displayLocation()
+	rows := make([]timelineRow, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		e := list[i]
+		rows = append(rows, timelineRow{Time: e.Time.In(loc).Format("Jan 2 15:04:05"), Kind: e.Kind, Check: e.Check, Message: e.Message})
+	}
+	return rows
+}
+
+func metricOrder(metric string) int {
+	for i, m := range []string{"cpu", "memory", "disk", "load1", "load5", "load15", "cores", "net_rx", "net_tx"} {
+		if m == metric {
+			return i
+		}
+	}
+	return 100
+}
+
+func render(w http.ResponseWriter, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		slog.Error("Error rendering dashboard", "err", err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerDebugHandlers serves net/http/pprof under /debug/pprof/ and expvar
+// under /debug/vars, including the sizes of the in-memory stores, for
+// diagnosing memory growth of long-running monitors. They are only
+// registered with http.debug set.
+func registerDebugHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+}
+
+func init() {
+	expvar.Publish("stores", expvar.Func(func() interface{} {
+		return map[string]int{
+			"activeAlerts":  len(alerts.list()),
+			"historyPoints": series.size(),
+			"samples":       history.size(),
+			"eventClients":  events.size(),
+		}
+	}))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+)
+
+// checkDependencies returns the checks an alert depends on. They come from
+// dependencies.<check>, or the dependsOn list of a custom check or
+// condition; by default every check depends on the host's ssh check. A
+// dependency is a check on the same host or "host/check" on another one,
+// e.g. the bastion's ssh check.
+func checkDependencies(check string) []string {
+	for name := range conf().GetStringMap("dependencies") {
+		if strings.EqualFold(name, check) {
+			return conf().GetStringSlice("dependencies." + name)
+		}
+	}
+	for _, c := range customChecks() {
+		if strings.EqualFold(c.Name, check) && c.DependsOn != nil {
+			return c.DependsOn
+		}
+	}
+	for _, c := range conditions() {
+		if strings.EqualFold(c.Name, check) && c.DependsOn != nil {
+			return c.DependsOn
+		}
+	}
+	if check == "ssh" {
+		return nil
+	}
+	return []string{"ssh"}
+}
+
+// failedDependency returns the key of an active alert that an alert
+// depends on, so one "host down" alert is sent instead of a cascade of
+// per-check failures.
+func failedDependency(a Alert) (string, bool) {
+	for _, dep := range checkDependencies(a.Check) {
+		key := a.Host + "/" + dep
+		if strings.Contains(dep, "/") {
+			key = dep
+		}
+		if key == a.Key() {
+			continue
+		}
+		if _, active := alerts.get(key); active {
+			return key, true
+		}
+	}
+	return "", false
+}
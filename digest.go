@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type digestEntry struct {
+	first, last Alert
+	count       int
+}
+
+type digestBuffer struct {
+	mu      sync.Mutex
+	title   string
+	pending map[string]map[string]*digestEntry // host -> check -> entry
+	groups  map[string]string                  // host -> group, for the hosts in pending
+}
+
+func newDigestBuffer(title string) *digestBuffer {
+	return &digestBuffer{title: title, pending: map[string]map[string]*digestEntry{}, groups: map[string]string{}}
+}
+
+var digest = newDigestBuffer("Warning digest")
+
+func (d *digestBuffer) add(a Alert) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	checks, ok := d.pending[a.Host]
+	if !ok {
+		checks = map[string]*digestEntry{}
+		d.pending[a.Host] = checks
+		h, ok := hostByName(a.Host)
+		if !ok {
+			h, _ = externalHost(a.Host)
+		}
+		d.groups[a.Host] = h.Group
+	}
+	name := a.Check
+	if a.Resolved {
+		name += " (resolved)"
+	}
+	e, ok := checks[name]
+	if !ok {
+		e = &digestEntry{first: a}
+		checks[name] = e
+	}
+	e.last = a
+	e.count++
+}
+
+// flush sends one message per group, and one per host that has none,
+// summarising the warnings collected since the previous flush by host.
+// Repeats of the same check are collapsed into a count. A group's message
+// goes to the main chat, a host's into its topic.
+func (d *digestBuffer) flush(send func(host, text string)) {
+	d.mu.Lock()
+	pending, groups := d.pending, d.groups
+	d.pending, d.groups = map[string]map[string]*digestEntry{}, map[string]string{}
+	d.mu.Unlock()
+
+	byGroup := map[string][]string{}
+	var ungrouped []string
+	for host := range pending {
+		if g := groups[host]; g != "" {
+			byGroup[g] = append(byGroup[g], host)
+		} else {
+			ungrouped = append(ungrouped, host)
+		}
+	}
+	names := make([]string, 0, len(byGroup))
+	for g := range byGroup {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	now := time.Now().In(displayLocation()).Format(timestampLayout)
+
+	for _, g := range names {
+		hosts := byGroup[g]
+		sort.Strings(hosts)
+		lines := []string{fmt.Sprintf("%s for group %s, %s:", d.title, g, now)}
+		for _, host := range hosts {
+			lines = append(lines, digestLabel(host, pending[host])+":")
+			lines = append(lines, digestLines(pending[host], "  ")...)
+		}
+		send("", strings.Join(lines, "\n"))
+	}
+	sort.Strings(ungrouped)
+	for _, host := range ungrouped {
+		lines := []string{fmt.Sprintf("%s for %s, %s:", d.title, digestLabel(host, pending[host]), now)}
+		lines = append(lines, digestLines(pending[host], "")...)
+		send(host, strings.Join(lines, "\n"))
+	}
+}
+
+// digestLabel names a host in a digest as its alerts do.
+func digestLabel(host string, checks map[string]*digestEntry) string {
+	for _, e := range checks {
+		return hostLabel(host, e.last.Address)
+	}
+	return host
+}
+
+// digestLines lists a host's collected warnings, one line per check.
+func digestLines(checks map[string]*digestEntry, indent string) []string {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		e := checks[name]
+		line := fmt.Sprintf("%s- %s", indent, e.last.Message)
+		if e.last.Resolved {
+			line = fmt.Sprintf("%s- RESOLVED: %s", indent, e.last.Message)
+		}
+		if e.count > 1 {
+			line += fmt.Sprintf(" (x%d since %s)", e.count, localClock(e.first.Time, displayLocation()))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func runDigest(ctx context.Context) {
+	window := conf().GetString("digest.window")
+	if window == "" {
+		window = "15m"
+	}
+	slog.Info("Digest mode enabled, sending warnings on schedule", "schedule", window)
+	runScheduled(ctx, scheduleSetting("digest.window", 15*time.Minute), false, func() {
+		digest.flush(sendTelegramHostMessage)
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestAdd(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	d := newDigestBuffer("Warning digest")
+	for _, a := range []Alert{
+		{Host: "val1", Check: "cpu", Message: "cpu 81%", Time: at},
+		{Host: "val1", Check: "cpu", Message: "cpu 84%", Time: at.Add(time.Minute)},
+		{Host: "val1", Check: "disk", Message: "disk 85%", Time: at},
+		{Host: "rpc1", Check: "cpu", Message: "cpu 90%", Time: at},
+	} {
+		d.add(a)
+	}
+
+	tests := []struct {
+		host, check string
+		message     string
+		count       int
+		since       time.Time
+	}{
+		{"val1", "cpu", "cpu 84%", 2, at},
+		{"val1", "disk", "disk 85%", 1, at},
+		{"rpc1", "cpu", "cpu 90%", 1, at},
+	}
+	for _, tt := range tests {
+		e := d.pending[tt.host][tt.check]
+		if e == nil {
+			t.Errorf("%s/%s: not collected", tt.host, tt.check)
+			continue
+		}
+		if e.last.Message != tt.message || e.count != tt.count || !e.first.Time.Equal(tt.since) {
+			t.Errorf("%s/%s: %q x%d since %s, want %q x%d since %s", tt.host, tt.check,
+				e.last.Message, e.count, e.first.Time, tt.message, tt.count, tt.since)
+		}
+	}
+	if len(d.pending) != 2 {
+		t.Errorf("collected %d hosts, want 2", len(d.pending))
+	}
+}
+
+func TestDigestFlush(t *testing.T) {
+	useConfig(t, `
+hosts:
+  - name: val1
+    command: "true"
+    group: validators
+  - name: val2
+    command: "true"
+    group: validators
+  - name: rpc1
+    command: "true"
+`)
+	at := time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	d := newDigestBuffer("Warning digest")
+	for _, a := range []Alert{
+		{Host: "val2", Check: "disk", Message: "disk 85%", Time: at},
+		{Host: "val1", Check: "cpu", Message: "cpu 81%", Time: at},
+		{Host: "val1", Check: "cpu", Message: "cpu 84%", Time: at.Add(time.Minute)},
+		{Host: "rpc1", Check: "memory", Message: "memory 90%", Time: at},
+	} {
+		d.add(a)
+	}
+
+	type message struct{ host, text string }
+	var got []message
+	d.flush(func(host, text string) {
+		// Drop the time of the flush from the first line.
+		first, rest, _ := strings.Cut(text, "\n")
+		first, _, _ = strings.Cut(first, ", ")
+		got = append(got, message{host, first + "\n" + rest})
+	})
+	want := []message{
+		{"", "Warning digest for group validators\nval1:\n  - cpu 84% (x2 since 03:04)\nval2:\n  - disk 85%"},
+		{"rpc1", "Warning digest for rpc1\n- memory 90%"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %q,\nwant %q", got, want)
+	}
+
+	d.flush(func(host, text string) { t.Errorf("flushed again: %q", text) })
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+)
+
+type escalationStep struct {
+	After    time.Duration
+	Channels []string
+}
+
+func escalationChain() []escalationStep {
+	return escalationChainIn(conf())
+}
+
+func escalationChainIn(cfg *viper.Viper) []escalationStep {
+	var steps []escalationStep
+	if err := cfg.UnmarshalKey("escalation", &steps); err != nil {
+		slog.Error("Error reading escalation chain", "err", err)
+	}
+	return steps
+}
+
+// escalate re-sends every unacknowledged critical alert to the next step of
+// the chain once it has been active for longer than that step's delay.
+func escalate(chain []escalationStep) {
+	now := time.Now()
+	alerts.mu.Lock()
+	var due []activeAlert
+	for _, aa := range alerts.active {
+		if aa.Severity < SeverityCritical || aa.suppressed(now) || aa.Level >= len(chain) {
+			continue
+		}
+		if now.Sub(aa.Since) < chain[aa.Level].After {
+			continue
+		}
+		due = append(due, *aa)
+		aa.Level++
+	}
+	alerts.mu.Unlock()
+
+	for _, aa := range due {
+		step := chain[aa.Level]
+		for _, name := range step.Channels {
+			ch, err := channelByName(name)
+			if err != nil {
+				slog.Error("Error escalating alert", "host", aa.Host, "check", aa.Check, "err", err)
+				continue
+			}
+			escalated := aa.Alert
+			escalated.Message = fmt.Sprintf("ESCALATED (unacknowledged for %s): %s", time.Since(aa.Since).Round(time.Minute), aa.Message)
+			err = notify(name, ch, escalated)
+			if err != nil {
+				slog.Error("Escalation failed", "host", aa.Host, "check", aa.Check, "channel", name, "err", err)
+			}
+			auditAlert("escalated", escalated, name, errorResult(err))
+		}
+	}
+}
+
+// runEscalation escalates due alerts every 30 seconds until ctx is done. The
+// chain is read each time, so a reloaded escalation applies right away.
+func runEscalation(ctx context.Context) {
+	runScheduled(ctx, cron.Every(30*time.Second), false, func() {
+		if isLeader() {
+			escalate(escalationChain())
+		}
+	})
+}
+
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	scope := requestScope(r)
+	list := []activeAlert{}
+	for _, aa := range alerts.list() {
+		if scope.allowsHost(aa.Host) {
+			list = append(list, aa)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func ackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.FormValue("key")
+	host, _, _ := strings.Cut(key, "/")
+	if !requestScope(r).allowsHost(host) || !alerts.ack(key) {
+		http.Error(w, fmt.Sprintf("no active alert %q", key), http.StatusNotFound)
+		return
+	}
+	slog.Info("Alert acknowledged", "alert", key)
+	recordAudit(auditEvent{Event: "ack", Alert: key, Channel: "http", Result: "Acknowledged via API"})
+	recordAck(key, "http")
+	fmt.Fprintf(w, "Alert %s acknowledged.", key)
+}
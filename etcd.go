@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// discoverEtcd reads hosts stored under inventory.etcd.prefix through the
+// etcd v3 JSON gateway. Each value is a JSON host object with the same fields
+// as a hosts entry; the last key segment is used as the name if it has none
+// and the command template fills in a missing command.
+func discoverEtcd(cfg *viper.Viper) ([]Host, error) {
+	endpoint := strings.TrimSuffix(cfg.GetString("endpoint"), "/")
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:2379"
+	}
+	prefix := cfg.GetString("prefix")
+
+	token := ""
+	if user := cfg.GetString("username"); user != "" {
+		var auth struct {
+			Token string `json:"token"`
+		}
+		err := etcdCall(endpoint+"/v3/auth/authenticate", "", map[string]string{
+			"name":     user,
+			"password": cfg.GetString("password"),
+		}, &auth)
+		if err != nil {
+			return nil, err
+		}
+		token = auth.Token
+	}
+
+	var resp struct {
+		Kvs []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	err := etcdCall(endpoint+"/v3/kv/range", token, map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd(prefix)),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []Host
+	for _, kv := range resp.Kvs {
+		key, _ := base64.StdEncoding.DecodeString(kv.Key)
+		value, _ := base64.StdEncoding.DecodeString(kv.Value)
+		var h Host
+		if err := json.Unmarshal(value, &h); err != nil {
+			return nil, fmt.Errorf("etcd: %s: %w", key, err)
+		}
+		if h.Name == "" {
+			h.Name = path.Base(string(key))
+		}
+		if h.Command == "" {
+			if err := checkDiscoveredHost(h); err != nil {
+				slog.Warn("Skipping an etcd host", "key", string(key), "err", err)
+				continue
+			}
+			if h.Command, err = discoveredHostCommand(cfg, h); err != nil {
+				return nil, err
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+func etcdCall(url, token string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("etcd: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd: %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// prefixEnd returns the range end that selects every key with the prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
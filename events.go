@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// event is a change pushed to /api/v1/events subscribers: a check result
+// ("result"), a host's health values ("sample") or an alert being raised or
+// resolved ("alert").
+type event struct {
+	Type string
+	Host string
+	Data interface{}
+}
+
+// eventBroker fans events out to the connected clients. Slow clients miss
+// events rather than holding up the checks.
+type eventBroker struct {
+	mu      sync.Mutex
+	clients map[chan event]bool
+}
+
+var events = &eventBroker{clients: map[chan event]bool{}}
+
+func (b *eventBroker) subscribe() chan event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan event, 16)
+	b.clients[ch] = true
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, ch)
+}
+
+func (b *eventBroker) size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+func (b *eventBroker) publish(typ, host string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.clients {
+		select {
+		case ch <- event{Type: typ, Host: host, Data: data}:
+		default:
+		}
+	}
+}
+
+// eventsHandler streams events as Server-Sent Events until the client goes
+// away.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	scope := requestScope(r)
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	// Comments keep proxies from closing an idle stream.
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-ch:
+			if !scope.allowsHost(e.Host) {
+				continue
+			}
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				slog.Error("Error encoding event", "type", e.Type, "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// alertEvent is the data of an "alert" event.
+type alertEvent struct {
+	Host     string    `json:"host"`
+	Check    string    `json:"check"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+	Resolved bool      `json:"resolved"`
+}
+
+func publishAlert(a Alert) {
+	events.publish("alert", a.Host, alertEvent{
+		Host:     a.Host,
+		Check:    a.Check,
+		Severity: strings.ToLower(a.Severity.String()),
+		Message:  a.Message,
+		Time:     a.Time,
+		Resolved: a.Resolved,
+	})
+}
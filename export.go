@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyExport is a host's metric and alert history over a time range.
+type historyExport struct {
+	Host    string             `json:"host"`
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Metrics map[string][]point `json:"metrics"`
+	Alerts  []auditEvent       `json:"alerts"`
+}
+
+// exportHistory collects the metric history kept for a host and its alerts
+// from the audit log between from and to.
+func exportHistory(h Host, from, to time.Time) (historyExport, error) {
+	e := historyExport{Host: h.Name, From: from, To: to, Metrics: map[string][]point{}}
+	for _, metric := range series.metrics(h.Name) {
+		var points []point
+		for _, p := range series.query(h.Name, metric, from) {
+			if !p.Time.After(to) {
+				points = append(points, p)
+			}
+		}
+		if len(points) > 0 {
+			e.Metrics[metric] = points
+		}
+	}
+	alerts, err := queryAudit(auditFilter{host: h.Name, since: from, until: to})
+	e.Alerts = alerts
+	return e, err
+}
+
+// writeCSV writes the export as one row per metric value or alert event,
+// ordered by time.
+func (e historyExport) writeCSV(w io.Writer) error {
+	type row struct {
+		t      time.Time
+		fields []string
+	}
+	var rows []row
+	for metric, points := range e.Metrics {
+		for _, p := range points {
+			rows = append(rows, row{p.Time, []string{"metric", metric, strconv.FormatFloat(p.Value, 'f', -1, 64), "", "", ""}})
+		}
+	}
+	for _, a := range e.Alerts {
+		check := strings.TrimPrefix(a.Alert, e.Host+"/")
+		rows = append(rows, row{a.Time, []string{"alert", check, "", a.Event, a.Severity, a.Message}})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].t.Before(rows[j].t) })
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "kind", "name", "value", "event", "severity", "message"})
+	for _, r := range rows {
+		cw.Write(append([]string{r.t.Format(time.RFC3339)}, r.fields...))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseTimeArg accepts an RFC 3339 time or a duration such as 24h meaning
+// that long before now.
+func parseTimeArg(v string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration such as 24h", v)
+	}
+	return t, nil
+}
+
+// apiHistoryHandler exports a host's history between from (default 24h ago)
+// and to (default now) as JSON, or as CSV with format=csv.
+func apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := scopedHost(r)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no host %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	from, to := now.Add(-24*time.Hour), now
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.FormValue(name); v != "" {
+			parsed, err := parseTimeArg(v, now)
+			if err != nil {
+				http.Error(w, name+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	e, err := exportHistory(h, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.FormValue("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", h.Name+".csv"))
+		e.writeCSV(w)
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+// localServer is the URL of the daemon's HTTP server on this machine.
+func localServer() string {
+	scheme := "http"
+	if conf().GetString("http.tls.cert") != "" || conf().GetBool("http.tls.selfSigned") {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(listenAddress())
+	if err != nil {
+		return scheme + "://localhost:8002"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// fetchHistory downloads a host's history from the running daemon, which is
+// the one keeping it, and copies it to stdout.
+func fetchHistory(server, token, host, from, to, format string) error {
+	q := url.Values{"from": {from}, "format": {format}}
+	if to != "" {
+		q.Set("to", to)
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(server, "/")+"/api/v1/hosts/"+url.PathEscape(host)+"/history?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// metricSample is a set of values collected from one host at one time: the
+// health check values or the numeric result of a custom check.
+type metricSample struct {
+	Host   Host
+	Values map[string]float64
+	Time   time.Time
+}
+
+// sampleExporter forwards samples to an external metrics system. Export
+// must not block the checks.
+type sampleExporter interface {
+	Export(s metricSample)
+}
+
+// resultExporter is implemented by exporters that also forward the result
+// of every check.
+type resultExporter interface {
+	ExportResult(h Host, check string, ok bool, message string, t time.Time)
+}
+
+// flushingExporter is implemented by exporters that batch, to send what is
+// pending when the monitor stops.
+type flushingExporter interface {
+	Flush()
+}
+
+// exporterTypes builds the exporter configured under exporters.<name>.
+var exporterTypes = map[string]func(cfg *viper.Viper) (sampleExporter, error){
+	"influxdb":    newInfluxExporter,
+	"remoteWrite": newRemoteWriteExporter,
+	"statsd":      newStatsdExporter,
+}
+
+var exporters []sampleExporter
+
+// newExporter builds the exporter configured under exporters.<name>.
+func newExporter(name string) (sampleExporter, error) {
+	return newExporterIn(conf(), name)
+}
+
+func newExporterIn(cfg *viper.Viper, name string) (sampleExporter, error) {
+	for typ, newType := range exporterTypes {
+		if strings.EqualFold(typ, name) {
+			return newType(cfg.Sub("exporters." + name))
+		}
+	}
+	return nil, fmt.Errorf("unknown exporter %q", name)
+}
+
+// startExporters sets up every configured exporter.
+func startExporters() error {
+	var names []string
+	for name := range conf().GetStringMap("exporters") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e, err := newExporter(name)
+		if err != nil {
+			return fmt.Errorf("exporters.%s: %w", name, err)
+		}
+		exporters = append(exporters, e)
+		slog.Info("Exporting samples", "exporter", name)
+	}
+	return nil
+}
+
+// exportSample hands a sample to every exporter.
+func exportSample(h Host, values map[string]float64, t time.Time) {
+	for _, e := range exporters {
+		e.Export(metricSample{Host: h, Values: values, Time: t})
+	}
+}
+
+// flushExporters sends the pending batches of every exporter.
+func flushExporters() {
+	for _, e := range exporters {
+		if f, ok := e.(flushingExporter); ok {
+			f.Flush()
+		}
+	}
+}
+
+// exportResult hands a check result to every exporter that takes them.
+func exportResult(host, check string, ok bool, message string, t time.Time) {
+	var h Host
+	for _, e := range exporters {
+		re, takes := e.(resultExporter)
+		if !takes {
+			continue
+		}
+		if h.Name == "" {
+			if h, takes = hostByName(host); !takes {
+				h = Host{Name: host}
+			}
+		}
+		re.ExportResult(h, check, ok, message, t)
+	}
+}
+
+// batch collects items and flushes them together once interval has passed
+// since the first of them, so exporters send one request per batch.
+type batch[T any] struct {
+	name     string
+	interval time.Duration
+	flush    func([]T) error
+
+	mu      sync.Mutex
+	pending []T
+}
+
+func (b *batch[T]) add(item T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		time.AfterFunc(b.interval, b.send)
+	}
+	b.pending = append(b.pending, item)
+}
+
+func (b *batch[T]) send() {
+	b.mu.Lock()
+	items := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	if len(items) == 0 {
+		return // flushed early
+	}
+
+	if err := b.flush(items); err != nil {
+		slog.Error("Export failed", "exporter", b.name, "items", len(items), "err", err)
+	}
+}
+
+// flushInterval is cfg's flushInterval, default 10s.
+func flushInterval(cfg *viper.Viper) time.Duration {
+	if d := cfg.GetDuration("flushInterval"); d > 0 {
+		return d
+	}
+	return 10 * time.Second
+}
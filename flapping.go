@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flapDetector counts how often each check changes between failing and OK.
+// A check with flapping.changes state changes within flapping.window is
+// flapping: its individual alerts and recoveries are held back and one
+// notice is sent instead.
+type flapDetector struct {
+	mu       sync.Mutex
+	changes  map[string][]time.Time
+	flapping map[string]bool
+}
+
+var flaps = &flapDetector{changes: map[string][]time.Time{}, flapping: map[string]bool{}}
+
+func flapSettings() (window time.Duration, changes int) {
+	window = conf().GetDuration("flapping.window")
+	if window <= 0 {
+		window = 30 * time.Minute
+	}
+	return window, conf().GetInt("flapping.changes")
+}
+
+// record notes a state change of an alert and reports whether the check is
+// now flapping and whether it just started to.
+func (d *flapDetector) record(key string, now time.Time) (flapping, started bool) {
+	window, threshold := flapSettings()
+	if threshold <= 0 {
+		return false, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.changes[key] = append(recentChanges(d.changes[key], now.Add(-window)), now)
+	if d.flapping[key] {
+		return true, false
+	}
+	if len(d.changes[key]) >= threshold {
+		d.flapping[key] = true
+		return true, true
+	}
+	return false, false
+}
+
+func (d *flapDetector) isFlapping(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flapping[key]
+}
+
+// settle ends flapping for checks that changed state fewer than half the
+// threshold times within the window and returns their keys.
+func (d *flapDetector) settle(now time.Time) []string {
+	window, threshold := flapSettings()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var settled []string
+	for key, changes := range d.changes {
+		changes = recentChanges(changes, now.Add(-window))
+		d.changes[key] = changes
+		if d.flapping[key] && len(changes) <= threshold/2 {
+			delete(d.flapping, key)
+			settled = append(settled, key)
+		}
+		if len(changes) == 0 {
+			delete(d.changes, key)
+		}
+	}
+	return settled
+}
+
+func recentChanges(changes []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(changes) && changes[i].Before(since) {
+		i++
+	}
+	return changes[i:]
+}
+
+// notifyFlapping tells the main chat that a check started flapping.
+func notifyFlapping(a Alert) {
+	window, changes := flapSettings()
+	auditAlert("flapping", a, "", "")
+	sendTelegramHostMessage(a.Host, fmt.Sprintf("Check %s on %s is flapping (%d state changes within %s), individual alerts are suppressed until it settles",
+		a.Check, hostLabel(a.Host, a.Address), changes, window))
+}
+
+// runFlapping periodically ends flapping for checks that have settled and
+// reports their current state.
+func runFlapping() {
+	for {
+		time.Sleep(time.Minute)
+		for _, key := range flaps.settle(time.Now()) {
+			state := "OK"
+			if aa, ok := alerts.get(key); ok {
+				state = aa.Alert.String()
+			}
+			slog.Info("Alert stopped flapping", "alert", key)
+			host := key[:strings.LastIndex(key, "/")]
+			sendTelegramHostMessage(host, fmt.Sprintf("%s is no longer flapping, currently: %s", key, state))
+		}
+	}
+}
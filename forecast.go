@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// forecastMetrics are the metrics whose trend can be predicted.
+var forecastMetrics = []string{"disk", "memory"}
+
+// trendCheck is the name of the alert predicting that a metric crosses its
+// threshold, e.g. disk-trend.
+func trendCheck(metric string) string {
+	return metric + "-trend"
+}
+
+// forecastConfig is the forecast block: a line is fitted to each metric's
+// history over window, and a warning is raised when it crosses the metric's
+// critical threshold (or 100% without one) within horizon.
+type forecastConfig struct {
+	Metrics []string
+	Window  time.Duration
+	Horizon time.Duration
+}
+
+func forecastSettings() (forecastConfig, bool) {
+	if !conf().GetBool("forecast.enabled") {
+		return forecastConfig{}, false
+	}
+	cfg := forecastConfig{
+		Metrics: conf().GetStringSlice("forecast.metrics"),
+		Window:  conf().GetDuration("forecast.window"),
+		Horizon: conf().GetDuration("forecast.horizon"),
+	}
+	if len(cfg.Metrics) == 0 {
+		cfg.Metrics = forecastMetrics
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 24 * time.Hour
+	}
+	if cfg.Horizon <= 0 {
+		cfg.Horizon = 72 * time.Hour
+	}
+	return cfg, true
+}
+
+// minForecastPoints is how many samples a fit needs, and they must span at
+// least a tenth of the window, so a few samples after a restart don't
+// extrapolate noise.
+const minForecastPoints = 10
+
+// fitLine returns the least squares slope (per second) and the value at
+// the time of the last point.
+func fitLine(points []point) (slope, last float64, ok bool) {
+	if len(points) < 2 {
+		return 0, 0, false
+	}
+	t0 := points[0].Time
+	var n, sx, sy, sxx, sxy float64
+	for _, p := range points {
+		x := p.Time.Sub(t0).Seconds()
+		n++
+		sx += x
+		sy += p.Value
+		sxx += x * x
+		sxy += x * p.Value
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, 0, false
+	}
+	slope = (n*sxy - sx*sy) / d
+	intercept := (sy - slope*sx) / n
+	return slope, intercept + slope*points[len(points)-1].Time.Sub(t0).Seconds(), true
+}
+
+// predictCrossing returns how long until the fitted trend of points
+// reaches target, if it is rising towards it.
+func predictCrossing(points []point, target float64) (time.Duration, float64, bool) {
+	slope, last, ok := fitLine(points)
+	if !ok || slope <= 0 || last >= target {
+		return 0, slope, false
+	}
+	return time.Duration((target - last) / slope * float64(time.Second)), slope, true
+}
+
+// formatETA rounds a duration for an alert: "~3 days", "~5 hours",
+// "~20 minutes" or "about a minute".
+func formatETA(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("~%d days", int(math.Round(d.Hours()/24)))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("~%d hours", int(math.Round(d.Hours())))
+	case d >= 2*time.Minute:
+		return fmt.Sprintf("~%d minutes", int(math.Round(d.Minutes())))
+	default:
+		return "about a minute"
+	}
+}
+
+// checkTrends raises or clears the trend alerts of a host from its history.
+func checkTrends(h Host, values map[string]float64) {
+	cfg, on := forecastSettings()
+	if !on {
+		return
+	}
+	thresholds := thresholdsFor(h)
+	now := time.Now()
+	for _, metric := range cfg.Metrics {
+		check := trendCheck(metric)
+		if !checkEnabled(h, metric) || !checkEnabled(h, check) {
+			clearAlert(h.Name, check)
+			continue
+		}
+		target := thresholds[metric].Critical
+		if target <= 0 {
+			target = 100
+		}
+		points := series.query(h.Name, metric, now.Add(-cfg.Window))
+		if len(points) < minForecastPoints || points[len(points)-1].Time.Sub(points[0].Time) < cfg.Window/10 {
+			continue
+		}
+		eta, slope, rising := predictCrossing(points, target)
+		if !rising || eta > cfg.Horizon {
+			clearAlert(h.Name, check)
+			continue
+		}
+		// Once it is above the target the threshold alert takes over.
+		if values[metric] >= target {
+			clearAlert(h.Name, check)
+			continue
+		}
+		what := fmt.Sprintf("%s will reach %.0f%%", metricNames[metric], target)
+		if target >= 100 {
+			what = strings.TrimSuffix(metricNames[metric], " usage") + " will be full"
+		}
+		raiseAlert(Alert{
+			Host:     h.Name,
+			Address:  h.Address,
+			Check:    check,
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("%s in %s (%.2f%% now, %+.2f%%/day)", what, formatETA(eta), values[metric], slope*86400),
+		})
+	}
+}
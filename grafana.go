@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// grafanaAnnotations remembers the annotations created for active alerts, so
+// they can be turned into regions ending when the alert resolves. Its lock is
+// held during the requests so an alert's resolution waits for its creation.
+var grafanaAnnotations = struct {
+	sync.Mutex
+	ids map[string][]int64 // alert key -> annotation IDs
+}{ids: map[string][]int64{}}
+
+// annotateAlert marks a raised alert on the dashboards of grafana.dashboards
+// (or organization-wide without any), and ends those annotations when it
+// resolves. Grafana is called in the background.
+func annotateAlert(a Alert) {
+	base := strings.TrimSuffix(conf().GetString("grafana.url"), "/")
+	if base == "" {
+		return
+	}
+	go func() {
+		grafanaAnnotations.Lock()
+		defer grafanaAnnotations.Unlock()
+		var err error
+		if a.Resolved {
+			err = endAnnotations(base, a)
+		} else {
+			err = createAnnotations(base, a)
+		}
+		if err != nil {
+			slog.Error("Error annotating Grafana", "host", a.Host, "check", a.Check, "err", err)
+		}
+	}()
+}
+
+func createAnnotations(base string, a Alert) error {
+	tags := append([]string{"checkhealth", a.Host, a.Check, strings.ToLower(a.Severity.String())}, conf().GetStringSlice("grafana.tags")...)
+	dashboards := conf().GetStringSlice("grafana.dashboards")
+	if len(dashboards) == 0 {
+		dashboards = []string{""}
+	}
+	for _, uid := range dashboards {
+		body := map[string]interface{}{
+			"time": a.Time.UnixMilli(),
+			"tags": tags,
+			"text": a.String(),
+		}
+		if uid != "" {
+			body["dashboardUID"] = uid
+		}
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		if err := grafanaRequest(http.MethodPost, base+"/api/annotations", body, &resp); err != nil {
+			return err
+		}
+		grafanaAnnotations.ids[a.Key()] = append(grafanaAnnotations.ids[a.Key()], resp.ID)
+	}
+	return nil
+}
+
+func endAnnotations(base string, a Alert) error {
+	ids := grafanaAnnotations.ids[a.Key()]
+	delete(grafanaAnnotations.ids, a.Key())
+
+	for _, id := range ids {
+		body := map[string]interface{}{"timeEnd": a.Time.UnixMilli(), "text": a.String()}
+		if err := grafanaRequest(http.MethodPatch, fmt.Sprintf("%s/api/annotations/%d", base, id), body, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func grafanaRequest(method, url string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+conf().GetString("grafana.token"))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("grafana returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// With ha.enabled, monitors sharing a history store elect a leader through
+// a lease in the store, which the leader renews every third of ha.lease
+// (default 30s). Only the leader runs the checks, sends notifications and
+// answers Telegram buttons. The others stand by, showing the alerts the
+// leader last saved, and one takes over once the lease runs out: within
+// ha.lease of the leader or its host dying, or at its next renewal when the
+// leader stops and gives up the lease. Each monitor needs its own
+// history.instance, and their clocks must agree.
+
+// leaderLease is the name of the lease the monitors compete for.
+const leaderLease = "leader"
+
+var leadership = struct {
+	sync.Mutex
+	enabled bool
+	leader  bool
+	holder  string        // the leader as last seen, this monitor or another
+	changed chan struct{} // closed, and replaced, when leader changes
+}{changed: make(chan struct{})}
+
+// isLeader reports whether this monitor leads, as it always does without
+// ha.enabled.
+func isLeader() bool {
+	leadership.Lock()
+	defer leadership.Unlock()
+	return !leadership.enabled || leadership.leader
+}
+
+// leaderChanged returns a channel that is closed when this monitor becomes
+// the leader or stands by.
+func leaderChanged() <-chan struct{} {
+	leadership.Lock()
+	defer leadership.Unlock()
+	return leadership.changed
+}
+
+// currentLeader is the instance that leads, or "" without ha.enabled.
+func currentLeader() string {
+	leadership.Lock()
+	defer leadership.Unlock()
+	if !leadership.enabled {
+		return ""
+	}
+	return leadership.holder
+}
+
+func haLease() time.Duration {
+	return conf().GetDuration("ha.lease")
+}
+
+func setLeader(leader bool, holder string) {
+	leadership.Lock()
+	defer leadership.Unlock()
+	leadership.holder = holder
+	if leader == leadership.leader {
+		return
+	}
+	leadership.leader = leader
+	close(leadership.changed)
+	leadership.changed = make(chan struct{})
+	if leader {
+		isLeaderGauge.Set(1)
+	} else {
+		isLeaderGauge.Set(0)
+	}
+}
+
+// followLeader makes the active alerts those last saved by the leader.
+func followLeader(store historyStore, leader string) {
+	var active []activeAlert
+	if err := getState(store, "alerts/"+leader, &active); err != nil {
+		slog.Error("Error loading the leader's alerts", "leader", leader, "err", err)
+		return
+	}
+	alerts.replace(active)
+}
+
+// elector claims the lease for this monitor, or renews it, and follows the
+// outcome.
+type elector struct {
+	store   historyStore
+	self    string
+	renewed time.Time // when this monitor last got the lease
+}
+
+func (e *elector) elect(now time.Time) {
+	lease := haLease()
+	previous, held, err := e.store.claimLease(leaderLease, e.self, now, now.Add(lease))
+	wasLeader := isLeader()
+	switch {
+	case err != nil:
+		slog.Error("Error claiming the leader lease", "err", err)
+		// Another monitor may take over once the lease runs out, so stop
+		// before that rather than both sending.
+		if wasLeader && now.Sub(e.renewed) >= lease-lease/3 {
+			slog.Warn("Standing by, the leader lease could not be renewed")
+			setLeader(false, "")
+		}
+	case held:
+		e.renewed = now
+		if !wasLeader {
+			// Carry on from the alerts the old leader last saved, so they
+			// aren't sent again.
+			if previous != "" && previous != e.self {
+				followLeader(e.store, previous)
+			}
+			slog.Info("Became the leader", "previous", previous)
+		}
+		setLeader(true, e.self)
+	default:
+		if wasLeader {
+			slog.Warn("Standing by, another monitor took the leader lease", "leader", previous)
+		}
+		setLeader(false, previous)
+		followLeader(e.store, previous)
+	}
+}
+
+// startElection claims the lease, so a monitor that gets it starts its checks
+// right away, and keeps renewing or claiming it until ctx is done. The
+// returned function waits for that and gives up the lease, after the final
+// alerts are saved, so a standby can take over at once. Without ha.enabled
+// it does nothing.
+func startElection(ctx context.Context) (release func()) {
+	store := series.storage()
+	if !conf().GetBool("ha.enabled") || store == nil {
+		return func() {}
+	}
+	leadership.Lock()
+	leadership.enabled = true
+	leadership.Unlock()
+	isLeaderGauge.Set(0)
+
+	e := &elector{store: store, self: historyInstance()}
+	e.elect(time.Now())
+	if !isLeader() {
+		slog.Info("Standing by", "leader", currentLeader(), "lease", haLease())
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(haLease() / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.elect(time.Now())
+			}
+		}
+	}()
+	return func() {
+		<-done
+		if !isLeader() {
+			return
+		}
+		if err := store.releaseLease(leaderLease, e.self); err != nil {
+			slog.Error("Error giving up the leader lease", "err", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lastHeartbeat is when heartbeat.url was last pinged.
+var lastHeartbeat = struct {
+	sync.Mutex
+	time.Time
+}{}
+
+// pingHeartbeat tells a dead man's switch such as healthchecks.io that the
+// monitor is alive after heartbeat.loop (default health) completed on a
+// host, at most once per heartbeat.interval (default 1m). If the pings
+// stop, that service alerts about the monitor itself.
+func pingHeartbeat(loop string) {
+	check := loopCheck(loop)
+	target := conf().GetString("heartbeat.url")
+	want := conf().GetString("heartbeat.loop")
+	if want == "" {
+		want = "health"
+	}
+	if target == "" || !strings.EqualFold(check, want) {
+		return
+	}
+	interval := conf().GetDuration("heartbeat.interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	lastHeartbeat.Lock()
+	if time.Since(lastHeartbeat.Time) < interval {
+		lastHeartbeat.Unlock()
+		return
+	}
+	lastHeartbeat.Time = time.Now()
+	lastHeartbeat.Unlock()
+
+	go func() {
+		if err := sendHeartbeat(target); err != nil {
+			slog.Error("Error pinging the heartbeat URL", "err", err)
+		}
+	}()
+}
+
+func sendHeartbeat(target string) error {
+	resp, err := httpClient.Get(target)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("heartbeat returned %s", resp.Status)
+	}
+	return nil
+}
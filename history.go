@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+)
+
+// point is one value of a metric at a time.
+type point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// metricHistory keeps every host's metric values and check results in the
+// history store (see historyStore), by default the SQLite database at
+// history.database (default history.db), for history.retention (default
+// 7d), so they survive restarts. The metrics are the health check values
+// (cpu, memory, disk, load, cores and network) and the numeric results of
+// custom checks, e.g. a validator's block lag. Older samples are downsampled
+// (see rollupTier). Until the daemon opens the store nothing is recorded.
+type metricHistory struct {
+	mu    sync.Mutex
+	store historyStore
+}
+
+var series = &metricHistory{}
+
+func historyRetention() time.Duration {
+	return historyRetentionIn(conf())
+}
+
+func historyRetentionIn(cfg *viper.Viper) time.Duration {
+	if d := cfg.GetDuration("history.retention"); d > 0 {
+		return d
+	}
+	return 7 * 24 * time.Hour
+}
+
+func historyPath() string {
+	return conf().GetString("history.database")
+}
+
+// openHistory opens (or creates) the history store.
+func openHistory() error {
+	store, err := openStore(historyBackend(), historyPath())
+	if err != nil {
+		return err
+	}
+	series.mu.Lock()
+	series.store = store
+	series.mu.Unlock()
+	return nil
+}
+
+// closeHistory closes the history store, after which nothing is recorded.
+func closeHistory() {
+	series.mu.Lock()
+	store := series.store
+	series.store = nil
+	series.mu.Unlock()
+	if store != nil {
+		if err := store.close(); err != nil {
+			slog.Error("Error closing the history", "err", err)
+		}
+	}
+}
+
+func (m *metricHistory) storage() historyStore {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.store
+}
+
+func (m *metricHistory) record(host, metric string, value float64, t time.Time) {
+	store := m.storage()
+	if store == nil {
+		return
+	}
+	if err := store.addSample(host, metric, value, t); err != nil {
+		slog.Error("Error recording sample", "host", host, "metric", metric, "err", err)
+	}
+}
+
+// recordResult stores the result of a check on a host.
+func (m *metricHistory) recordResult(host, check string, ok bool, message string, t time.Time) {
+	store := m.storage()
+	if store == nil {
+		return
+	}
+	if err := store.addResult(host, checkResult{Check: check, OK: ok, Message: message, Time: t}); err != nil {
+		slog.Error("Error recording result", "host", host, "check", check, "err", err)
+	}
+}
+
+// query returns the points of a host's metric taken since from.
+func (m *metricHistory) query(host, metric string, from time.Time) []point {
+	store := m.storage()
+	if store == nil {
+		return nil
+	}
+	points, err := store.points(host, metric, from)
+	if err != nil {
+		slog.Error("Error querying history", "host", host, "metric", metric, "err", err)
+	}
+	return points
+}
+
+// metrics lists the metrics recorded for a host.
+func (m *metricHistory) metrics(host string) []string {
+	store := m.storage()
+	if store == nil {
+		return nil
+	}
+	names, err := store.metrics(host)
+	if err != nil {
+		slog.Error("Error listing metrics", "host", host, "err", err)
+	}
+	return names
+}
+
+// metricStats summarizes a host's values of a metric over a time range.
+type metricStats struct {
+	Host                       string
+	Count                      int
+	Avg, Min, Max, First, Last float64
+}
+
+// stats summarizes every host's values of a metric between from and to.
+func (m *metricHistory) stats(metric string, from, to time.Time) ([]metricStats, error) {
+	store := m.storage()
+	if store == nil {
+		return nil, nil
+	}
+	return store.stats(metric, from, to)
+}
+
+// size is the number of points kept for all hosts.
+func (m *metricHistory) size() int {
+	store := m.storage()
+	if store == nil {
+		return 0
+	}
+	n, _ := store.size()
+	return n
+}
+
+// retentionRule deletes what is older than retention from a table.
+type retentionRule struct {
+	table     string
+	retention time.Duration
+}
+
+// prune downsamples the samples past the retention, or deletes them without
+// rollups, and deletes everything else that is older than its retention.
+func (m *metricHistory) prune(now time.Time) {
+	store := m.storage()
+	if store == nil {
+		return
+	}
+	rules := []retentionRule{
+		{"results", historyRetention()},
+		{"alert_history", alertRetention()},
+		{"host_events", alertRetention()},
+		{"incidents", alertRetention()},
+	}
+	if rollupsEnabled() {
+		n, err := store.rollup(rollupTiers(), now)
+		if err != nil {
+			slog.Error("Error downsampling history", "err", err)
+		} else if n > 0 {
+			slog.Debug("Downsampled history", "samples", n)
+		}
+		rules = append(rules, retentionRule{"samples_1h", hourlyRetention()})
+	} else {
+		for _, table := range []string{"samples", "samples_5m", "samples_1h"} {
+			rules = append(rules, retentionRule{table, historyRetention()})
+		}
+	}
+	for _, t := range rules {
+		n, err := store.prune(t.table, now.Add(-t.retention))
+		if err != nil {
+			slog.Error("Error pruning history", "table", t.table, "err", err)
+			return
+		}
+		if n > 0 {
+			slog.Debug("Pruned history", "table", t.table, "rows", n, "retention", t.retention)
+		}
+	}
+}
+
+// runHistoryPruning applies the retention every hour until ctx is done.
+func runHistoryPruning(ctx context.Context) {
+	runScheduled(ctx, cron.Every(time.Hour), true, func() { series.prune(time.Now()) })
+}
+
+// restoreResults loads the latest check results, health check values and
+// last-seen times from the database, so the API and dashboard show what was
+// known before a restart.
+func restoreResults(r *hostResults) error {
+	store := series.storage()
+	if store == nil {
+		return nil
+	}
+	latest, err := store.latestResults(time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, c := range latest {
+		r.restore(c.Host, c.checkResult)
+	}
+	values, err := store.latestValues([]string{"cpu", "memory", "disk", "load1", "load5", "load15", "cores", "net_rx", "net_tx"})
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		r.restoreValue(v.Host, v.Metric, v.Value, v.Time)
+	}
+	slog.Debug("Restored check results from the history", "results", len(latest))
+	return nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// Host is one monitored server. Name is the display name used in alerts;
+// Address defaults to the target of the host's ssh command.
+type Host struct {
+	Name    string   `json:"name"`
+	Address string   `json:"address,omitempty"`
+	Command string   `json:"command"`
+	Group   string   `json:"group,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+
+	// Agent is set for hosts that a checkhealth agent reports for, rather
+	// than being checked over SSH. Command, if set, is run by the agent.
+	Agent bool `json:"agent,omitempty"`
+
+	// Checks turns individual checks on or off for this host, overriding
+	// the host's group.
+	Checks map[string]bool `json:"checks,omitempty"`
+
+	// Without a command, the health check command is built from the ssh
+	// defaults and these per-host settings.
+	User         string `json:"user,omitempty"`
+	Port         int    `json:"port,omitempty"`
+	IdentityFile string `json:"identityFile,omitempty"`
+
+	// Commands overrides the command of other checks on this host, keyed by
+	// check name, e.g. a different log path.
+	Commands map[string]string `json:"commands,omitempty"`
+
+	// Host-level layers of settings that otherwise come from the host's
+	// group or the global config.
+	Thresholds     map[string]map[string]float64 `json:"thresholds,omitempty"`
+	CheckIntervals map[string]string             `json:"checkIntervals,omitempty"`
+	Channels       []string                      `json:"channels,omitempty"`
+}
+
+// Settings are layered: global defaults, then the host's group under
+// groups.<name>, then the host's own entry. The lookups below resolve one
+// setting through those layers.
+
+// checkCommand returns the command a check runs on a host: the host's
+// override from commands, else its group's, else def.
+func (h Host) checkCommand(check, def string) string {
+	for name, command := range h.Commands {
+		if strings.EqualFold(name, check) {
+			return command
+		}
+	}
+	if cfg := groupSetting(h.Group, "commands"); cfg != nil && cfg.IsSet(check) {
+		return cfg.GetString(check)
+	}
+	return def
+}
+
+// sshDefaults holds the ssh settings (user, port, identityFile and the
+// command template) for hosts given only by address: the global ssh block
+// with the group's ssh block on top.
+func sshDefaults(group string) *viper.Viper {
+	return sshDefaultsIn(conf(), group)
+}
+
+func sshDefaultsIn(config *viper.Viper, group string) *viper.Viper {
+	v := viper.New()
+	if cfg := config.Sub("ssh"); cfg != nil {
+		v.MergeConfigMap(cfg.AllSettings())
+	}
+	if cfg := groupSettingIn(config, group, "ssh"); cfg != nil {
+		v.MergeConfigMap(cfg.AllSettings())
+	}
+	return v
+}
+
+// hostChannels are the extra channels that get all of a host's alerts: the
+// host's channels, else its group's.
+func hostChannels(h Host) []string {
+	if h.Channels != nil {
+		return h.Channels
+	}
+	if h.Group == "" {
+		return nil
+	}
+	for name := range conf().GetStringMap("groups") {
+		if strings.EqualFold(name, h.Group) {
+			return conf().GetStringSlice("groups." + name + ".channels")
+		}
+	}
+	return nil
+}
+
+// Label is how a host appears in messages and logs: "name (address)".
+func (h Host) Label() string {
+	return hostLabel(h.Name, h.Address)
+}
+
+func hostLabel(name, address string) string {
+	if address == "" || strings.EqualFold(address, name) {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, address)
+}
+
+func (h Host) HasTag(tag string) bool {
+	for _, t := range h.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredHosts returns the hosts from the legacy SSHCommands list followed
+// by the entries of the hosts list and then any inventory hosts. Hosts
+// without a name are numbered "Server N" by position, and hosts without a
+// command get one built from the ssh defaults.
+func configuredHosts() []Host {
+	var hosts []Host
+	for _, command := range conf().GetStringSlice("SSHCommands") {
+		hosts = append(hosts, Host{Name: fmt.Sprintf("Server %d", len(hosts)+1), Command: command})
+	}
+
+	var entries []Host
+	if err := conf().UnmarshalKey("hosts", &entries); err != nil {
+		slog.Error("Error reading hosts", "err", err)
+	}
+	for _, h := range append(entries, inventory.hosts()...) {
+		if h.Name == "" {
+			h.Name = fmt.Sprintf("Server %d", len(hosts)+1)
+		}
+		if h.Command == "" && h.Agent {
+			h.Command = healthScript
+		}
+		if h.Command == "" && h.Address != "" {
+			command, err := hostCommand(sshDefaults(h.Group), h, map[string]string{
+				"User":         h.User,
+				"Port":         portString(h.Port),
+				"IdentityFile": h.IdentityFile,
+			})
+			if err != nil {
+				slog.Error("Error building the SSH command", "host", h.Name, "err", err)
+			}
+			h.Command = command
+		}
+		hosts = append(hosts, h)
+	}
+	for i := range hosts {
+		if hosts[i].Address == "" {
+			hosts[i].Address, _ = parseSSHCommand(hosts[i].Command)
+		}
+	}
+	return hosts
+}
+
+// healthScript is the remote part of the default health check command; its
+// output is what parseSSHOutput expects.
+const healthScript = "echo 'Uptime:' && uptime && echo 'CPU Usage:' && top -bn1 | grep 'Cpu(s)' && echo 'Memory Usage:' && free -m && echo 'Disk Usage:' && df -h / && echo 'Cores:' && nproc && echo 'Network:' && cat /proc/net/dev"
+
+const defaultCommandTemplate = `ssh {{if .IdentityFile}}-i {{quote .IdentityFile}} {{end}}{{if .Port}}-p {{quote .Port}} {{end}}{{if .User}}{{quote .User}}@{{end}}{{quote .Address}} {{quote .Script}}`
+
+// shellSafe matches words that sh takes literally.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_~-]+$`)
+
+// shellQuote quotes s as a single sh word. Words sh takes literally are left
+// as they are to keep commands readable. Command templates run with sh -c
+// and their values may come from inventory providers, e.g. EC2 tags, so the
+// default template quotes every value.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// discoveredHostCommand builds the check command for a host found by an
+// inventory provider from the provider's command template. The template can
+// use .Name, .Address, .Group, .User, .Port, .IdentityFile and .Script, and
+// quote to shell-quote them.
+func discoveredHostCommand(cfg *viper.Viper, h Host) (string, error) {
+	return hostCommand(cfg, h, nil)
+}
+
+// hostCommand is discoveredHostCommand with per-host values for User, Port
+// and IdentityFile, which take precedence over the provider settings.
+func hostCommand(cfg *viper.Viper, h Host, overrides map[string]string) (string, error) {
+	text := cfg.GetString("command")
+	if text == "" {
+		text = defaultCommandTemplate
+	}
+	tmpl, err := template.New("command").Funcs(template.FuncMap{"quote": shellQuote}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("command template: %w", err)
+	}
+	data := map[string]string{
+		"Name":         h.Name,
+		"Address":      h.Address,
+		"Group":        h.Group,
+		"User":         cfg.GetString("user"),
+		"Port":         cfg.GetString("port"),
+		"IdentityFile": cfg.GetString("identityFile"),
+		"Script":       healthScript,
+	}
+	for k, v := range overrides {
+		if v != "" {
+			data[k] = v
+		}
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, data)
+	return b.String(), err
+}
+
+// sshArgFlags are the ssh options that take a value.
+const sshArgFlags = "BbcDEeFIiJLlmOopQRSWw"
+
+// parseSSHCommand finds the target host and identity file (-i) of the ssh
+// invocation in a shell command. The host is empty if there is no ssh call.
+func parseSSHCommand(command string) (host, identity string) {
+	fields := strings.Fields(command)
+	target, identity := sshTarget(fields)
+	if target < 0 {
+		return "", identity
+	}
+	host = strings.Trim(fields[target], `"'`)
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	return host, identity
+}
+
+// sshTarget returns the index of the ssh destination among the fields of a
+// command, or -1, along with the identity file given with -i.
+func sshTarget(fields []string) (target int, identity string) {
+	start := -1
+	for i, f := range fields {
+		if strings.Trim(f, `"'`) == "ssh" {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return -1, ""
+	}
+
+	for i := start + 1; i < len(fields); i++ {
+		f := strings.Trim(fields[i], `"'`)
+		if strings.HasPrefix(f, "-") && len(f) >= 2 {
+			if !strings.ContainsRune(sshArgFlags, rune(f[1])) {
+				continue
+			}
+			value := f[2:]
+			if value == "" && i+1 < len(fields) {
+				i++
+				value = strings.Trim(fields[i], `"'`)
+			}
+			if f[1] == 'i' {
+				identity = value
+			}
+			continue
+		}
+		return i, identity
+	}
+	return -1, identity
+}
+
+// remoteCommand returns a command that runs script on the host over the same
+// ssh connection as its health check command. Hosts without an ssh command
+// run the script locally.
+func remoteCommand(h Host, script string) string {
+	fields := strings.Fields(h.Command)
+	target, _ := sshTarget(fields)
+	if target < 0 {
+		return script
+	}
+	return strings.Join(fields[:target+1], " ") + " " + shellQuote(script)
+}
+
+func portString(port int) string {
+	if port == 0 {
+		return ""
+	}
+	return strconv.Itoa(port)
+}
+
+// hostChecks are the checks that can be toggled per host or group. "health"
+// is the whole SSH health check; cpu, memory and disk are its individual
+// metrics. "timeline" watches the services and files of timeline. "stale"
+// alerts when results stop coming in.
+var hostChecks = []string{"health", "cpu", "memory", "disk", "timeline", "stale"}
+
+// checkEnabled reports whether a check runs on a host: the host's checks
+// entry wins, then the checks block of its group. Checks are on by default.
+func checkEnabled(h Host, check string) bool {
+	for name, on := range h.Checks {
+		if strings.EqualFold(name, check) {
+			return on
+		}
+	}
+	if cfg := groupSetting(h.Group, "checks"); cfg != nil && cfg.IsSet(check) {
+		return cfg.GetBool(check)
+	}
+	return true
+}
+
+func hostByName(name string) (Host, bool) {
+	for _, h := range configuredHosts() {
+		if strings.EqualFold(h.Name, name) {
+			return h, true
+		}
+	}
+	return Host{}, false
+}
+
+// groupSetting returns the settings block of a host group, if any.
+func groupSetting(group, key string) *viper.Viper {
+	return groupSettingIn(conf(), group, key)
+}
+
+func groupSettingIn(cfg *viper.Viper, group, key string) *viper.Viper {
+	if group == "" {
+		return nil
+	}
+	for name := range cfg.GetStringMap("groups") {
+		if strings.EqualFold(name, group) {
+			return cfg.Sub("groups." + name + "." + key)
+		}
+	}
+	return nil
+}
+
+type groupStatus struct {
+	Group    string   `json:"group"`
+	Hosts    int      `json:"hosts"`
+	Degraded []string `json:"degraded"`
+}
+
+func (g groupStatus) String() string {
+	return fmt.Sprintf("%d/%d %s nodes degraded", len(g.Degraded), g.Hosts, g.Group)
+}
+
+// groupStatuses counts, per group, the hosts that currently have an active
+// alert.
+func groupStatuses() []groupStatus {
+	degraded := map[string]bool{}
+	for _, aa := range alerts.list() {
+		degraded[strings.ToLower(aa.Host)] = true
+	}
+
+	byGroup := map[string]*groupStatus{}
+	for _, h := range configuredHosts() {
+		if h.Group == "" {
+			continue
+		}
+		g, ok := byGroup[h.Group]
+		if !ok {
+			g = &groupStatus{Group: h.Group, Degraded: []string{}}
+			byGroup[h.Group] = g
+		}
+		g.Hosts++
+		if degraded[strings.ToLower(h.Name)] {
+			g.Degraded = append(g.Degraded, h.Name)
+		}
+	}
+
+	statuses := make([]groupStatus, 0, len(byGroup))
+	for _, g := range byGroup {
+		statuses = append(statuses, *g)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Group < statuses[j].Group })
+	return statuses
+}
+
+func groupsHandler(w http.ResponseWriter, r *http.Request) {
+	scope := requestScope(r)
+	list := []groupStatus{}
+	for _, g := range groupStatuses() {
+		if scope.allows(g.Group) {
+			list = append(list, g)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
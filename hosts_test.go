@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"10.0.0.1", "10.0.0.1"},
+		{"~/.ssh/id_ed25519", "~/.ssh/id_ed25519"},
+		{"", "''"},
+		{"web 1", "'web 1'"},
+		{"x; reboot", "'x; reboot'"},
+		{"it's", `'it'\''s'`},
+		{"$(id)", "'$(id)'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHostCommand(t *testing.T) {
+	cfg := viper.New()
+	cfg.Set("user", "ubuntu")
+	cfg.Set("port", 2222)
+	got, err := hostCommand(cfg, Host{Name: "web1", Address: "10.0.0.1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ssh -p 2222 ubuntu@10.0.0.1 " + shellQuote(healthScript); got != want {
+		t.Errorf("command %s, want %s", got, want)
+	}
+	if address, _ := parseSSHCommand(got); address != "10.0.0.1" {
+		t.Errorf("ssh target %q, want 10.0.0.1", address)
+	}
+
+	got, err = hostCommand(viper.New(), Host{Name: "web1", Address: "x; reboot"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ssh 'x; reboot' " + shellQuote(healthScript); got != want {
+		t.Errorf("command %s, want %s", got, want)
+	}
+}
+
+// TestHostCommandQuotesValues runs the commands built for hostile values and
+// checks that each value arrives as a single argument.
+func TestHostCommandQuotesValues(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "pwned")
+	values := []string{
+		"x; touch " + marker,
+		"$(touch " + marker + ")",
+		"`touch " + marker + "`",
+		"a' ; touch " + marker + " ; '",
+	}
+	cfg := viper.New()
+	cfg.Set("command", `printf '%s\n' {{quote .Name}} {{quote .Address}} {{quote .Group}}`)
+	for _, value := range values {
+		h := Host{Name: value, Address: value, Group: value}
+		command, err := hostCommand(cfg, h, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+		if want := value + "\n" + value + "\n" + value + "\n"; string(out) != want {
+			t.Errorf("%s printed %q, want %q", command, out, want)
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Fatalf("%s ran the injected command", command)
+		}
+	}
+}
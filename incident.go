@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incident is a stretch of time a host had at least one active alert: it
+// starts with the host's first alert and ends when its last one resolves.
+type incident struct {
+	ID       int64         `json:"id"`
+	Host     string        `json:"host"`
+	Start    time.Time     `json:"start"`
+	End      *time.Time    `json:"end,omitempty"` // unset while ongoing
+	Duration float64       `json:"durationSeconds"`
+	Severity string        `json:"severity"` // the worst of its alerts
+	Checks   []string      `json:"checks"`
+	Alerts   []alertRecord `json:"alerts,omitempty"` // the alert history while it lasted
+}
+
+// incidentFilter selects incidents; zero fields match everything. from and
+// to select the incidents overlapping the range.
+type incidentFilter struct {
+	id       int64
+	open     bool // only the ongoing ones
+	host     string
+	from, to time.Time
+}
+
+// incidentTracker keeps the ongoing incidents by host in lower case; the
+// database has them all.
+type incidentTracker struct {
+	mu   sync.Mutex
+	open map[string]*incident
+}
+
+var incidents = &incidentTracker{open: map[string]*incident{}}
+
+// loadOpenIncidents picks up the incidents that were ongoing when the
+// monitor stopped, so the host's next alert or recovery continues them.
+func loadOpenIncidents() error {
+	list, err := findIncidents(incidentFilter{open: true})
+	if err != nil {
+		return err
+	}
+	incidents.mu.Lock()
+	defer incidents.mu.Unlock()
+	for i := range list {
+		incidents.open[strings.ToLower(list[i].Host)] = &list[i]
+	}
+	return nil
+}
+
+// raised adds a new alert to the host's ongoing incident, or starts one.
+func (t *incidentTracker) raised(a Alert) {
+	store := series.storage()
+	if store == nil {
+		return
+	}
+	severity := strings.ToLower(a.Severity.String())
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := strings.ToLower(a.Host)
+	inc, ok := t.open[key]
+	if !ok {
+		inc = &incident{Host: a.Host, Start: a.Time, Severity: severity, Checks: []string{a.Check}}
+		if err := store.addIncident(inc); err != nil {
+			slog.Error("Error recording incident", "host", a.Host, "err", err)
+			return
+		}
+		t.open[key] = inc
+		slog.Debug("Incident started", "incident", inc.ID, "host", a.Host, "check", a.Check)
+		return
+	}
+	if !contains(inc.Checks, a.Check) {
+		inc.Checks = append(inc.Checks, a.Check)
+	}
+	if a.Severity >= SeverityCritical {
+		inc.Severity = severity
+	}
+	if err := store.updateIncident(*inc); err != nil {
+		slog.Error("Error updating incident", "incident", inc.ID, "err", err)
+	}
+}
+
+// resolved is called after an alert of host resolved. It ends the host's
+// incident unless other alerts are still active, and returns its ID.
+func (t *incidentTracker) resolved(host string, now time.Time) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := strings.ToLower(host)
+	inc, ok := t.open[key]
+	if !ok {
+		return 0, false
+	}
+	for _, aa := range alerts.list() {
+		if strings.EqualFold(aa.Host, host) {
+			return inc.ID, true
+		}
+	}
+	delete(t.open, key)
+	inc.End = &now
+	if store := series.storage(); store != nil {
+		if err := store.updateIncident(*inc); err != nil {
+			slog.Error("Error ending incident", "incident", inc.ID, "err", err)
+		}
+	}
+	slog.Debug("Incident ended", "incident", inc.ID, "host", host, "duration", now.Sub(inc.Start).Round(time.Second))
+	return inc.ID, true
+}
+
+// passed ends an incident left over from before a restart once every check
+// of its host passes again without an alert to resolve.
+func (t *incidentTracker) passed(host string, now time.Time) {
+	t.mu.Lock()
+	_, ok := t.open[strings.ToLower(host)]
+	t.mu.Unlock()
+	if ok {
+		t.resolved(host, now)
+	}
+}
+
+// findIncidents returns the incidents matching f by start, with their
+// durations so far.
+func findIncidents(f incidentFilter) ([]incident, error) {
+	store := series.storage()
+	if store == nil {
+		return []incident{}, nil
+	}
+	list, err := store.incidents(f)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range list {
+		end := now
+		if list[i].End != nil {
+			end = *list[i].End
+		}
+		list[i].Duration = end.Sub(list[i].Start).Seconds()
+	}
+	return list, nil
+}
+
+// listIncidents returns the incidents of host (all hosts if empty) that
+// overlap from to to; zero times are unbounded.
+func listIncidents(host string, from, to time.Time) ([]incident, error) {
+	return findIncidents(incidentFilter{host: host, from: from, to: to})
+}
+
+// incidentByID returns an incident with the alert history of its host while
+// it lasted.
+func incidentByID(id int64) (incident, bool, error) {
+	list, err := findIncidents(incidentFilter{id: id})
+	if err != nil || len(list) == 0 {
+		return incident{}, false, err
+	}
+	inc := list[0]
+	f := alertHistoryFilter{host: inc.Host, from: inc.Start}
+	if inc.End != nil {
+		f.to = *inc.End
+	}
+	inc.Alerts, err = queryAlertHistory(f)
+	return inc, true, err
+}
+
+// apiIncidentsHandler lists the incidents filtered by host, from and to.
+func apiIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAlertHistoryFilter(r.FormValue("host"), "", r.FormValue("from"), r.FormValue("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := listIncidents(f.host, f.from, f.to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if scope := requestScope(r); scope != nil {
+		visible := []incident{}
+		for _, inc := range list {
+			if scope.allowsHost(inc.Host) {
+				visible = append(visible, inc)
+			}
+		}
+		list = visible
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Start.After(list[j].Start) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// apiIncidentHandler returns one incident with its alerts.
+func apiIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid incident ID", http.StatusBadRequest)
+		return
+	}
+	inc, ok, err := incidentByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok || !requestScope(r).allowsHost(inc.Host) {
+		http.Error(w, fmt.Sprintf("no incident %d", id), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inc)
+}
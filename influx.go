@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// influxExporter writes samples to InfluxDB in line protocol, one point per
+// sample with the host and group as tags and every value as a field. With
+// a bucket it uses the v2 API, otherwise the v1 API and database.
+type influxExporter struct {
+	endpoint    string
+	token       string // v2
+	user, pass  string // v1
+	measurement string
+	batch       *batch[metricSample]
+}
+
+func newInfluxExporter(cfg *viper.Viper) (sampleExporter, error) {
+	base := strings.TrimSuffix(cfg.GetString("url"), "/")
+	if base == "" {
+		return nil, errors.New("url is required")
+	}
+	e := &influxExporter{
+		token:       cfg.GetString("token"),
+		user:        cfg.GetString("username"),
+		pass:        cfg.GetString("password"),
+		measurement: cfg.GetString("measurement"),
+	}
+	if e.measurement == "" {
+		e.measurement = "checkhealth"
+	}
+	q := url.Values{"precision": {"ns"}}
+	switch {
+	case cfg.GetString("bucket") != "":
+		q.Set("bucket", cfg.GetString("bucket"))
+		q.Set("org", cfg.GetString("org"))
+		e.endpoint = base + "/api/v2/write?" + q.Encode()
+	case cfg.GetString("database") != "":
+		q.Set("db", cfg.GetString("database"))
+		e.endpoint = base + "/write?" + q.Encode()
+	default:
+		return nil, errors.New("bucket (InfluxDB 2) or database (InfluxDB 1) is required")
+	}
+	e.batch = &batch[metricSample]{name: "influxdb", interval: flushInterval(cfg), flush: e.write}
+	return e, nil
+}
+
+func (e *influxExporter) Export(s metricSample) {
+	e.batch.add(s)
+}
+
+var (
+	influxTagEscaper  = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxMeasEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+)
+
+// line formats a sample as a line protocol point.
+func (e *influxExporter) Flush() {
+	e.batch.send()
+}
+
+func (e *influxExporter) line(s metricSample) string {
+	var b strings.Builder
+	b.WriteString(influxMeasEscaper.Replace(e.measurement))
+	b.WriteString(",host=" + influxTagEscaper.Replace(s.Host.Name))
+	if s.Host.Group != "" {
+		b.WriteString(",group=" + influxTagEscaper.Replace(s.Host.Group))
+	}
+	names := make([]string, 0, len(s.Values))
+	for name := range s.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		b.WriteString(sep + influxTagEscaper.Replace(name) + "=" + strconv.FormatFloat(s.Values[name], 'f', -1, 64))
+	}
+	b.WriteString(" " + strconv.FormatInt(s.Time.UnixNano(), 10))
+	return b.String()
+}
+
+func (e *influxExporter) write(samples []metricSample) error {
+	var lines []string
+	for _, s := range samples {
+		if len(s.Values) > 0 {
+			lines = append(lines, e.line(s))
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	} else if e.user != "" {
+		req.SetBasicAuth(e.user, e.pass)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influxdb returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
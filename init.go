@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/spf13/viper"
+)
+
+// prompter asks questions on the terminal for the init wizard.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, _ := p.in.ReadString('\n')
+	if line = strings.TrimSpace(line); line == "" {
+		return def
+	}
+	return line
+}
+
+func (p *prompter) askYes(question string, def bool) bool {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+	answer := strings.ToLower(p.ask(question+" ("+d+")", ""))
+	if answer == "" {
+		return def
+	}
+	return strings.HasPrefix(answer, "y")
+}
+
+func (p *prompter) askFloat(question string, def float64) float64 {
+	for {
+		answer := p.ask(question, strconv.FormatFloat(def, 'f', -1, 64))
+		f, err := strconv.ParseFloat(answer, 64)
+		if err == nil && f >= 0 && f <= 100 {
+			return f
+		}
+		fmt.Fprintln(p.out, "  Please enter a percentage between 0 and 100.")
+	}
+}
+
+type initConfigData struct {
+	Token     string
+	ChatID    int64
+	Hosts     []Host
+	Threshold map[string]Threshold
+}
+
+var initConfigTemplate = template.Must(template.New("config").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+}).Parse(`# Generated by "checkhealth init" on {{.Date}}. See config.example.yaml for
+# all settings.
+telegramBotToken: {{quote .Token}}
+telegramChatID: {{.ChatID}}
+
+hosts:
+{{- range .Hosts}}
+  - name: {{quote .Name}}
+    address: {{quote .Address}}
+    command: {{quote .Command}}
+{{- end}}
+
+thresholds:
+{{- range $metric, $t := .Threshold}}
+  {{$metric}}:
+    warning: {{$t.Warning}}
+    critical: {{$t.Critical}}
+{{- end}}
+`))
+
+// runInit implements the "init" command: it asks for the Telegram
+// credentials, hosts and thresholds, checks that the hosts and the chat can
+// be reached, and writes a starter config to path.
+func runInit(path string, force bool) error {
+	if path == "" {
+		path = "config.yaml"
+	}
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	}
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	data := initConfigData{Threshold: map[string]Threshold{}}
+
+	fmt.Println("Telegram")
+	data.Token = p.ask("  Bot token (empty to read it from $TELEGRAM_BOT_TOKEN)", "")
+	token := data.Token
+	if token == "" {
+		data.Token = "${TELEGRAM_BOT_TOKEN}"
+		token = os.Getenv("TELEGRAM_BOT_TOKEN")
+	}
+	for {
+		id, err := strconv.ParseInt(p.ask("  Chat ID", ""), 10, 64)
+		if err == nil && id != 0 {
+			data.ChatID = id
+			break
+		}
+		fmt.Println("  Please enter the numeric chat ID, e.g. -1001234567890.")
+	}
+	if token != "" && p.askYes("  Send a test message now?", true) {
+		if err := initTestMessage(token, data.ChatID); err != nil {
+			fmt.Printf("  Telegram test failed: %v\n", err)
+		} else {
+			fmt.Println("  Test message sent.")
+		}
+	}
+
+	fmt.Println("\nHosts (leave the address empty when done)")
+	for {
+		var h Host
+		h.Address = p.ask(fmt.Sprintf("  Host %d address", len(data.Hosts)+1), "")
+		if h.Address == "" {
+			if len(data.Hosts) == 0 {
+				fmt.Println("  At least one host is required.")
+				continue
+			}
+			break
+		}
+		h.Name = p.ask("    Name", h.Address)
+		user := p.ask("    SSH user", os.Getenv("USER"))
+		identity := p.ask("    Identity file (empty for the ssh default)", "")
+		command, err := hostCommand(viper.New(), h, map[string]string{"User": user, "IdentityFile": identity})
+		if err != nil {
+			return err
+		}
+		h.Command = command
+
+		fmt.Printf("    Checking %s... ", h.Label())
+		if output, err := runSSHCommand(context.Background(), h.Command); err != nil {
+			fmt.Printf("failed: %v\n", err)
+		} else if cpu, mem, disk, _, err := parseSSHOutput(output); err != nil {
+			fmt.Printf("unexpected output: %v\n", err)
+		} else {
+			fmt.Printf("OK (CPU %.0f%%, memory %.0f%%, disk %.0f%%)\n", cpu, mem, disk)
+		}
+		data.Hosts = append(data.Hosts, h)
+	}
+
+	fmt.Println("\nThresholds in percent (0 disables a level)")
+	for _, metric := range []string{"cpu", "memory", "disk"} {
+		data.Threshold[metric] = Threshold{
+			Warning:  p.askFloat("  "+metricNames[metric]+" warning", 80),
+			Critical: p.askFloat("  "+metricNames[metric]+" critical", 95),
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = initConfigTemplate.Execute(f, struct {
+		initConfigData
+		Date string
+	}{data, time.Now().Format("2006-01-02")})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nWrote %s. Run \"checkhealth validate\" to check it and \"checkhealth run\" to start monitoring.\n", path)
+	return nil
+}
+
+func initTestMessage(token string, chatID int64) error {
+	bot, err := tgbotapi.NewBotAPI(token)
+	if err != nil {
+		return err
+	}
+	_, err = bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("checkhealth %s is set up for this chat.", version)))
+	return err
+}
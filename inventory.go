@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// hostInventory holds hosts discovered outside the main config, keyed by the
+// source that provided them so each source can be refreshed independently.
+type hostInventory struct {
+	mu      sync.RWMutex
+	sources map[string][]Host
+}
+
+var inventory = &hostInventory{sources: map[string][]Host{}}
+
+func (inv *hostInventory) set(source string, hosts []Host) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	before := map[string]bool{}
+	for _, h := range inv.sources[source] {
+		before[h.Name] = true
+	}
+	var added int
+	for _, h := range hosts {
+		if !before[h.Name] {
+			added++
+		}
+		delete(before, h.Name)
+	}
+	inv.sources[source] = hosts
+	if added > 0 || len(before) > 0 {
+		slog.Info("Inventory updated", "source", source, "hosts", len(hosts), "added", added, "removed", len(before))
+	}
+}
+
+// hosts returns the hosts of all sources, ordered by source name.
+func (inv *hostInventory) hosts() []Host {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+
+	names := make([]string, 0, len(inv.sources))
+	for name := range inv.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var hosts []Host
+	for _, name := range names {
+		hosts = append(hosts, inv.sources[name]...)
+	}
+	return hosts
+}
+
+// loadInventoryFile reads the hosts list from inventory.file (YAML or JSON,
+// with the same schema as the hosts key of the main config) and reloads it
+// whenever the file changes, so other tooling can regenerate it.
+func loadInventoryFile() error {
+	path := conf().GetString("inventory.file")
+	if path == "" {
+		return nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	read := func() error {
+		if err := v.ReadInConfig(); err != nil {
+			return err
+		}
+		var hosts []Host
+		if err := v.UnmarshalKey("hosts", &hosts); err != nil {
+			return err
+		}
+		inventory.set("file", hosts)
+		return nil
+	}
+	if err := read(); err != nil {
+		return err
+	}
+
+	v.OnConfigChange(func(e fsnotify.Event) {
+		if err := read(); err != nil {
+			slog.Error("Error reloading inventory, keeping previous hosts", "path", path, "err", err)
+		}
+	})
+	v.WatchConfig()
+	return nil
+}
+
+// pollInventory refreshes an inventory source every inventory.<source>.refresh
+// (default 5m) until ctx is done, so new hosts are monitored as they appear
+// and removed ones are dropped. On errors the previous hosts are kept. The
+// source's settings are read again for every poll, so a config reload
+// applies to the next one; removing the source drops its hosts.
+func pollInventory(ctx context.Context, source string, discover func(cfg *viper.Viper) ([]Host, error)) {
+	for {
+		refresh := 5 * time.Minute
+		if cfg := conf().Sub("inventory." + source); cfg == nil {
+			inventory.set(source, nil)
+		} else {
+			if d := cfg.GetDuration("refresh"); d > 0 {
+				refresh = d
+			}
+			hosts, err := discover(cfg)
+			if err != nil {
+				slog.Error("Inventory discovery failed, keeping previous hosts", "source", source, "err", err)
+			} else {
+				inventory.set(source, hosts)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(refresh):
+		}
+	}
+}
+
+// inventoryProviders are the discovery-based inventory sources, by config key.
+var inventoryProviders = map[string]func(cfg *viper.Viper) ([]Host, error){
+	"ansible": discoverAnsible,
+	"aws":     discoverEC2,
+	"consul":  discoverConsul,
+	"etcd":    discoverEtcd,
+}
+
+func startInventoryProviders(ctx context.Context) {
+	for source, discover := range inventoryProviders {
+		if conf().IsSet("inventory." + source) {
+			go pollInventory(ctx, source, discover)
+		}
+	}
+}
+
+// discoverInventory runs every configured inventory provider once, for
+// one-shot commands that don't keep polling.
+func discoverInventory() {
+	for source, discover := range inventoryProviders {
+		cfg := conf().Sub("inventory." + source)
+		if cfg == nil {
+			continue
+		}
+		hosts, err := discover(cfg)
+		if err != nil {
+			slog.Error("Inventory discovery failed", "source", source, "err", err)
+			continue
+		}
+		inventory.set(source, hosts)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestPollInventoryRereadsConfig(t *testing.T) {
+	useConfig(t, "inventory:\n  test:\n    refresh: 5ms\n    host: a\n")
+	t.Cleanup(func() { inventory.set("test", nil) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pollInventory(ctx, "test", func(cfg *viper.Viper) ([]Host, error) {
+			return []Host{{Name: cfg.GetString("host")}}, nil
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitForHosts := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			hosts := inventory.hosts()
+			if len(hosts) == 1 && hosts[0].Name == want || want == "" && len(hosts) == 0 {
+				return
+			}
+		}
+		t.Fatalf("inventory has %v, want %q", inventory.hosts(), want)
+	}
+	waitForHosts("a")
+	useConfig(t, "inventory:\n  test:\n    refresh: 5ms\n    host: b\n")
+	waitForHosts("b")
+	useConfig(t, "")
+	waitForHosts("")
+}
+
+func TestPollInventoryStopsWithContext(t *testing.T) {
+	useConfig(t, "inventory:\n  test:\n    refresh: 1h\n")
+	t.Cleanup(func() { inventory.set("test", nil) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pollInventory(ctx, "test", func(cfg *viper.Viper) ([]Host, error) { return nil, nil })
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("still polling after the context is done")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// loadSecretDir sets a config key for every file in kubernetes.secretDir, as
+// written by a mounted Secret: the file name is the key and its content the
+// value, e.g. a file telegramBotToken or channels.ops.token. Secret values
+// take precedence over everything else.
+func loadSecretDir(v *viper.Viper) error {
+	dir := v.GetString("kubernetes.secretDir")
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// Kubernetes keeps the real files in ..data and timestamped
+		// directories; the keys are symlinks next to them.
+		if strings.HasPrefix(e.Name(), ".") || e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		v.Set(e.Name(), strings.TrimRight(string(data), "\r\n"))
+	}
+	return nil
+}
+
+// configStatus is the outcome of the last config (re)load, reported by
+// /readyz.
+type configStatus struct {
+	mu       sync.Mutex
+	loaded   time.Time
+	problems []string
+}
+
+var configState = &configStatus{}
+
+func (s *configStatus) set(problems []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loaded = time.Now()
+	s.problems = problems
+}
+
+func (s *configStatus) get() (time.Time, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loaded, s.problems
+}
+
+// reloadConfig reads the config again into an instance of its own and
+// swaps it in once it validates. If it can't be read or doesn't validate,
+// the previous config stays in effect and the problems are reported by
+// /readyz until a later reload succeeds.
+func reloadConfig() {
+	path := conf().ConfigFileUsed()
+	v, err := readConfig(path, profile)
+	var problems []string
+	if err != nil {
+		problems = []string{err.Error()}
+	} else {
+		for _, p := range validateConfig(v) {
+			problems = append(problems, p.String())
+		}
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			slog.Error("Config reload", "problem", p)
+		}
+		slog.Error("Config reload failed, keeping the previous config")
+	} else {
+		current.Store(v)
+		if level, err := parseLogLevel(configLogLevel()); err == nil {
+			logLevel.Set(level)
+		}
+		slog.Info("Reloaded config", "path", path)
+	}
+	configState.set(problems)
+}
+
+// watchKubernetesConfig reloads the config whenever the directory of the
+// config file or kubernetes.secretDir changes. Mounted ConfigMaps and
+// Secrets are updated by swapping a ..data symlink rather than writing the
+// files, so the directories are watched instead of the files. Schedules,
+// channels' connections and the HTTP server keep their startup settings.
+func watchKubernetesConfig() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := []string{filepath.Dir(conf().ConfigFileUsed())}
+	if dir := conf().GetString("kubernetes.secretDir"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("watching %s: %w", dir, err)
+		}
+	}
+
+	go func() {
+		// A single update produces a burst of events; reload once it settles.
+		var pending <-chan time.Time
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !configEvent(e.Name) {
+					continue
+				}
+				slog.Debug("Config change", "event", e)
+				pending = time.After(time.Second)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("Error watching config", "err", err)
+			case <-pending:
+				pending = nil
+				reloadConfig()
+			}
+		}
+	}()
+	return nil
+}
+
+// configEvent reports whether a change to path concerns the config: the
+// config file itself, a ConfigMap or Secret ..data swap, or a file in the
+// secret directory. Other files next to the config, such as logs, are
+// ignored.
+func configEvent(path string) bool {
+	switch {
+	case path == conf().ConfigFileUsed(), filepath.Base(path) == "..data":
+		return true
+	case conf().GetString("kubernetes.secretDir") != "":
+		return filepath.Dir(path) == filepath.Clean(conf().GetString("kubernetes.secretDir"))
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// loadTestConfigFile writes text to a config file and makes it the config
+// in effect, as at startup.
+func loadTestConfigFile(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := readConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := current.Load()
+	current.Store(v)
+	t.Cleanup(func() { current.Store(previous) })
+	return path
+}
+
+const reloadTestConfig = `
+telegramBotToken: "x"
+telegramChatID: 1
+hosts:
+  - name: a
+    command: "true"
+http:
+  auth:
+    tokens: ["secret-token"]
+`
+
+func TestReloadConfig(t *testing.T) {
+	path := loadTestConfigFile(t, reloadTestConfig)
+	tests := []struct {
+		name       string
+		config     string
+		wantChatID int64
+		problems   bool
+	}{
+		{"invalid", "telegramBotToken: \"x\"\nhosts: []\n", 1, true},
+		{"unreadable", "hosts: [\n", 1, true},
+		{"valid", strings.Replace(reloadTestConfig, "telegramChatID: 1", "telegramChatID: 2", 1), 2, false},
+		{"invalid after valid", "telegramChatID: 3\n", 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			before := conf()
+			reloadConfig()
+			_, problems := configState.get()
+			if (len(problems) > 0) != tt.problems {
+				t.Fatalf("problems %v, want some: %v", problems, tt.problems)
+			}
+			if tt.problems && conf() != before {
+				t.Error("the config was replaced by one that doesn't validate")
+			}
+			if !tt.problems && conf() == before {
+				t.Error("the config wasn't replaced")
+			}
+			if got := conf().GetInt64("telegramChatID"); got != tt.wantChatID {
+				t.Errorf("telegramChatID %d, want %d", got, tt.wantChatID)
+			}
+			if got := conf().GetStringSlice("http.auth.tokens"); len(got) != 1 {
+				t.Errorf("http.auth.tokens %v after the reload", got)
+			}
+		})
+	}
+}
+
+// TestReloadConfigConcurrently reloads while the config is read the way the
+// check loops and handlers read it. Run with -race.
+func TestReloadConfigConcurrently(t *testing.T) {
+	path := loadTestConfigFile(t, reloadTestConfig)
+	configs := []string{reloadTestConfig, reloadTestConfig + "ssh:\n  maxSessions: 5\n", "hosts: []\n"}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/hosts", nil)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, ok := authenticate(r); ok {
+					t.Error("an unauthenticated request was let in during a reload")
+					return
+				}
+				maxSSHSessions()
+				checkJitter(cron.Every(time.Minute))
+				auditPath()
+				agentStaleAfter()
+				haLease()
+				historyPath()
+				sshDefaults("")
+				configuredHosts()
+			}
+		}()
+	}
+	for i := 0; i < 30; i++ {
+		if err := os.WriteFile(path, []byte(configs[i%len(configs)]), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		reloadConfig()
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a log file that is moved aside to <path>.<time> once it
+// reaches maxSize bytes or, when every is set, at the start of each period
+// of that length (every day at midnight UTC for 24h). The newest
+// maxBackups old files are kept. Zero limits are disabled.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	every      time.Duration
+	maxBackups int
+
+	f      *os.File
+	size   int64
+	period time.Time // start of the period the file was written in
+}
+
+func openRotatingFile(path string, maxSize int64, every time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, every: every, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	// A file left by an earlier run belongs to the period it was last
+	// written in, so a restart doesn't skip a rotation.
+	r.period = r.periodOf(time.Now())
+	if r.size > 0 {
+		r.period = r.periodOf(info.ModTime())
+	}
+	return nil
+}
+
+func (r *rotatingFile) periodOf(t time.Time) time.Time {
+	if r.every <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(r.every)
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.size > 0 && (r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize || !r.periodOf(now).Equal(r.period)) {
+		if err := r.rotate(now); err != nil {
+			// Keep writing to the current file rather than losing logs.
+			os.Stderr.WriteString("Error rotating " + r.path + ": " + err.Error() + "\n")
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate(now time.Time) error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, r.path+"."+now.UTC().Format("2006-01-02T15-04-05")); err != nil {
+		r.open()
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.period = r.periodOf(now)
+	r.prune()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond maxBackups. Their names
+// sort by time.
+func (r *rotatingFile) prune() {
+	if r.maxBackups <= 0 {
+		return
+	}
+	old, err := filepath.Glob(r.path + ".*-*-*T*")
+	if err != nil || len(old) <= r.maxBackups {
+		return
+	}
+	sort.Strings(old)
+	for _, path := range old[:len(old)-r.maxBackups] {
+		os.Remove(path)
+	}
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	// logLevel is the level below which log records are dropped, set by
+	// --log-level or log.level.
+	logLevel = new(slog.LevelVar)
+	// logLevelFlagSet is whether --log-level was given, overriding
+	// log.level.
+	logLevelFlagSet bool
+	// logOutput is stderr or the log.file.
+	logOutput io.Writer = os.Stderr
+)
+
+// setupLogging makes slog log at the given level (debug, info, warn or
+// error) to logOutput, as key=value text or, with format "json", one JSON
+// object per line. Output of the standard log package, from libraries, goes
+// through the same handler.
+func setupLogging(level, format string) error {
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(l)
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		// Durations read better as "1m30s" than as nanoseconds in JSON.
+		if a.Value.Kind() == slog.KindDuration {
+			return slog.String(a.Key, a.Value.Duration().String())
+		}
+		return a
+	}}
+	switch strings.ToLower(format) {
+	case "text", "":
+		slog.SetDefault(slog.New(slog.NewTextHandler(logOutput, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, opts)))
+	default:
+		return fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+	return nil
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", level)
+}
+
+// configLogLevel is log.level, unless --log-level was given. An invalid
+// log.level is left for validation to report.
+func configLogLevel() string {
+	if level := conf().GetString("log.level"); !logLevelFlagSet && level != "" {
+		if _, err := parseLogLevel(level); err == nil {
+			return level
+		}
+	}
+	return logLevelName
+}
+
+// configureLogging applies the log section of the config once it is read:
+// log.level and log.format, which --log-level and --log-format override,
+// and, for the daemon, log.file to write to instead of stderr, rotated by
+// log.maxSize megabytes (default 100) and every log.rotate, keeping
+// log.maxBackups (default 7) old files.
+func configureLogging(daemon bool) error {
+	format := logFormat
+	if f := strings.ToLower(conf().GetString("log.format")); format == "" && (f == "text" || f == "json") {
+		format = f
+	}
+	if path := conf().GetString("log.file"); path != "" && daemon {
+		f, err := openRotatingFile(path, conf().GetInt64("log.maxSize")<<20, conf().GetDuration("log.rotate"), conf().GetInt("log.maxBackups"))
+		if err != nil {
+			return fmt.Errorf("log.file: %w", err)
+		}
+		logOutput = f
+	}
+	return setupLogging(configLogLevel(), format)
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -3,51 +3,49 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"os/exec"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/spf13/viper"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func initConfig() {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading config file, %s", err)
-	}
-}
-
-func sendTelegramMessage(message string) {
-	botToken := viper.GetString("telegramBotToken")
-	chatID := viper.GetInt64("telegramChatID")
-
-	bot, err := tgbotapi.NewBotAPI(botToken)
-	if err != nil {
-		log.Panic(err)
+// runSSHCommand runs a check command, usually ssh to a host, once one of the
+// ssh.maxSessions sessions is free. The 10-second timeout starts when it
+// does; the command is also killed when ctx is done, at the check's
+// deadline or when the monitor gives up waiting for checks at shutdown.
+func runSSHCommand(ctx context.Context, command string) (string, error) {
+	if err := sshSessions.acquire(ctx); err != nil {
+		if err == context.DeadlineExceeded {
+			return "", fmt.Errorf("command timed out")
+		}
+		return "", err
 	}
-
-	msg := tgbotapi.NewMessage(chatID, message)
-	bot.Send(msg)
-}
-
-func runSSHCommand(command string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer sshSessions.release()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "sh", "-c", command)
 	var out bytes.Buffer
 	cmd.Stdout = &out
+	// Killing sh leaves its children holding the output pipe; don't wait
+	// for them.
+	cmd.WaitDelay = time.Second
 	err := cmd.Run()
-	if ctx.Err() == context.DeadlineExceeded {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
 		return "", fmt.Errorf("command timed out")
+	case context.Canceled:
+		return "", ctx.Err()
 	}
 	if err != nil {
 		return "", err
@@ -55,6 +53,27 @@ func runSSHCommand(command string) (string, error) {
 	return out.String(), nil
 }
 
+var loadAverage = regexp.MustCompile(`load averages?:\s*([0-9.]+),?\s+([0-9.]+),?\s+([0-9.]+)`)
+
+// parseLoadAndCores extracts the load averages from the uptime line and the
+// CPU count printed after "Cores:", if the health command includes it.
+func parseLoadAndCores(output string) map[string]float64 {
+	values := map[string]float64{}
+	if m := loadAverage.FindStringSubmatch(output); m != nil {
+		for i, name := range []string{"load1", "load5", "load15"} {
+			if v, err := strconv.ParseFloat(m[i+1], 64); err == nil {
+				values[name] = v
+			}
+		}
+	}
+	if _, after, ok := strings.Cut(output, "Cores:\n"); ok {
+		if cores, err := strconv.ParseFloat(strings.TrimSpace(strings.SplitN(after, "\n", 2)[0]), 64); err == nil {
+			values["cores"] = cores
+		}
+	}
+	return values
+}
+
 func parseSSHOutput(output string) (float64, float64, float64, string, error) {
 	lines := strings.Split(output, "\n")
 	if len(lines) < 12 {
@@ -105,44 +124,28 @@ func parseSSHOutput(output string) (float64, float64, float64, string, error) {
 	return cpuUsage, memUsage, diskUsage, uptime, nil
 }
 
-func checkHealth() {
-	commands := viper.GetStringSlice("SSHCommands")
-
+// checkHealth runs the health check on every host at once, for
+// GET /checkhealth, and logs the reports with the fleet's averages.
+func checkHealth(ctx context.Context) {
 	var messages []string
-	var errorMessages []string
-	var highUsage bool
 
 	var totalCPU, totalMem, totalDisk float64
 	var count int
 
-	for i, command := range commands {
-		output, err := runSSHCommand(command)
-		if err != nil {
-			if err.Error() == "command timed out" {
-				sendTelegramMessage(fmt.Sprintf("Error: SSH command to server %d timed out", i+1))
-			} else {
-				errorMessages = append(errorMessages, fmt.Sprintf("Error running SSH command for server %d: %v", i+1, err))
-			}
+	for _, h := range configuredHosts() {
+		if !checkEnabled(h, "health") {
 			continue
 		}
-
-		cpu, mem, disk, uptime, err := parseSSHOutput(output)
-		if err != nil {
-			errorMessages = append(errorMessages, fmt.Sprintf("Error parsing SSH output for server %d: %v", i+1, err))
+		values, message, ok := checkHostHealth(ctx, h)
+		if !ok {
 			continue
 		}
-
-		message := fmt.Sprintf("Server %d - CPU Usage: %.2f%%, Memory Usage: %.2f%%, Disk Usage: %.2f%%, Uptime: %s", i+1, cpu, mem, disk, uptime)
 		messages = append(messages, message)
 
-		totalCPU += cpu
-		totalMem += mem
-		totalDisk += disk
+		totalCPU += values["cpu"]
+		totalMem += values["memory"]
+		totalDisk += values["disk"]
 		count++
-
-		if cpu > 80 || mem > 80 || disk > 80 {
-			highUsage = true
-		}
 	}
 
 	// Calculate average usage
@@ -152,32 +155,248 @@ func checkHealth() {
 
 	finalMessage := "\nHealth Check:\n" + strings.Join(messages, "\n")
 	finalMessage += fmt.Sprintf("\n|=> Average CPU Usage: %.2f%%, Average Memory Usage: %.2f%%, Average Disk Usage: %.2f%%", avgCPU, avgMem, avgDisk)
+	for _, g := range groupStatuses() {
+		finalMessage += "\n|=> " + g.String()
+	}
 
-	if highUsage {
-		sendTelegramMessage("Warning: High resource usage detected!\n" + finalMessage)
+	slog.Info(finalMessage, "check", "health", "hosts", count, "cpu", avgCPU, "memory", avgMem, "disk", avgDisk)
+}
+
+// runHealthCheck is the "health" check type: it runs the health check on a
+// host and logs its report.
+func runHealthCheck(ctx context.Context, h Host) {
+	if !checkEnabled(h, "health") {
+		return
+	}
+	start := time.Now()
+	if values, message, ok := checkHostHealth(ctx, h); ok {
+		slog.Info(message, "host", h.Name, "check", "health", "duration", time.Since(start).Round(time.Millisecond),
+			"cpu", values["cpu"], "memory", values["memory"], "disk", values["disk"])
+	}
+}
+
+// checkHostHealth runs the health command on one host, raises or clears its
+// alerts and returns the sampled values and a one-line report. ok is false
+// when the host couldn't be sampled, or ctx was canceled before it was.
+func checkHostHealth(ctx context.Context, h Host) (values map[string]float64, message string, ok bool) {
+	host := h.Name
+	start := time.Now()
+	output, err := runHostCommand(ctx, h, h.Command)
+	observeCheck(host, "health", start, err)
+	if errors.Is(err, context.Canceled) {
+		return nil, "", false
+	}
+	if err == nil {
+		slog.Debug("SSH output", "host", h.Name, "check", "health", "output", output)
+		clearAlert(host, "ssh")
 	} else {
-		log.Println(finalMessage)
+		if h.Agent {
+			raiseAlert(Alert{Host: host, Address: h.Address, Check: "ssh", Severity: SeverityCritical, Message: fmt.Sprintf("Error from the agent: %v", err)})
+		} else if err.Error() == "command timed out" {
+			raiseAlert(Alert{Host: host, Address: h.Address, Check: "ssh", Severity: SeverityCritical, Message: "SSH command timed out"})
+		} else {
+			raiseAlert(Alert{Host: host, Address: h.Address, Check: "ssh", Severity: SeverityCritical, Message: fmt.Sprintf("Error running SSH command: %v", err)})
+		}
+		return nil, "", false
 	}
 
-	if len(errorMessages) > 0 {
-		errorMessage := "Errors occurred during health check:\n" + strings.Join(errorMessages, "\n")
-		sendTelegramMessage(errorMessage)
+	cpu, mem, disk, uptime, err := parseSSHOutput(output)
+	if err != nil {
+		raiseAlert(Alert{Host: host, Address: h.Address, Check: "parse", Severity: SeverityWarning, Message: fmt.Sprintf("Error parsing SSH output: %v", err)})
+		return nil, "", false
 	}
+	clearAlert(host, "parse")
+
+	now := time.Now()
+	noteUptime(h, uptime, now)
+	message = fmt.Sprintf("%s - CPU Usage: %.2f%%%s, Memory Usage: %.2f%%%s, Disk Usage: %.2f%%%s, Uptime: %s", h.Label(),
+		cpu, results.change(host, "cpu", cpu, now), mem, results.change(host, "memory", mem, now), disk, results.change(host, "disk", disk, now), uptime)
+
+	thresholds := thresholdsFor(h)
+	values = map[string]float64{"cpu": cpu, "memory": mem, "disk": disk}
+	for _, metric := range []string{"cpu", "memory", "disk"} {
+		if checkEnabled(h, metric) {
+			checkThreshold(h, metric, values[metric], thresholds[metric])
+		} else {
+			clearAlert(host, metric)
+		}
+	}
+
+	for name, v := range parseLoadAndCores(output) {
+		values[name] = v
+	}
+	for name, v := range networkRates(host, output, time.Now()) {
+		values[name] = v
+	}
+	recordHostMetrics(h, values)
+	results.sample(host, values)
+	exportSample(h, values, time.Now())
+	evaluateConditions(h, values)
+	checkTrends(h, values)
+	checkAnomalies(h, values)
+	return values, message, true
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	checkHealth()
+	checkHealth(r.Context())
 	fmt.Fprintf(w, "Health check completed. Check logs for details.")
 }
 
-func main() {
-	initConfig()
-	http.HandleFunc("/checkhealth", healthHandler)
+// runDaemon starts the HTTP server and the periodic check loop, and runs
+// until it receives SIGINT or SIGTERM. It then stops starting checks, lets
+// the running ones finish, sends what is queued and saves its state; a
+// second signal exits at once.
+func runDaemon() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	go func() {
-		for {
-			checkHealth()
-			time.Sleep(10 * time.Second)
+		<-ctx.Done()
+		// Restore the default handling, so the next signal kills the process.
+		stop()
+		slog.Info("Stopping, send the signal again to exit at once")
+	}()
+
+	if problems := validateConfig(conf()); len(problems) > 0 {
+		for _, p := range problems {
+			slog.Error("Config error", "problem", p)
 		}
+		return fmt.Errorf("%s has %d problems, run \"checkhealth validate\" after fixing them", conf().ConfigFileUsed(), len(problems))
+	}
+	if err := loadInventoryFile(); err != nil {
+		return fmt.Errorf("reading inventory: %w", err)
+	}
+	startInventoryProviders(ctx)
+	if err := startTelemetry(); err != nil {
+		return fmt.Errorf("opentelemetry: %w", err)
+	}
+	if err := startExporters(); err != nil {
+		return err
+	}
+	if err := openHistory(); err != nil {
+		return fmt.Errorf("opening the history: %w", err)
+	}
+	where := historyPath()
+	if historyBackend() == "postgres" {
+		where = "PostgreSQL" // the URL may have the password
+	}
+	slog.Info("Keeping history", "backend", historyBackend(), "path", where, "retention", historyRetention())
+	if err := loadOpenIncidents(); err != nil {
+		slog.Error("Error loading ongoing incidents", "err", err)
+	}
+	if err := restoreResults(results); err != nil {
+		slog.Error("Error restoring check results", "err", err)
+	}
+	if err := restoreState(); err != nil {
+		slog.Error("Error restoring alerts and silences", "err", err)
+	}
+	releaseLease := startElection(ctx)
+	go runHistoryPruning(ctx)
+	loadOutbox()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/checkhealth", healthHandler)
+	mux.HandleFunc("/alerts", alertsHandler)
+	mux.HandleFunc("/alerts/ack", ackHandler)
+	mux.HandleFunc("/silences", silencesHandler)
+	mux.HandleFunc("/audit", auditHandler)
+	mux.HandleFunc("/groups", groupsHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("GET /api/openapi.json", openAPIHandler)
+	mux.HandleFunc("GET /api/v1/alerts/history", apiAlertHistoryHandler)
+	mux.HandleFunc("GET /api/v1/hosts", apiHostsHandler)
+	mux.HandleFunc("GET /api/v1/incidents", apiIncidentsHandler)
+	mux.HandleFunc("GET /api/v1/incidents/{id}", apiIncidentHandler)
+	mux.HandleFunc("GET /api/v1/sla", apiSLAHandler)
+	mux.HandleFunc("GET /api/v1/hosts/{name}", apiHostHandler)
+	mux.HandleFunc("POST /api/v1/hosts/{name}/check", apiCheckHostHandler)
+	mux.HandleFunc("GET /api/v1/hosts/{name}/history", apiHistoryHandler)
+	mux.HandleFunc("GET /api/v1/hosts/{name}/timeline", apiTimelineHandler)
+	mux.HandleFunc("GET /api/v1/events", eventsHandler)
+	mux.HandleFunc("GET /api/v1/monitor", apiMonitorHandler)
+	mux.HandleFunc("POST /api/v1/webhooks/{source}", webhookHandler)
+	if conf().GetString("agents.secret") != "" {
+		mux.HandleFunc("POST /api/v1/agent/report", agentReportHandler)
+		publicPaths["/api/v1/agent/report"] = true
+	}
+	mux.HandleFunc("GET /dashboard", dashboardHandler)
+	mux.HandleFunc("GET /dashboard/hosts/{name}", dashboardHostHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	if conf().GetBool("http.debug") {
+		registerDebugHandlers(mux)
+	}
+	if conf().GetBool("statusPage.enabled") {
+		mux.HandleFunc("GET /status", statusPageHandler)
+		mux.HandleFunc("GET /status.json", statusJSONHandler)
+		publicPaths["/status"], publicPaths["/status.json"] = true, true
+	}
+	configState.set(nil)
+	if conf().GetBool("kubernetes.enabled") {
+		if err := watchKubernetesConfig(); err != nil {
+			return err
+		}
+	}
+	loadSilences()
+	go runSilenceExpiry()
+	go runStateSync(ctx)
+	loadQuietHours()
+	if conf().GetBool("digest.enabled") {
+		go runDigest(ctx)
+	}
+	if conf().GetInt("flapping.changes") > 0 {
+		go runFlapping()
+	}
+	if conf().IsSet("summary.schedule") {
+		go runSummary(ctx)
+	}
+	go runSummaryReports(ctx)
+	if conf().GetBool("sla.report") {
+		go runSLAReport(ctx)
+	}
+	go runEscalation(ctx)
+	go runTelegramUpdates()
+	go runSelfMonitor(ctx)
+	go runStalenessCheck(ctx)
+	// Check cycles get their own context, so the ones running at a signal
+	// can finish; it is canceled once shutdownTimeout has passed.
+	cycles, abortChecks := context.WithCancel(context.Background())
+	defer abortChecks()
+	checks := startChecks(ctx, cycles)
+	if err := serveHTTP(ctx, requireAuth(mux)); err != nil {
+		return err
+	}
+
+	// Let running check cycles finish, so their alerts aren't lost halfway.
+	done := make(chan struct{})
+	go func() {
+		checks.Wait()
+		close(done)
 	}()
-	log.Fatal(http.ListenAndServe(":8002", nil))
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout()):
+		slog.Warn("Check cycles still running, canceling them", "timeout", shutdownTimeout())
+		abortChecks()
+	}
+
+	// Deliver what the last checks raised before the state is saved.
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+	queue.close(flushCtx)
+	flushExporters()
+	if err := stopTelemetry(flushCtx); err != nil {
+		slog.Error("Error flushing telemetry", "err", err)
+	}
+	if err := saveState(); err != nil {
+		slog.Error("Error saving alerts", "err", err)
+	}
+	releaseLease()
+	closeHistory()
+	slog.Info("Stopped")
+	return nil
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
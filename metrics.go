@@ -0,0 +1,166 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Prometheus metrics served on /metrics. Host metrics carry the host and
+// group labels so dashboards can aggregate them the same way as /groups.
+var (
+	hostUsage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_host_usage_percent",
+		Help: "Latest CPU, memory or disk usage of a host in percent.",
+	}, []string{"host", "group", "metric"})
+
+	hostLoad = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_host_load",
+		Help: "Latest load average of a host over 1, 5 or 15 minutes.",
+	}, []string{"host", "group", "period"})
+
+	hostCores = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_host_cores",
+		Help: "Number of CPU cores of a host.",
+	}, []string{"host", "group"})
+
+	hostNetwork = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_host_network_bytes_per_second",
+		Help: "Bytes a host received (rx) or transmitted (tx) per second since its previous health check.",
+	}, []string{"host", "group", "direction"})
+
+	hostLastSeen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_host_last_seen_timestamp_seconds",
+		Help: "When a host last answered its health check.",
+	}, []string{"host", "group"})
+
+	checkDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "checkhealth_check_duration_seconds",
+		Help:    "How long running a check on a host took.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"host", "check"})
+
+	checkUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_check_up",
+		Help: "Whether the latest sample of a check passed (1) or failed (0).",
+	}, []string{"host", "check"})
+
+	sshSessionsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_ssh_sessions",
+		Help: "Check commands running (active) and waiting for ssh.maxSessions (waiting).",
+	}, []string{"state"})
+
+	checkPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "checkhealth_check_panics_total",
+		Help: "Check cycles that panicked, by check.",
+	}, []string{"check"})
+
+	cycleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "checkhealth_cycle_duration_seconds",
+		Help:    "How long a cycle of a check loop took, by check.",
+		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"check"})
+
+	cycleOverruns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "checkhealth_cycle_overruns_total",
+		Help: "Check cycles that took longer than the interval of their loop, by check.",
+	}, []string{"check"})
+
+	loopsBehind = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_loops_behind",
+		Help: "Check loops that are overdue or whose last cycle overran their interval.",
+	}, []string{"state"})
+
+	notificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "checkhealth_notification_failures_total",
+		Help: "Notifications that failed to send, by channel.",
+	}, []string{"channel"})
+
+	agentLastReport = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "checkhealth_agent_last_report_timestamp_seconds",
+		Help: "When the agent of a host last reported, as a Unix time.",
+	}, []string{"host"})
+
+	isLeaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "checkhealth_leader",
+		Help: "Whether this monitor is the leader that runs the checks (1) or stands by (0).",
+	})
+
+	alertsRaised = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "checkhealth_alerts_raised_total",
+		Help: "Alerts raised, by check and severity.",
+	}, []string{"check", "severity"})
+)
+
+func init() {
+	prometheus.MustRegister(alertsCollector{})
+}
+
+// alertsCollector reports the active alerts at scrape time.
+type alertsCollector struct{}
+
+var alertsActiveDesc = prometheus.NewDesc("checkhealth_alerts_active",
+	"Currently active alerts, by severity and whether they are acknowledged or silenced.",
+	[]string{"severity", "suppressed"}, nil)
+
+func (alertsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- alertsActiveDesc
+}
+
+func (alertsCollector) Collect(ch chan<- prometheus.Metric) {
+	type key struct{ severity, suppressed string }
+	counts := map[key]float64{}
+	for _, s := range []Severity{SeverityWarning, SeverityCritical} {
+		for _, suppressed := range []string{"false", "true"} {
+			counts[key{strings.ToLower(s.String()), suppressed}] = 0
+		}
+	}
+	now := time.Now()
+	for _, aa := range alerts.list() {
+		suppressed := "false"
+		if aa.suppressed(now) {
+			suppressed = "true"
+		}
+		counts[key{strings.ToLower(aa.Severity.String()), suppressed}]++
+	}
+	for k, n := range counts {
+		ch <- prometheus.MustNewConstMetric(alertsActiveDesc, prometheus.GaugeValue, n, k.severity, k.suppressed)
+	}
+}
+
+// recordHostMetrics exports the values of a host's health check.
+func recordHostMetrics(h Host, values map[string]float64) {
+	for _, metric := range []string{"cpu", "memory", "disk"} {
+		if v, ok := values[metric]; ok && checkEnabled(h, metric) {
+			hostUsage.WithLabelValues(h.Name, h.Group, metric).Set(v)
+		} else {
+			hostUsage.DeleteLabelValues(h.Name, h.Group, metric)
+		}
+	}
+	for _, period := range []string{"1", "5", "15"} {
+		if v, ok := values["load"+period]; ok {
+			hostLoad.WithLabelValues(h.Name, h.Group, period).Set(v)
+		}
+	}
+	if v, ok := values["cores"]; ok {
+		hostCores.WithLabelValues(h.Name, h.Group).Set(v)
+	}
+	for _, direction := range []string{"rx", "tx"} {
+		if v, ok := values["net_"+direction]; ok {
+			hostNetwork.WithLabelValues(h.Name, h.Group, direction).Set(v)
+		}
+	}
+	hostLastSeen.WithLabelValues(h.Name, h.Group).SetToCurrentTime()
+}
+
+// observeCheck records how long a check took on a host since start, for
+// Prometheus and OpenTelemetry.
+func observeCheck(host, check string, start time.Time, err error) {
+	slog.Debug("Check ran", "host", host, "check", check, "duration", time.Since(start).Round(time.Millisecond), "err", err)
+	checkDuration.WithLabelValues(host, check).Observe(time.Since(start).Seconds())
+	traceSince("check "+check, start, err, otelCheckDuration, attribute.String("host", host), attribute.String("check", check))
+}
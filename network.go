@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// networkCounters are the received and transmitted bytes of all interfaces
+// but loopback, as printed after "Network:" by the health command (the
+// contents of /proc/net/dev).
+type networkCounters struct {
+	rx, tx float64
+	time   time.Time
+}
+
+func parseNetworkCounters(output string) (networkCounters, bool) {
+	_, after, ok := strings.Cut(output, "Network:\n")
+	if !ok {
+		return networkCounters{}, false
+	}
+	var c networkCounters
+	found := false
+	for _, line := range strings.Split(after, "\n") {
+		name, stats, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(stats)
+		if name == "lo" || len(fields) < 9 {
+			continue
+		}
+		rx, err1 := strconv.ParseFloat(fields[0], 64)
+		tx, err2 := strconv.ParseFloat(fields[8], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		c.rx += rx
+		c.tx += tx
+		found = true
+	}
+	return c, found
+}
+
+// lastCounters keeps each host's previous network counters to compute
+// rates from.
+var lastCounters = struct {
+	sync.Mutex
+	hosts map[string]networkCounters
+}{hosts: map[string]networkCounters{}}
+
+// networkRates returns a host's received and transmitted bytes per second
+// since its previous health check, as net_rx and net_tx. There are none on
+// the first check, or after the counters were reset by a reboot.
+func networkRates(host, output string, now time.Time) map[string]float64 {
+	c, ok := parseNetworkCounters(output)
+	if !ok {
+		return nil
+	}
+	c.time = now
+	key := strings.ToLower(host)
+	lastCounters.Lock()
+	prev, seen := lastCounters.hosts[key]
+	lastCounters.hosts[key] = c
+	lastCounters.Unlock()
+
+	elapsed := c.time.Sub(prev.time).Seconds()
+	if !seen || elapsed <= 0 || c.rx < prev.rx || c.tx < prev.tx {
+		return nil
+	}
+	return map[string]float64{
+		"net_rx": (c.rx - prev.rx) / elapsed,
+		"net_tx": (c.tx - prev.tx) / elapsed,
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The tracer and instruments use the global providers, which do nothing
+// until startTelemetry installs the OTLP ones.
+var (
+	tracer = otel.Tracer("checkhealth")
+	meter  = otel.Meter("checkhealth")
+
+	otelCheckDuration, _ = meter.Float64Histogram("checkhealth.check.duration",
+		metric.WithUnit("s"), metric.WithDescription("Duration of a check's command on a host."))
+	otelCycleDuration, _ = meter.Float64Histogram("checkhealth.cycle.duration",
+		metric.WithUnit("s"), metric.WithDescription("Duration of a check loop's cycle on its host."))
+	otelNotifyDuration, _ = meter.Float64Histogram("checkhealth.notification.duration",
+		metric.WithUnit("s"), metric.WithDescription("Duration of sending a notification."))
+)
+
+// stopTelemetry flushes and shuts down the OTLP exporters.
+var stopTelemetry = func(ctx context.Context) error { return nil }
+
+// startTelemetry exports traces and metrics over OTLP/HTTP to
+// opentelemetry.endpoint, if set. The standard OTEL_EXPORTER_OTLP_*
+// variables are honored as well.
+func startTelemetry() error {
+	cfg := conf().Sub("opentelemetry")
+	if cfg == nil {
+		return nil
+	}
+	ctx := context.Background()
+
+	var traceOpts []otlptracehttp.Option
+	var metricOpts []otlpmetrichttp.Option
+	if endpoint := cfg.GetString("endpoint"); endpoint != "" {
+		traceOpts = append(traceOpts, otlptracehttp.WithEndpoint(endpoint))
+		metricOpts = append(metricOpts, otlpmetrichttp.WithEndpoint(endpoint))
+	}
+	if cfg.GetBool("insecure") {
+		traceOpts = append(traceOpts, otlptracehttp.WithInsecure())
+		metricOpts = append(metricOpts, otlpmetrichttp.WithInsecure())
+	}
+	if headers := cfg.GetStringMapString("headers"); len(headers) > 0 {
+		traceOpts = append(traceOpts, otlptracehttp.WithHeaders(headers))
+		metricOpts = append(metricOpts, otlpmetrichttp.WithHeaders(headers))
+	}
+
+	name := cfg.GetString("serviceName")
+	if name == "" {
+		name = "checkhealth"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(name)))
+	if err != nil {
+		return err
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, traceOpts...)
+	if err != nil {
+		return err
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, metricOpts...)
+	if err != nil {
+		return err
+	}
+	interval := cfg.GetDuration("interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	stopTelemetry = func(ctx context.Context) error {
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}
+	slog.Info("Exporting traces and metrics over OTLP", "service", name)
+	return nil
+}
+
+// traceSince records a span that started at start and ended now, and its
+// duration in histogram.
+func traceSince(name string, start time.Time, err error, histogram metric.Float64Histogram, attrs ...attribute.KeyValue) {
+	end := time.Now()
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	histogram.Record(context.Background(), end.Sub(start).Seconds(),
+		metric.WithAttributes(append(attrs, attribute.String("outcome", outcome))...))
+
+	_, span := tracer.Start(context.Background(), name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// tracedChannel records every notification sent through a channel.
+type tracedChannel struct {
+	Notifier
+	name string
+}
+
+func (c tracedChannel) Notify(ctx context.Context, a Alert) error {
+	start := time.Now()
+	err := c.Notifier.Notify(ctx, a)
+	traceSince("notify "+c.name, start, err, otelNotifyDuration,
+		attribute.String("channel", c.name), attribute.String("host", a.Host), attribute.String("check", a.Check))
+	return err
+}
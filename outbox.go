@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// outboundMessage is a Telegram message waiting to be delivered. AlertKey
+// names the alert it carries, if any, and Resolved whether it is the
+// alert's resolve notice; Buttons attaches the Ack/Silence keyboard for it.
+type outboundMessage struct {
+	ID          int64     `json:"id"`
+	ChatID      int64     `json:"chatID"`
+	ThreadID    int       `json:"threadID,omitempty"`
+	Text        string    `json:"text"`
+	AlertKey    string    `json:"alertKey,omitempty"`
+	Resolved    bool      `json:"resolved,omitempty"`
+	Buttons     bool      `json:"buttons,omitempty"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// params builds a sendMessage request by hand because the bot library has no
+// support for message_thread_id (forum topics).
+func (m outboundMessage) params() tgbotapi.Params {
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", m.ChatID)
+	params.AddNonZero("message_thread_id", m.ThreadID)
+	params.AddNonEmpty("text", m.Text)
+	if m.Buttons {
+		params.AddInterface("reply_markup", tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Ack", "ack:"+m.AlertKey),
+			tgbotapi.NewInlineKeyboardButtonData("Silence 1h", "silence:"+m.AlertKey),
+		)))
+	}
+	return params
+}
+
+// outbox is a file-backed queue of outbound Telegram messages. Failed sends
+// are retried with exponential backoff; messages that exhaust their attempts
+// are appended to the dead-letter log instead of being dropped silently.
+type outbox struct {
+	mu       sync.Mutex
+	messages []outboundMessage
+	lastID   int64
+	wake     chan struct{}
+	stop     chan struct{} // closed by close
+	stopped  chan struct{} // closed when run returns
+
+	send                 func(m outboundMessage) error // deliverTelegram
+	path, deadLetterPath string
+	maxAttempts          int
+	minBackoff           time.Duration
+	maxBackoff           time.Duration
+}
+
+var queue *outbox
+
+func queueFilePath() string {
+	return conf().GetString("delivery.queueFile")
+}
+
+func deadLetterFilePath() string {
+	return conf().GetString("delivery.deadLetterFile")
+}
+
+func loadOutbox() {
+	queue = &outbox{
+		wake:           make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+		send:           deliverTelegram,
+		path:           queueFilePath(),
+		deadLetterPath: deadLetterFilePath(),
+		maxAttempts:    conf().GetInt("delivery.maxAttempts"),
+		minBackoff:     conf().GetDuration("delivery.minBackoff"),
+		maxBackoff:     conf().GetDuration("delivery.maxBackoff"),
+	}
+
+	data, err := os.ReadFile(queue.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Error reading outbox", "path", queue.path, "err", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &queue.messages); err != nil {
+			slog.Error("Error parsing outbox", "path", queue.path, "err", err)
+		}
+	}
+	for _, m := range queue.messages {
+		if m.ID > queue.lastID {
+			queue.lastID = m.ID
+		}
+	}
+	if n := len(queue.messages); n > 0 {
+		slog.Info("Resuming delivery of queued messages", "messages", n)
+	}
+	go queue.run()
+}
+
+func (o *outbox) enqueue(m outboundMessage) {
+	if !isLeader() {
+		slog.Debug("Standing by, not sending", "channel", "telegram", "alert", m.AlertKey)
+		return
+	}
+	m.Created = time.Now()
+	m.NextAttempt = m.Created
+
+	o.mu.Lock()
+	if i := o.queued(m); i >= 0 {
+		// An active alert is sent again every cycle. While a message of it
+		// still waits, that one gets the new text, keeping its place and its
+		// attempts, so an outage doesn't pile up a copy per cycle.
+		o.messages[i].Text, o.messages[i].Buttons = m.Text, m.Buttons
+	} else {
+		o.lastID++
+		m.ID = o.lastID
+		o.messages = append(o.messages, m)
+	}
+	o.save()
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save must be called with o.mu held.
+func (o *outbox) save() {
+	data, err := json.Marshal(o.messages)
+	if err != nil {
+		slog.Error("Error encoding outbox", "err", err)
+		return
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		slog.Error("Error writing outbox", "path", o.path, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		slog.Error("Error writing outbox", "path", o.path, "err", err)
+	}
+}
+
+func (o *outbox) run() {
+	defer close(o.stopped)
+	for {
+		o.deliverDue(context.Background())
+
+		wait := time.Minute
+		o.mu.Lock()
+		for _, m := range o.messages {
+			if d := time.Until(m.NextAttempt); d < wait {
+				wait = d
+			}
+		}
+		o.mu.Unlock()
+
+		select {
+		case <-o.wake:
+		case <-time.After(wait):
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// close stops the delivery loop once the message it is sending is done and
+// makes a last attempt at the messages that are due, until ctx is done.
+// Messages left over stay in the queue file for the next start.
+func (o *outbox) close(ctx context.Context) {
+	close(o.stop)
+	select {
+	case <-o.stopped:
+	case <-ctx.Done():
+		return
+	}
+	o.deliverDue(ctx)
+	o.mu.Lock()
+	n := len(o.messages)
+	o.mu.Unlock()
+	if n > 0 {
+		slog.Warn("Messages left in the outbox for the next start", "messages", n, "path", o.path)
+	}
+}
+
+// deliverDue sends every message whose retry time has come, in queue order,
+// until ctx is done.
+func (o *outbox) deliverDue(ctx context.Context) {
+	o.mu.Lock()
+	pending := append([]outboundMessage(nil), o.messages...)
+	o.mu.Unlock()
+
+	now := time.Now()
+	for _, m := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		if m.NextAttempt.After(now) {
+			continue
+		}
+		start := time.Now()
+		sent := m.Text
+		// A panic while sending counts as a failed attempt rather than
+		// stopping the delivery of everything else.
+		var err error
+		if p := recoverPanic("a Telegram delivery", func() { err = o.send(m) }); p != nil {
+			err = p
+		}
+		if err != nil {
+			countNotifyFailure("telegram")
+		}
+		traceSince("notify telegram", start, err, otelNotifyDuration,
+			attribute.String("channel", "telegram"), attribute.String("alert", m.AlertKey), attribute.Int("attempt", m.Attempts+1))
+
+		if m.AlertKey != "" {
+			recordAudit(auditEvent{Event: "delivery", Alert: m.AlertKey, Channel: fmt.Sprintf("telegram:%d", m.ChatID), Result: errorResult(err)})
+		}
+
+		o.mu.Lock()
+		// The alert may have been sent again meanwhile, updating the text.
+		if i := o.index(m.ID); i >= 0 {
+			m.Text, m.Buttons = o.messages[i].Text, o.messages[i].Buttons
+		}
+		switch {
+		case err == nil && m.Text != sent:
+			// The new text still has to go out; the message stays due.
+		case err == nil:
+			o.remove(m)
+		case m.Attempts+1 >= o.maxAttempts || telegramRejected(err):
+			m.Attempts++
+			m.LastError = redactToken(err)
+			o.deadLetter(m)
+			o.remove(m)
+		default:
+			m.Attempts++
+			m.LastError = redactToken(err)
+			m.NextAttempt = now.Add(max(o.backoff(m.Attempts), telegramRetryAfter(err)))
+			o.replace(m)
+			slog.Warn("Telegram delivery failed, retrying", "alert", m.AlertKey, "attempt", m.Attempts, "retry", m.NextAttempt, "err", m.LastError)
+		}
+		o.save()
+		o.mu.Unlock()
+	}
+}
+
+func (o *outbox) backoff(attempts int) time.Duration {
+	d := o.minBackoff
+	for i := 1; i < attempts && d < o.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.maxBackoff {
+		d = o.maxBackoff
+	}
+	return d
+}
+
+// queued, index, remove and replace must be called with o.mu held.
+
+// queued returns the index of the last waiting message of the same alert to
+// the same chat and thread as m if m can replace it, or -1. A resolve notice
+// and a firing alert never replace one another, so both get delivered, in
+// the order they were raised.
+func (o *outbox) queued(m outboundMessage) int {
+	if m.AlertKey == "" {
+		return -1
+	}
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		q := o.messages[i]
+		if q.AlertKey == m.AlertKey && q.ChatID == m.ChatID && q.ThreadID == m.ThreadID {
+			if q.Resolved != m.Resolved {
+				return -1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *outbox) index(id int64) int {
+	for i := range o.messages {
+		if o.messages[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *outbox) remove(m outboundMessage) {
+	for i := range o.messages {
+		if o.messages[i].ID == m.ID {
+			o.messages = append(o.messages[:i], o.messages[i+1:]...)
+			return
+		}
+	}
+}
+
+func (o *outbox) replace(m outboundMessage) {
+	for i := range o.messages {
+		if o.messages[i].ID == m.ID {
+			o.messages[i] = m
+			return
+		}
+	}
+}
+
+func (o *outbox) deadLetter(m outboundMessage) {
+	slog.Error("Giving up on Telegram message", "alert", m.AlertKey, "attempts", m.Attempts, "deadLetters", o.deadLetterPath, "err", m.LastError)
+	recordAudit(auditEvent{Event: "dead-letter", Alert: m.AlertKey, Channel: fmt.Sprintf("telegram:%d", m.ChatID), Result: m.LastError, Message: m.Text})
+	f, err := os.OpenFile(o.deadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.Error("Error opening dead-letter log", "err", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(m); err != nil {
+		slog.Error("Error writing dead-letter log", "err", err)
+	}
+}
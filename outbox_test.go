@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func testOutbox(t *testing.T, send func(m outboundMessage) error) *outbox {
+	t.Helper()
+	useConfig(t, "")
+	dir := t.TempDir()
+	return &outbox{
+		wake:           make(chan struct{}, 1),
+		send:           send,
+		path:           filepath.Join(dir, "outbox.json"),
+		deadLetterPath: filepath.Join(dir, "deadletter.log"),
+		maxAttempts:    3,
+		minBackoff:     5 * time.Second,
+		maxBackoff:     time.Minute,
+	}
+}
+
+func deadLetters(t *testing.T, o *outbox) int {
+	t.Helper()
+	f, err := os.Open(o.deadLetterPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for s := bufio.NewScanner(f); s.Scan(); {
+		n++
+	}
+	return n
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	o := &outbox{minBackoff: 5 * time.Second, maxBackoff: time.Minute}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{4, 40 * time.Second},
+		{5, time.Minute},
+		{20, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := o.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxDeliverDue(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		attempts   int // before this one
+		wantQueued bool
+		wantDead   bool
+		wantRetry  time.Duration // at least, when queued
+	}{
+		{name: "sent", err: nil},
+		{name: "failed", err: errors.New("timeout"), wantQueued: true, wantRetry: 5 * time.Second},
+		{name: "failed again", err: errors.New("timeout"), attempts: 1, wantQueued: true, wantRetry: 10 * time.Second},
+		{name: "out of attempts", err: errors.New("timeout"), attempts: 2, wantDead: true},
+		{name: "rejected", err: &tgbotapi.Error{Code: http.StatusBadRequest, Message: "chat not found"}, wantDead: true},
+		{name: "blocked", err: &tgbotapi.Error{Code: http.StatusForbidden, Message: "bot was kicked"}, wantDead: true},
+		{name: "rate limited", err: &tgbotapi.Error{Code: http.StatusTooManyRequests, Message: "Too Many Requests: retry after 120", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 120}},
+			wantQueued: true, wantRetry: 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := 0
+			o := testOutbox(t, func(m outboundMessage) error {
+				sent++
+				return tt.err
+			})
+			o.enqueue(outboundMessage{ChatID: 1, Text: "down", AlertKey: "a/ssh"})
+			o.messages[0].Attempts = tt.attempts
+			start := time.Now()
+			o.deliverDue(context.Background())
+
+			if sent != 1 {
+				t.Fatalf("sent %d times, want 1", sent)
+			}
+			if queued := len(o.messages) == 1; queued != tt.wantQueued {
+				t.Fatalf("queued = %v, want %v", queued, tt.wantQueued)
+			}
+			if tt.wantQueued {
+				m := o.messages[0]
+				if m.Attempts != tt.attempts+1 || m.LastError == "" {
+					t.Errorf("attempts = %d, lastError = %q, want %d and the error", m.Attempts, m.LastError, tt.attempts+1)
+				}
+				if m.NextAttempt.Before(start.Add(tt.wantRetry)) {
+					t.Errorf("next attempt in %s, want at least %s", m.NextAttempt.Sub(start), tt.wantRetry)
+				}
+			}
+			if dead := deadLetters(t, o) == 1; dead != tt.wantDead {
+				t.Errorf("dead-lettered = %v, want %v", dead, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestOutboxCoalesces(t *testing.T) {
+	tests := []struct {
+		name string
+		next outboundMessage
+		want int
+	}{
+		{"same alert", outboundMessage{ChatID: 1, Text: "cpu 95%", AlertKey: "a/cpu"}, 1},
+		{"resolved", outboundMessage{ChatID: 1, Text: "RESOLVED", AlertKey: "a/cpu", Resolved: true}, 2},
+		{"other alert", outboundMessage{ChatID: 1, Text: "disk 91%", AlertKey: "a/disk"}, 2},
+		{"other chat", outboundMessage{ChatID: 2, Text: "cpu 95%", AlertKey: "a/cpu"}, 2},
+		{"other topic", outboundMessage{ChatID: 1, ThreadID: 7, Text: "cpu 95%", AlertKey: "a/cpu"}, 2},
+		{"no alert", outboundMessage{ChatID: 1, Text: "summary"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOutbox(t, nil)
+			o.enqueue(outboundMessage{ChatID: 1, Text: "cpu 90%", AlertKey: "a/cpu", Buttons: true})
+			o.messages[0].Attempts = 2
+			o.enqueue(tt.next)
+			if len(o.messages) != tt.want {
+				t.Fatalf("%d messages queued, want %d", len(o.messages), tt.want)
+			}
+			if tt.want == 1 {
+				m := o.messages[0]
+				if m.Text != tt.next.Text || m.Buttons != tt.next.Buttons || m.Attempts != 2 {
+					t.Errorf("queued %+v, want the new text and buttons and the old attempts", m)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxKeepsFiringAndResolvedApart(t *testing.T) {
+	o := testOutbox(t, nil)
+	o.enqueue(outboundMessage{ChatID: 1, Text: "cpu 90%", AlertKey: "a/cpu"})
+	o.enqueue(outboundMessage{ChatID: 1, Text: "RESOLVED cpu", AlertKey: "a/cpu", Resolved: true})
+	o.enqueue(outboundMessage{ChatID: 1, Text: "cpu 95%", AlertKey: "a/cpu"})
+	o.enqueue(outboundMessage{ChatID: 1, Text: "cpu 97%", AlertKey: "a/cpu"})
+
+	var got []string
+	for _, m := range o.messages {
+		got = append(got, m.Text)
+	}
+	want := []string{"cpu 90%", "RESOLVED cpu", "cpu 97%"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %q, want %q", got, want)
+	}
+}
+
+func TestOutboxKeepsTextUpdatedWhileSending(t *testing.T) {
+	var o *outbox
+	o = testOutbox(t, func(m outboundMessage) error {
+		// The alert is raised again while Telegram doesn't answer.
+		o.enqueue(outboundMessage{ChatID: 1, Text: "cpu 99%", AlertKey: "a/cpu"})
+		return errors.New("timeout")
+	})
+	o.enqueue(outboundMessage{ChatID: 1, Text: "cpu 90%", AlertKey: "a/cpu"})
+	o.deliverDue(context.Background())
+	if len(o.messages) != 1 || o.messages[0].Text != "cpu 99%" || o.messages[0].Attempts != 1 {
+		t.Fatalf("queued %+v, want one message with the new text after one attempt", o.messages)
+	}
+}
+
+func TestOutboxRedactsToken(t *testing.T) {
+	requestErr := &url.Error{Op: "Post", URL: "https://api.telegram.org/bot" + testBotToken + "/sendMessage", Err: context.DeadlineExceeded}
+	o := testOutbox(t, func(m outboundMessage) error { return requestErr })
+	useConfig(t, "telegramBotToken: \""+testBotToken+"\"\n")
+	o.maxAttempts = 2
+	o.enqueue(outboundMessage{ChatID: 1, Text: "down", AlertKey: "a/ssh"})
+	o.deliverDue(context.Background())
+	if len(o.messages) != 1 || strings.Contains(o.messages[0].LastError, testBotToken) {
+		t.Fatalf("queued %+v, want the error without the token", o.messages)
+	}
+	o.messages[0].NextAttempt = time.Now()
+	o.deliverDue(context.Background())
+	if deadLetters(t, o) != 1 {
+		t.Fatal("not dead-lettered")
+	}
+	for _, path := range []string{o.path, o.deadLetterPath, auditPath()} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), testBotToken) {
+			t.Errorf("%s has the token: %s", filepath.Base(path), data)
+		}
+	}
+}
+
+func TestOutboxSendsTextUpdatedWhileSending(t *testing.T) {
+	var o *outbox
+	var sent []string
+	o = testOutbox(t, func(m outboundMessage) error {
+		sent = append(sent, m.Text)
+		if len(sent) == 1 {
+			// The alert is raised again while the first text goes out.
+			o.enqueue(outboundMessage{ChatID: 1, Text: "cpu 99%", AlertKey: "a/cpu"})
+		}
+		return nil
+	})
+	o.enqueue(outboundMessage{ChatID: 1, Text: "cpu 90%", AlertKey: "a/cpu"})
+	o.deliverDue(context.Background())
+	if len(o.messages) != 1 || o.messages[0].Text != "cpu 99%" {
+		t.Fatalf("queued %+v, want the new text still due", o.messages)
+	}
+	o.deliverDue(context.Background())
+	if len(o.messages) != 0 {
+		t.Errorf("queued %+v after sending the new text", o.messages)
+	}
+	if want := []string{"cpu 90%", "cpu 99%"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+}
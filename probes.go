@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// loopState is what the probes know about one check loop.
+type loopState struct {
+	Running             bool       `json:"running"`
+	LastStarted         *time.Time `json:"lastStarted,omitempty"`
+	LastCompleted       *time.Time `json:"lastCompleted,omitempty"`
+	LastDurationSeconds float64    `json:"lastDurationSeconds,omitempty"`
+	NextDue             time.Time  `json:"nextDue"`
+	Overdue             bool       `json:"overdue"`
+	// Overruns counts the latest cycles in a row that took longer than
+	// the interval of the loop.
+	Overruns int `json:"overruns,omitempty"`
+}
+
+// checkLoops tracks the cycles of every check loop started by startChecks,
+// one per check of every host.
+type checkLoops struct {
+	mu    sync.Mutex
+	loops map[string]*loopState
+	sched map[string]cron.Schedule
+}
+
+var loops = &checkLoops{loops: map[string]*loopState{}, sched: map[string]cron.Schedule{}}
+
+// track wraps the run function of a check loop so its cycles are recorded.
+func (l *checkLoops) track(name string, sched cron.Schedule, run func()) func() {
+	l.mu.Lock()
+	l.loops[name] = &loopState{NextDue: sched.Next(time.Now())}
+	l.sched[name] = sched
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		started := time.Now()
+		l.loops[name].Running = true
+		l.loops[name].LastStarted = &started
+		l.mu.Unlock()
+
+		run()
+		took := time.Since(started)
+		traceSince("cycle "+name, started, nil, otelCycleDuration, attribute.String("loop", name))
+		check := loopCheck(name)
+		cycleDuration.WithLabelValues(check).Observe(took.Seconds())
+		pushMetrics()
+		pingHeartbeat(name)
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		// The loop may have been removed while the cycle ran.
+		if s, ok := l.loops[name]; ok {
+			now := time.Now()
+			s.Running = false
+			s.LastCompleted = &now
+			s.LastDurationSeconds = took.Seconds()
+			if took > scheduleInterval(l.sched[name], started) {
+				s.Overruns++
+				cycleOverruns.WithLabelValues(check).Inc()
+			} else {
+				s.Overruns = 0
+			}
+			s.NextDue = l.sched[name].Next(now)
+		}
+	}
+}
+
+// interval is the time between the cycles of a loop following now.
+func (l *checkLoops) interval(name string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sched, ok := l.sched[name]
+	if !ok {
+		return 0, false
+	}
+	return scheduleInterval(sched, now), true
+}
+
+// remove forgets a loop that was stopped.
+func (l *checkLoops) remove(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.loops, name)
+	delete(l.sched, name)
+}
+
+// state returns every loop's state. A loop is overdue when it hasn't
+// finished a cycle for a grace period after it was due: as long as the
+// interval itself, at least loopGrace.
+func (l *checkLoops) state(now time.Time) map[string]loopState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	states := map[string]loopState{}
+	for name, s := range l.loops {
+		st := *s
+		grace := l.sched[name].Next(st.NextDue).Sub(st.NextDue)
+		if grace < loopGrace {
+			grace = loopGrace
+		}
+		st.Overdue = now.After(st.NextDue.Add(grace))
+		states[name] = st
+	}
+	return states
+}
+
+const loopGrace = 5 * time.Minute
+
+// loopCheck is the check of a loop named "<check>/<host>".
+func loopCheck(name string) string {
+	check, _, _ := strings.Cut(name, "/")
+	return check
+}
+
+// scheduleInterval is the time between the runs of sched following t.
+func scheduleInterval(sched cron.Schedule, t time.Time) time.Duration {
+	next := sched.Next(t)
+	return sched.Next(next).Sub(next)
+}
+
+// telegramStatus caches whether the Telegram API accepts the bot token. It
+// is refreshed in the background at most once a minute, so readiness probes
+// answer quickly and don't call Telegram every time.
+var telegramStatus = struct {
+	sync.Mutex
+	checked    time.Time
+	refreshing bool
+	err        error
+}{}
+
+func checkTelegram() error {
+	// NewBotAPI calls getMe to verify the token.
+	client := &http.Client{Timeout: 10 * time.Second}
+	_, err := tgbotapi.NewBotAPIWithClient(conf().GetString("telegramBotToken"), tgbotapi.APIEndpoint, client)
+	if err != nil {
+		slog.Warn("Telegram is unreachable", "err", redactToken(err))
+	}
+	return err
+}
+
+func telegramReachable() error {
+	telegramStatus.Lock()
+	defer telegramStatus.Unlock()
+	switch {
+	case telegramStatus.checked.IsZero():
+		telegramStatus.err = checkTelegram()
+		telegramStatus.checked = time.Now()
+	case time.Since(telegramStatus.checked) > time.Minute && !telegramStatus.refreshing:
+		telegramStatus.refreshing = true
+		go func() {
+			err := checkTelegram()
+			telegramStatus.Lock()
+			defer telegramStatus.Unlock()
+			telegramStatus.err, telegramStatus.checked, telegramStatus.refreshing = err, time.Now(), false
+		}()
+	}
+	return telegramStatus.err
+}
+
+func writeProbe(w http.ResponseWriter, ok bool, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(body)
+}
+
+// healthzHandler is the liveness probe: it fails when a check loop has
+// stopped completing cycles, e.g. because a check hangs.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	states := loops.state(time.Now())
+	var overdue []string
+	for name, s := range states {
+		if s.Overdue {
+			overdue = append(overdue, name)
+		}
+	}
+	sort.Strings(overdue)
+	status := "ok"
+	if len(overdue) > 0 {
+		status = "check loops overdue"
+	}
+	writeProbe(w, len(overdue) == 0, map[string]interface{}{
+		"status":  status,
+		"overdue": overdue,
+		"loops":   states,
+	})
+}
+
+// readyzHandler is the readiness probe: it fails while the last config
+// reload was rejected or Telegram can't be reached.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	body := map[string]interface{}{}
+	ok := true
+	loaded, problems := configState.get()
+	body["configLoaded"] = loaded
+	if len(problems) > 0 {
+		ok = false
+		body["configProblems"] = problems
+	}
+	// The probe is public, so the cause is only logged: it may name the
+	// request URL, which carries the bot token.
+	if err := telegramReachable(); err != nil {
+		ok = false
+		body["telegram"] = "unreachable"
+	} else {
+		body["telegram"] = "ok"
+	}
+	if leader := currentLeader(); leader != "" {
+		body["leader"] = leader
+	}
+	body["status"] = "ok"
+	if !ok {
+		body["status"] = "not ready"
+	}
+	writeProbe(w, ok, body)
+}
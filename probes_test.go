@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyzHidesTelegramError(t *testing.T) {
+	useConfig(t, "telegramBotToken: \""+testBotToken+"\"\n")
+	telegramStatus.Lock()
+	telegramStatus.checked = time.Now()
+	telegramStatus.err = &url.Error{Op: "Post", URL: "https://api.telegram.org/bot" + testBotToken + "/getMe", Err: context.DeadlineExceeded}
+	telegramStatus.Unlock()
+	t.Cleanup(func() {
+		telegramStatus.Lock()
+		telegramStatus.checked, telegramStatus.err = time.Time{}, nil
+		telegramStatus.Unlock()
+	})
+
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if body := w.Body.String(); strings.Contains(body, testBotToken) || !strings.Contains(body, `"telegram": "unreachable"`) {
+		t.Errorf("body %s, want telegram unreachable without the error", body)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushMu keeps pushes of loops finishing at the same time from overlapping.
+var pushMu sync.Mutex
+
+// lastPush is when the metrics were last pushed, guarded by pushMu.
+var lastPush time.Time
+
+// pushMetrics replaces the metrics of /metrics on the Pushgateway at
+// pushgateway.url, if set, for monitors that can't be scraped. It's called
+// after every check and pushes at most every 10 seconds, so hundreds of
+// hosts don't mean hundreds of pushes.
+func pushMetrics() {
+	cfg := conf().Sub("pushgateway")
+	if cfg == nil || cfg.GetString("url") == "" {
+		return
+	}
+	pushMu.Lock()
+	defer pushMu.Unlock()
+	if time.Since(lastPush) < defaultCheckInterval {
+		return
+	}
+	lastPush = time.Now()
+	job := cfg.GetString("job")
+	if job == "" {
+		job = "checkhealth"
+	}
+	p := push.New(cfg.GetString("url"), job).Gatherer(prometheus.DefaultGatherer).Client(httpClient)
+	grouping := cfg.GetStringMapString("grouping")
+	names := make([]string, 0, len(grouping))
+	for name := range grouping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p = p.Grouping(name, grouping[name])
+	}
+	if user := cfg.GetString("username"); user != "" {
+		p = p.BasicAuth(user, cfg.GetString("password"))
+	}
+
+	if err := p.Push(); err != nil {
+		slog.Error("Error pushing metrics to the Pushgateway", "err", err)
+	}
+}
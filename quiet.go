@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// quietHours is a daily window (possibly wrapping midnight) during which
+// only critical alerts go out immediately.
+type quietHours struct {
+	start, end time.Duration // offsets from local midnight
+	loc        *time.Location
+}
+
+func parseQuietHours(cfg *viper.Viper) (*quietHours, error) {
+	if cfg == nil || !cfg.IsSet("start") {
+		return nil, nil
+	}
+	start, err := time.Parse("15:04", cfg.GetString("start"))
+	if err != nil {
+		return nil, fmt.Errorf("quiet hours start: %w", err)
+	}
+	end, err := time.Parse("15:04", cfg.GetString("end"))
+	if err != nil {
+		return nil, fmt.Errorf("quiet hours end: %w", err)
+	}
+	loc := time.Local
+	if tz := cfg.GetString("timezone"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("quiet hours timezone: %w", err)
+		}
+	}
+	sinceMidnight := func(t time.Time) time.Duration {
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return &quietHours{start: sinceMidnight(start), end: sinceMidnight(end), loc: loc}, nil
+}
+
+func (q *quietHours) active(now time.Time) bool {
+	if q == nil {
+		return false
+	}
+	now = now.In(q.loc)
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	if q.start <= q.end {
+		return offset >= q.start && offset < q.end
+	}
+	return offset >= q.start || offset < q.end
+}
+
+// primaryQuietHours applies to the main telegramChatID.
+var primaryQuietHours *quietHours
+
+var quietQueues = struct {
+	sync.Mutex
+	m map[string]*digestBuffer
+}{m: map[string]*digestBuffer{}}
+
+// quietQueue returns the morning digest queue for a channel; "" is the main
+// chat.
+func quietQueue(channel string) *digestBuffer {
+	quietQueues.Lock()
+	defer quietQueues.Unlock()
+
+	q, ok := quietQueues.m[channel]
+	if !ok {
+		q = newDigestBuffer("Overnight digest")
+		quietQueues.m[channel] = q
+	}
+	return q
+}
+
+// quietChannel queues non-critical alerts for a channel while its quiet
+// hours are active.
+type quietChannel struct {
+	Notifier
+	name  string
+	hours *quietHours
+}
+
+func (c quietChannel) Notify(ctx context.Context, a Alert) error {
+	if a.Severity < SeverityCritical && c.hours.active(time.Now()) {
+		quietQueue(c.name).add(a)
+		return nil
+	}
+	return c.Notifier.Notify(ctx, a)
+}
+
+// runQuietHours sends each queued morning digest once its channel's quiet
+// hours are over.
+func runQuietHours() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now()
+
+		if !primaryQuietHours.active(now) {
+			quietQueue("").flush(sendTelegramHostMessage)
+		}
+		for name := range conf().GetStringMap("channels") {
+			hours, err := parseQuietHours(conf().Sub("channels." + name + ".quietHours"))
+			if err != nil || hours == nil || hours.active(now) {
+				continue
+			}
+			ch, err := channelByName(name)
+			if err != nil {
+				continue
+			}
+			quietQueue(name).flush(func(host, text string) {
+				if err := notify(name, ch, Alert{Host: host, Check: "digest", Severity: SeverityWarning, Message: text, Time: now}); err != nil {
+					slog.Error("Sending overnight digest failed", "channel", name, "err", err)
+				}
+			})
+		}
+	}
+}
+
+func loadQuietHours() {
+	hours, err := parseQuietHours(conf().Sub("quietHours"))
+	if err != nil {
+		fatal("Error reading quiet hours", "err", err)
+	}
+	primaryQuietHours = hours
+	go runQuietHours()
+}
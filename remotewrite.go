@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// promSeries is one sample of a Prometheus time series.
+type promSeries struct {
+	labels map[string]string // including __name__
+	value  float64
+	time   time.Time
+}
+
+// remoteWriteExporter sends samples and check results to a Prometheus
+// remote-write receiver (Mimir, VictoriaMetrics, Thanos, Prometheus with
+// --web.enable-remote-write-receiver) under the same names as /metrics.
+type remoteWriteExporter struct {
+	url         string
+	headers     map[string]string
+	user, pass  string
+	bearer      string
+	extraLabels map[string]string
+	batch       *batch[promSeries]
+}
+
+func newRemoteWriteExporter(cfg *viper.Viper) (sampleExporter, error) {
+	e := &remoteWriteExporter{
+		url:         cfg.GetString("url"),
+		headers:     cfg.GetStringMapString("headers"),
+		user:        cfg.GetString("username"),
+		pass:        cfg.GetString("password"),
+		bearer:      cfg.GetString("bearerToken"),
+		extraLabels: cfg.GetStringMapString("labels"),
+	}
+	if e.url == "" {
+		return nil, errors.New("url is required")
+	}
+	e.batch = &batch[promSeries]{name: "remoteWrite", interval: flushInterval(cfg), flush: e.write}
+	return e, nil
+}
+
+func (e *remoteWriteExporter) add(name string, labels map[string]string, value float64, t time.Time) {
+	all := map[string]string{"__name__": name}
+	for k, v := range e.extraLabels {
+		all[k] = v
+	}
+	for k, v := range labels {
+		all[k] = v
+	}
+	e.batch.add(promSeries{labels: all, value: value, time: t})
+}
+
+// Export maps health values to the gauges of /metrics and custom check
+// results to checkhealth_custom_value.
+func (e *remoteWriteExporter) Export(s metricSample) {
+	host := map[string]string{"host": s.Host.Name, "group": s.Host.Group}
+	with := func(k, v string) map[string]string {
+		labels := map[string]string{k: v}
+		for hk, hv := range host {
+			labels[hk] = hv
+		}
+		return labels
+	}
+	for name, v := range s.Values {
+		switch {
+		case metricNames[name] != "":
+			e.add("checkhealth_host_usage_percent", with("metric", name), v, s.Time)
+		case strings.HasPrefix(name, "load"):
+			e.add("checkhealth_host_load", with("period", strings.TrimPrefix(name, "load")), v, s.Time)
+		case name == "cores":
+			e.add("checkhealth_host_cores", host, v, s.Time)
+		case strings.HasPrefix(name, "net_"):
+			e.add("checkhealth_host_network_bytes_per_second", with("direction", strings.TrimPrefix(name, "net_")), v, s.Time)
+		default:
+			e.add("checkhealth_custom_value", with("check", name), v, s.Time)
+		}
+	}
+}
+
+func (e *remoteWriteExporter) ExportResult(h Host, check string, ok bool, message string, t time.Time) {
+	up := 0.0
+	if ok {
+		up = 1
+	}
+	e.add("checkhealth_check_up", map[string]string{"host": h.Name, "check": check}, up, t)
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest protobuf.
+func encodeWriteRequest(series []promSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		names := make([]string, 0, len(s.labels))
+		for name, value := range s.labels {
+			if value != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		var ts []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, s.labels[name])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.time.UnixMilli()))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+func (e *remoteWriteExporter) Flush() {
+	e.batch.send()
+}
+
+func (e *remoteWriteExporter) write(series []promSeries) error {
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "checkhealth")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	switch {
+	case e.bearer != "":
+		req.Header.Set("Authorization", "Bearer "+e.bearer)
+	case e.user != "":
+		req.SetBasicAuth(e.user, e.pass)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
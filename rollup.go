@@ -0,0 +1,63 @@
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Raw samples older than history.retention are downsampled into 5-minute
+// aggregates, kept until history.rollups.fiveMinute, and those into hourly
+// ones, kept until history.rollups.hourly, so months of history stay
+// queryable in a small database. Queries read the three tiers as one. With
+// history.rollups.enabled false they are deleted instead.
+func rollupsEnabled() bool {
+	return rollupsEnabledIn(conf())
+}
+
+func rollupsEnabledIn(cfg *viper.Viper) bool {
+	return !cfg.IsSet("history.rollups.enabled") || cfg.GetBool("history.rollups.enabled")
+}
+
+func fiveMinuteRetention() time.Duration {
+	return fiveMinuteRetentionIn(conf())
+}
+
+func fiveMinuteRetentionIn(cfg *viper.Viper) time.Duration {
+	if d := cfg.GetDuration("history.rollups.fiveMinute"); d > 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+func hourlyRetention() time.Duration {
+	return hourlyRetentionIn(conf())
+}
+
+func hourlyRetentionIn(cfg *viper.Viper) time.Duration {
+	if d := cfg.GetDuration("history.rollups.hourly"); d > 0 {
+		return d
+	}
+	return 365 * 24 * time.Hour
+}
+
+// rollupTier is a downsampling step: the samples of from older than the
+// cutoff are aggregated into buckets of to and deleted.
+type rollupTier struct {
+	from, to  string
+	bucket    time.Duration
+	retention time.Duration
+	raw       bool // from has single values instead of aggregates
+}
+
+func rollupTiers() []rollupTier {
+	return []rollupTier{
+		{"samples", "samples_5m", 5 * time.Minute, historyRetention(), true},
+		{"samples_5m", "samples_1h", time.Hour, fiveMinuteRetention(), false},
+	}
+}
+
+// cutoff is a whole hour, so a bucket is never aggregated in two parts.
+func (t rollupTier) cutoff(now time.Time) time.Time {
+	return now.Add(-t.retention).Truncate(time.Hour)
+}
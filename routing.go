@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// route sends an alert to extra channels by host group and tags, in addition
+// to the main chat.
+type route struct {
+	Group    string
+	Tags     []string
+	Severity string // minimum severity, "warning" (default) or "critical"
+	Channels []string
+}
+
+func (r route) matches(a Alert, h Host) bool {
+	if r.Group != "" && !strings.EqualFold(r.Group, h.Group) {
+		return false
+	}
+	for _, tag := range r.Tags {
+		if !h.HasTag(tag) {
+			return false
+		}
+	}
+	if strings.EqualFold(r.Severity, "critical") && a.Severity < SeverityCritical {
+		return false
+	}
+	return true
+}
+
+func routes() []route {
+	return routesIn(conf())
+}
+
+func routesIn(cfg *viper.Viper) []route {
+	var rs []route
+	if err := cfg.UnmarshalKey("routes", &rs); err != nil {
+		slog.Error("Error reading routes", "err", err)
+	}
+	return rs
+}
+
+// routeAlert notifies every channel whose route matches the alert's host,
+// and the channels configured for the host. Each channel is notified at most
+// once per alert.
+func routeAlert(a Alert) {
+	h, ok := hostByName(a.Host)
+	if !ok {
+		if h, ok = externalHost(a.Host); !ok {
+			return
+		}
+	}
+
+	// The host's (or group's) own channels act as one more route.
+	rs := routes()
+	if channels := hostChannels(h); len(channels) > 0 {
+		rs = append(rs, route{Channels: channels})
+	}
+
+	sent := map[string]bool{}
+	for _, r := range rs {
+		if !r.matches(a, h) {
+			continue
+		}
+		for _, name := range r.Channels {
+			if sent[name] {
+				continue
+			}
+			sent[name] = true
+
+			ch, err := channelByName(name)
+			if err != nil {
+				slog.Error("Error routing alert", "host", a.Host, "check", a.Check, "err", err)
+				continue
+			}
+			err = notify(name, ch, a)
+			if err != nil {
+				slog.Error("Routing alert failed", "host", a.Host, "check", a.Check, "channel", name, "err", err)
+			}
+			auditAlert("sent", a, name, errorResult(err))
+		}
+	}
+}
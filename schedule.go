@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const defaultCheckInterval = 10 * time.Second
+
+// checkRunners are the check types run by the daemon on each host, keyed by
+// the name used for their schedule under checkIntervals.
+var checkRunners = map[string]func(ctx context.Context, h Host){
+	"health":   runHealthCheck,
+	"custom":   runHostCustomChecks,
+	"timeline": runTimelineCheck,
+}
+
+// parseSchedule accepts a fixed interval such as "30s" or a cron expression
+// such as "0 3 * * *" or "@weekly", evaluated in local time unless it starts
+// with CRON_TZ=.
+func parseSchedule(spec string) (cron.Schedule, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+		return cron.Every(d), nil
+	}
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a duration such as 5m nor a cron expression: %v", spec, err)
+	}
+	return sched, nil
+}
+
+// scheduleSetting parses the schedule at key, falling back to def if it is
+// unset or invalid.
+func scheduleSetting(key string, def time.Duration) cron.Schedule {
+	spec := conf().GetString(key)
+	if spec == "" {
+		return cron.Every(def)
+	}
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		slog.Error("Invalid schedule, using the default", "key", key, "default", def, "err", err)
+		return cron.Every(def)
+	}
+	return sched
+}
+
+// checkScheduleSpec returns when a check type runs: checkIntervals.<name>,
+// else checkIntervals.default, else every 10 seconds.
+func checkScheduleSpec(name string) string {
+	key := "checkIntervals." + name
+	if !conf().IsSet(key) {
+		key = "checkIntervals.default"
+	}
+	if spec := conf().GetString(key); spec != "" {
+		return spec
+	}
+	return defaultCheckInterval.String()
+}
+
+// minCheckTimeout is the shortest default deadline of a check cycle, the
+// timeout of a single check command.
+const minCheckTimeout = 10 * time.Second
+
+// checkTimeout is how long a cycle of a check may run before its commands
+// are killed: checkTimeouts.<name>, else checkTimeouts.default, else until
+// the check is next due, at least 10 seconds.
+func checkTimeout(name string, sched cron.Schedule) time.Duration {
+	key := "checkTimeouts." + name
+	if !conf().IsSet(key) {
+		key = "checkTimeouts.default"
+	}
+	if d := conf().GetDuration(key); d > 0 {
+		return d
+	}
+	next := sched.Next(time.Now())
+	if d := sched.Next(next).Sub(next); d > minCheckTimeout {
+		return d
+	}
+	return minCheckTimeout
+}
+
+// hostCheckSchedule returns the interval of a check on one host from its
+// checkIntervals entry or its group's; "" if neither sets one.
+func hostCheckSchedule(h Host, check string) string {
+	for name, spec := range h.CheckIntervals {
+		if strings.EqualFold(name, check) {
+			return spec
+		}
+	}
+	if cfg := groupSetting(h.Group, "checkIntervals"); cfg != nil {
+		return cfg.GetString(check)
+	}
+	return ""
+}
+
+// runScheduled calls run whenever sched comes due until ctx is done. Fixed
+// intervals count from the end of the previous run; if immediate is set they
+// also run right away. Cron schedules always wait for their first time.
+func runScheduled(ctx context.Context, sched cron.Schedule, immediate bool, run func()) {
+	if _, ok := sched.(cron.ConstantDelaySchedule); ok && immediate {
+		run()
+	}
+	for {
+		timer := time.NewTimer(time.Until(sched.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			run()
+		}
+	}
+}
+
+// checkJitter is the most a check is started late, at random, so hosts on
+// the same schedule aren't all probed in the same second: checkJitter,
+// default 2s, at most half of a fixed interval.
+func checkJitter(sched cron.Schedule) time.Duration {
+	jitter := conf().GetDuration("checkJitter")
+	if every, ok := sched.(cron.ConstantDelaySchedule); ok && jitter > every.Delay/2 {
+		jitter = every.Delay / 2
+	}
+	return jitter
+}
+
+// jitteredSchedule delays each time of a schedule by up to max.
+type jitteredSchedule struct {
+	cron.Schedule
+	max time.Duration
+}
+
+func (s jitteredSchedule) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t).Add(randomDuration(s.max))
+}
+
+// randomDuration returns a random duration in [0, max).
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// hostLoop is a check loop of one host: a check type, or a custom check
+// with its own schedule. Its name, "<check>/<host>", is how the probes
+// report it.
+type hostLoop struct {
+	name, check, host, spec string
+	run                     func(ctx context.Context, h Host)
+}
+
+// hostLoops lists the loops the configured hosts need. A host's or its
+// group's checkIntervals entry overrides the schedule of the check.
+func hostLoops() map[string]hostLoop {
+	want := map[string]hostLoop{}
+	add := func(h Host, check, spec string, run func(ctx context.Context, h Host)) {
+		if s := hostCheckSchedule(h, check); s != "" {
+			spec = s
+		}
+		name := check + "/" + h.Name
+		want[strings.ToLower(name)] = hostLoop{name: name, check: check, host: h.Name, spec: spec, run: run}
+	}
+	checks := customChecks()
+	for _, h := range configuredHosts() {
+		for check, run := range checkRunners {
+			add(h, check, checkScheduleSpec(check), run)
+		}
+		for _, c := range checks {
+			if c.Schedule != "" && c.appliesTo(h) {
+				add(h, c.Name, c.Schedule, c.run)
+			}
+		}
+	}
+	return want
+}
+
+// runRecovered runs a check of a host, recovering from a panic in it so the
+// other loops keep running. A panic is raised as a critical
+// "internal-<check>" alert of the host, resolved once the check completes
+// again.
+func runRecovered(ctx context.Context, h Host, check string, run func(ctx context.Context, h Host)) {
+	key := "internal-" + check
+	if err := recoverPanic(fmt.Sprintf("the %s check of %s", check, h.Label()), func() { run(ctx, h) }); err != nil {
+		checkPanics.WithLabelValues(check).Inc()
+		raiseAlert(Alert{Host: h.Name, Address: h.Address, Check: key, Severity: SeverityCritical, Message: fmt.Sprintf("Monitor internal error in the %s check: %v", check, err)})
+		return
+	}
+	if _, active := alerts.get(h.Name + "/" + key); active {
+		clearAlert(h.Name, key)
+	}
+}
+
+// recoverPanic calls fn and returns a panic in it as an error, after logging
+// it with its stack.
+func recoverPanic(what string, fn func()) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			slog.Error("Panic in "+what, "panic", v, "stack", string(debug.Stack()))
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// startChecks runs every check of every host in its own loop, so a slow or
+// unreachable host doesn't delay the others. The loops are started and
+// stopped as hosts come and go, and restarted when their schedule changes;
+// each cycle uses the host's current settings and runs until its
+// checkTimeout. The loops stop starting cycles when ctx is done; the
+// returned WaitGroup is done once their running cycles have finished. Those
+// are only canceled when cycles is, or when their loop is stopped.
+func startChecks(ctx, cycles context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	type running struct {
+		name, spec string
+		stop       context.CancelFunc
+	}
+	loopsByName := map[string]running{}
+	start := func(l hostLoop) {
+		sched, err := parseSchedule(l.spec)
+		if err != nil {
+			slog.Error("Invalid schedule, using the default", "loop", l.name, "default", defaultCheckInterval, "err", err)
+			sched = cron.Every(defaultCheckInterval)
+		}
+		jittered := jitteredSchedule{sched, checkJitter(sched)}
+		loopCtx, stopLoop := context.WithCancel(ctx)
+		cycleCtx, abort := context.WithCancel(cycles)
+		stop := func() {
+			stopLoop()
+			abort()
+		}
+		loopsByName[strings.ToLower(l.name)] = running{l.name, l.spec, stop}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run := loops.track(l.name, jittered, func() {
+				if h, ok := hostByName(l.host); ok {
+					ctx, cancel := context.WithTimeout(cycleCtx, checkTimeout(l.check, sched))
+					defer cancel()
+					runRecovered(ctx, h, l.check, l.run)
+				}
+			})
+			// Fixed intervals start right away, spread over the first
+			// interval so a restart doesn't check every host at once.
+			if every, ok := sched.(cron.ConstantDelaySchedule); ok {
+				timer := time.NewTimer(randomDuration(every.Delay))
+				select {
+				case <-loopCtx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				run()
+			}
+			runScheduled(loopCtx, jittered, false, run)
+		}()
+	}
+	refresh := func() {
+		want := hostLoops()
+		if !isLeader() {
+			want = nil
+		}
+		for key, r := range loopsByName {
+			if l, ok := want[key]; !ok || l.spec != r.spec {
+				r.stop()
+				loops.remove(r.name)
+				delete(loopsByName, key)
+			}
+		}
+		for key, l := range want {
+			if _, ok := loopsByName[key]; !ok {
+				start(l)
+			}
+		}
+	}
+
+	changed := leaderChanged()
+	refresh()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// The loops start and stop right away when the monitor becomes
+		// the leader or stands by.
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-changed:
+			}
+			changed = leaderChanged()
+			refresh()
+		}
+	}()
+	return &wg
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// SecretProvider resolves a secret stored outside the config file. path
+// identifies the secret document and key the field within it.
+type SecretProvider interface {
+	Secret(path, key string) (string, error)
+}
+
+var secretProviders = map[string]func() SecretProvider{
+	"vault": newVaultProvider,
+	"sops":  newSopsProvider,
+}
+
+// resolveSecret looks up a reference of the form
+// "<provider>:<path>#<key>", e.g. "vault:secret/data/checkhealth#token".
+func resolveSecret(ref string) (string, error) {
+	provider, rest, _ := strings.Cut(ref, ":")
+	newProvider, ok := secretProviders[provider]
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider %q", provider)
+	}
+	path, key, ok := strings.Cut(rest, "#")
+	if !ok {
+		return "", fmt.Errorf("secret reference %q has no #key", ref)
+	}
+	return newProvider().Secret(path, key)
+}
+
+// writeSecretFile stores a secret such as an SSH private key in a file only
+// the current user can read and returns its path.
+func writeSecretFile(value string) (string, error) {
+	f, err := os.CreateTemp("", "checkhealth-secret-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := f.Chmod(0o600); err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(value, "\n") {
+		value += "\n"
+	}
+	if _, err := f.WriteString(value); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// vaultProvider reads from HashiCorp Vault's HTTP API. Both KV v1 and KV v2
+// (where the path includes "data/") are supported.
+type vaultProvider struct {
+	address, token, namespace string
+}
+
+func newVaultProvider() SecretProvider {
+	address := conf().GetString("secrets.vault.address")
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	token := conf().GetString("secrets.vault.token")
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	return vaultProvider{
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		namespace: conf().GetString("secrets.vault.namespace"),
+	}
+}
+
+func (v vaultProvider) Secret(path, key string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, v.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault: reading %s returned %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested // KV v2
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault: %s has no key %q", path, key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// sopsProvider decrypts SOPS-encrypted files with the sops binary, which
+// takes care of KMS/age/PGP key access. Nested keys are dot-separated.
+type sopsProvider struct{}
+
+var sopsCache = struct {
+	sync.Mutex
+	files map[string]map[string]interface{}
+}{files: map[string]map[string]interface{}{}}
+
+func newSopsProvider() SecretProvider {
+	return sopsProvider{}
+}
+
+func (sopsProvider) Secret(path, key string) (string, error) {
+	sopsCache.Lock()
+	defer sopsCache.Unlock()
+
+	doc, ok := sopsCache.files[path]
+	if !ok {
+		binary := conf().GetString("secrets.sops.binary")
+		if binary == "" {
+			binary = "sops"
+		}
+		out, err := exec.Command(binary, "--decrypt", "--output-type", "json", path).Output()
+		if err != nil {
+			return "", fmt.Errorf("sops: decrypting %s: %w", path, err)
+		}
+		if err := json.Unmarshal(out, &doc); err != nil {
+			return "", fmt.Errorf("sops: %s: %w", path, err)
+		}
+		sopsCache.files[path] = doc
+	}
+
+	var value interface{} = doc
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("sops: %s has no key %q", path, key)
+		}
+		if value, ok = m[part]; !ok {
+			return "", fmt.Errorf("sops: %s has no key %q", path, key)
+		}
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// startedAt is when the monitor started.
+var startedAt = time.Now()
+
+// notifyFailures counts the notifications that failed to send since the
+// start, by channel.
+var notifyFailures = struct {
+	sync.Mutex
+	byChannel map[string]int64
+}{byChannel: map[string]int64{}}
+
+func countNotifyFailure(channel string) {
+	notifyFailures.Lock()
+	defer notifyFailures.Unlock()
+	notifyFailures.byChannel[channel]++
+	notificationFailures.WithLabelValues(channel).Inc()
+}
+
+// overrunCheck is the check of the monitor's own alert about check loops
+// that can't keep up with their interval.
+const overrunCheck = "monitor-overrun"
+
+// overrunsToAlert is how many cycles of a loop in a row must overrun its
+// interval before the monitor alerts, so a single slow cycle doesn't.
+const overrunsToAlert = 3
+
+// behindLoops returns the names of the overdue loops and of those whose
+// last cycle overran their interval.
+func behindLoops(states map[string]loopState) (overdue, overrunning []string) {
+	overdue, overrunning = []string{}, []string{}
+	for name, s := range states {
+		if s.Overdue {
+			overdue = append(overdue, name)
+		}
+		if s.Overruns > 0 {
+			overrunning = append(overrunning, name)
+		}
+	}
+	sort.Strings(overdue)
+	sort.Strings(overrunning)
+	return overdue, overrunning
+}
+
+// checkSelf updates the gauges of loops falling behind and raises a warning
+// about the monitor itself, named after history.instance, while check loops
+// keep overrunning their interval; it resolves once they keep up again.
+func checkSelf(now time.Time) {
+	states := loops.state(now)
+	overdue, overrunning := behindLoops(states)
+	loopsBehind.WithLabelValues("overdue").Set(float64(len(overdue)))
+	loopsBehind.WithLabelValues("overrunning").Set(float64(len(overrunning)))
+
+	var slow []string
+	for _, name := range overrunning {
+		if states[name].Overruns >= overrunsToAlert {
+			slow = append(slow, name)
+		}
+	}
+	self := historyInstance()
+	if len(slow) == 0 {
+		if _, active := alerts.get(self + "/" + overrunCheck); active {
+			clearAlert(self, overrunCheck)
+		}
+		return
+	}
+	names := slow
+	if len(names) > 5 {
+		names = append(names[:5:5], fmt.Sprintf("%d more", len(slow)-5))
+	}
+	raiseAlert(Alert{Host: self, Check: overrunCheck, Severity: SeverityWarning, Time: now,
+		Message: fmt.Sprintf("Cycles take longer than the interval in %d check loops: %s", len(slow), strings.Join(names, ", "))})
+}
+
+// runSelfMonitor checks the monitor's own loops every minute until ctx is
+// done.
+func runSelfMonitor(ctx context.Context) {
+	runScheduled(ctx, cron.Every(time.Minute), false, func() { checkSelf(time.Now()) })
+}
+
+// monitorStatus is the monitor's own health, for GET /api/v1/monitor. The
+// same figures are on /metrics, along with the go_ and process_ metrics.
+type monitorStatus struct {
+	Instance             string           `json:"instance"`
+	Leader               string           `json:"leader,omitempty"`
+	Version              string           `json:"version"`
+	Started              time.Time        `json:"started"`
+	UptimeSeconds        float64          `json:"uptimeSeconds"`
+	Goroutines           int              `json:"goroutines"`
+	HeapBytes            uint64           `json:"heapBytes"`
+	SysBytes             uint64           `json:"sysBytes"`
+	Loops                int              `json:"loops"`
+	Overdue              []string         `json:"overdue"`
+	Overrunning          []string         `json:"overrunning"`
+	SSHSessionsActive    int              `json:"sshSessionsActive"`
+	SSHSessionsWaiting   int              `json:"sshSessionsWaiting"`
+	QueuedNotifications  int              `json:"queuedNotifications"`
+	NotificationFailures map[string]int64 `json:"notificationFailures"`
+}
+
+func currentMonitorStatus(now time.Time) monitorStatus {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	states := loops.state(now)
+	overdue, overrunning := behindLoops(states)
+	st := monitorStatus{
+		Instance:             historyInstance(),
+		Leader:               currentLeader(),
+		Version:              version,
+		Started:              startedAt,
+		UptimeSeconds:        now.Sub(startedAt).Seconds(),
+		Goroutines:           runtime.NumGoroutine(),
+		HeapBytes:            mem.HeapAlloc,
+		SysBytes:             mem.Sys,
+		Loops:                len(states),
+		Overdue:              overdue,
+		Overrunning:          overrunning,
+		NotificationFailures: map[string]int64{},
+	}
+	sshSessions.mu.Lock()
+	st.SSHSessionsActive, st.SSHSessionsWaiting = sshSessions.active, sshSessions.waiting
+	sshSessions.mu.Unlock()
+	if queue != nil {
+		queue.mu.Lock()
+		st.QueuedNotifications = len(queue.messages)
+		queue.mu.Unlock()
+	}
+	notifyFailures.Lock()
+	for channel, n := range notifyFailures.byChannel {
+		st.NotificationFailures[channel] = n
+	}
+	notifyFailures.Unlock()
+	return st
+}
+
+func apiMonitorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentMonitorStatus(time.Now()))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+func listenAddress() string {
+	if addr := conf().GetString("http.listen"); addr != "" {
+		return addr
+	}
+	return ":8002"
+}
+
+// shutdownTimeout is how long shutting down waits for HTTP requests and
+// check cycles in progress, and then for queued notifications to be sent:
+// http.shutdownTimeout, default 30s.
+func shutdownTimeout() time.Duration {
+	if d := conf().GetDuration("http.shutdownTimeout"); d > 0 {
+		return d
+	}
+	return 30 * time.Second
+}
+
+// serveHTTP serves handler on http.listen, over TLS when http.tls has a
+// certificate and key or selfSigned set, until ctx is done. It then stops
+// accepting connections and waits for the requests in progress. Requests'
+// contexts end with ctx, so event streams close instead of holding it up.
+func serveHTTP(ctx context.Context, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:        listenAddress(),
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	cert, key := conf().GetString("http.tls.cert"), conf().GetString("http.tls.key")
+	if cert == "" && key == "" && conf().GetBool("http.tls.selfSigned") {
+		c, err := selfSignedCertificate(conf().GetStringSlice("http.tls.hosts"))
+		if err != nil {
+			return fmt.Errorf("generating a self-signed certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{c}}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		switch {
+		case cert != "" || key != "":
+			slog.Info("Serving HTTPS", "addr", srv.Addr)
+			errs <- srv.ListenAndServeTLS(cert, key)
+		case srv.TLSConfig != nil:
+			slog.Info("Serving HTTPS with a self-signed certificate", "addr", srv.Addr)
+			errs <- srv.ListenAndServeTLS("", "")
+		default:
+			slog.Info("Serving HTTP", "addr", srv.Addr)
+			errs <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down the HTTP server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// selfSignedCertificate creates a certificate valid for a year for the
+// given host names and addresses, or the machine's hostname and localhost.
+func selfSignedCertificate(hosts []string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+		if name, err := os.Hostname(); err == nil {
+			hosts = append(hosts, name)
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{"checkhealth"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// Silence mutes alerts for a host and/or check during a time range, e.g. a
+// planned maintenance window. Empty Host or Check match everything.
+type Silence struct {
+	ID      string    `json:"id"`
+	Host    string    `json:"host"`
+	Check   string    `json:"check"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Comment string    `json:"comment"`
+
+	configured bool // from the config file rather than the API
+}
+
+func (s *Silence) matches(a Alert, now time.Time) bool {
+	if now.Before(s.Start) || !now.Before(s.End) {
+		return false
+	}
+	return s.covers(a)
+}
+
+// covers reports whether the silence is for the alert's host and check,
+// whatever their case, as hostByName matches host names.
+func (s *Silence) covers(a Alert) bool {
+	return (s.Host == "" || strings.EqualFold(s.Host, a.Host)) && (s.Check == "" || strings.EqualFold(s.Check, a.Check))
+}
+
+type silenceStore struct {
+	mu       sync.Mutex
+	silences map[string]*Silence
+}
+
+var silences = &silenceStore{silences: map[string]*Silence{}}
+
+func (s *silenceStore) add(sil Silence) Silence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sil.ID == "" {
+		b := make([]byte, 4)
+		rand.Read(b)
+		sil.ID = hex.EncodeToString(b)
+	}
+	s.silences[sil.ID] = &sil
+	return sil
+}
+
+func (s *silenceStore) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.silences[id]
+	delete(s.silences, id)
+	return ok
+}
+
+func (s *silenceStore) get(id string) (Silence, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sil, ok := s.silences[id]
+	if !ok {
+		return Silence{}, false
+	}
+	return *sil, true
+}
+
+// silenced reports whether any silence currently covers the alert.
+func (s *silenceStore) silenced(a Alert, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sil := range s.silences {
+		if sil.matches(a, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *silenceStore) list() []Silence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Silence, 0, len(s.silences))
+	for _, sil := range s.silences {
+		list = append(list, *sil)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list
+}
+
+// expire drops silences that have ended. If alerts they covered are still
+// active, a follow-up is sent so the issue doesn't stay hidden.
+func (s *silenceStore) expire(now time.Time) {
+	s.mu.Lock()
+	var ended []Silence
+	for id, sil := range s.silences {
+		if now.Before(sil.End) {
+			continue
+		}
+		ended = append(ended, *sil)
+		delete(s.silences, id)
+	}
+	s.mu.Unlock()
+
+	for _, sil := range ended {
+		var still []string
+		for _, aa := range alerts.list() {
+			if sil.covers(aa.Alert) {
+				still = append(still, aa.String())
+			}
+		}
+		if len(still) == 0 {
+			continue
+		}
+		name := sil.ID
+		if sil.Comment != "" {
+			name = fmt.Sprintf("%q", sil.Comment)
+		}
+		sendTelegramMessage(fmt.Sprintf("Silence %s expired, issues still present:\n%s", name, strings.Join(still, "\n")))
+	}
+}
+
+func loadSilences() {
+	var configured []Silence
+	err := conf().UnmarshalKey("silences", &configured, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+		mapstructure.StringToTimeDurationHookFunc(),
+	)))
+	if err != nil {
+		slog.Error("Error reading silences", "err", err)
+		return
+	}
+	for _, sil := range configured {
+		sil.configured = true
+		silences.add(sil)
+	}
+}
+
+func runSilenceExpiry() {
+	for {
+		time.Sleep(30 * time.Second)
+		silences.expire(time.Now())
+	}
+}
+
+// silencesHandler lists (GET), creates (POST) and deletes (DELETE ?id=)
+// silences. POST accepts either an explicit end or a duration such as "2h".
+func silencesHandler(w http.ResponseWriter, r *http.Request) {
+	// Scoped requests only see and manage the silences of their hosts.
+	scope := requestScope(r)
+	visible := func(sil Silence) bool {
+		return scope == nil || sil.Host != "" && scope.allowsHost(sil.Host)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		list := []Silence{}
+		for _, sil := range silences.list() {
+			if visible(sil) {
+				list = append(list, sil)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		var req struct {
+			Silence
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sil := req.Silence
+		if !visible(sil) {
+			http.Error(w, "silences must be for a host of your groups", http.StatusForbidden)
+			return
+		}
+		if sil.Start.IsZero() {
+			sil.Start = time.Now()
+		}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sil.End = sil.Start.Add(d)
+		}
+		if !sil.End.After(sil.Start) {
+			http.Error(w, "silence must end after it starts", http.StatusBadRequest)
+			return
+		}
+		sil = silences.add(sil)
+		storeSilence(sil)
+		slog.Info("Silence added", "silence", sil.ID, "host", sil.Host, "check", sil.Check, "until", sil.End)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sil)
+	case http.MethodDelete:
+		id := r.FormValue("id")
+		if sil, ok := silences.get(id); !ok || !visible(sil) || !silences.remove(id) {
+			http.NotFound(w, r)
+			return
+		}
+		unstoreSilence(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
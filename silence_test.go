@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSilenceMatches(t *testing.T) {
+	now := time.Now()
+	a := Alert{Host: "Web1", Check: "disk"}
+	tests := []struct {
+		name string
+		sil  Silence
+		want bool
+	}{
+		{"host and check", Silence{Host: "Web1", Check: "disk"}, true},
+		{"other case", Silence{Host: "web1", Check: "DISK"}, true},
+		{"any check", Silence{Host: "WEB1"}, true},
+		{"everything", Silence{}, true},
+		{"other host", Silence{Host: "web2"}, false},
+		{"other check", Silence{Host: "web1", Check: "cpu"}, false},
+		{"ended", Silence{Host: "web1", End: now}, false},
+		{"not started", Silence{Host: "web1", Start: now.Add(time.Minute)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.sil.End.IsZero() {
+				tt.sil.End = now.Add(time.Hour)
+			}
+			if got := tt.sil.matches(a, now); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// availability is the time a host (or the hosts of a group, on average)
+// spent with all of its checks passing over a period. Time without check
+// results, e.g. while the monitor was stopped, counts as unknown and is left
+// out of the percentage.
+type availability struct {
+	Host         string  `json:"host,omitempty"`
+	Group        string  `json:"group,omitempty"`
+	Availability float64 `json:"availability"` // percent of the known time
+	Up           float64 `json:"upSeconds"`
+	Down         float64 `json:"downSeconds"`
+	Unknown      float64 `json:"unknownSeconds"`
+}
+
+// slaReport is the availability of every host and group over a period.
+type slaReport struct {
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Hosts  []availability `json:"hosts"`
+	Groups []availability `json:"groups"`
+}
+
+// slaMaxGap is how long a check result counts for: longer gaps between a
+// host's results count as unknown.
+func slaMaxGap() time.Duration {
+	if d := conf().GetDuration("sla.maxGap"); d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// countsForSLA reports whether a check's failures count as downtime:
+// those in sla.checks, or every check but the trend and anomaly warnings,
+// which predict or hint at trouble rather than being it.
+func countsForSLA(check string) bool {
+	if checks := conf().GetStringSlice("sla.checks"); len(checks) > 0 {
+		for _, c := range checks {
+			if strings.EqualFold(c, check) {
+				return true
+			}
+		}
+		return false
+	}
+	return !strings.HasSuffix(check, "-trend") && !strings.HasSuffix(check, "-anomaly")
+}
+
+// slaEvent is a stored check result.
+type slaEvent struct {
+	check string
+	time  time.Time
+	ok    bool
+}
+
+// hostAvailability computes the availability of every host with check
+// results between from and to, by host name in lower case.
+func hostAvailability(from, to time.Time) (map[string]availability, error) {
+	store := series.storage()
+	if store == nil {
+		return nil, fmt.Errorf("no history database")
+	}
+	maxGap := slaMaxGap()
+	// The state at from is the latest result before it.
+	list, err := store.latestResults(from.Add(-maxGap), from)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	during, err := store.results(from, to)
+	if err != nil {
+		return nil, err
+	}
+	events := map[string][]slaEvent{}
+	for _, r := range append(list, during...) {
+		if countsForSLA(r.Check) {
+			key := strings.ToLower(r.Host)
+			events[key] = append(events[key], slaEvent{r.Check, r.Time, r.OK})
+		}
+	}
+
+	end := to
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+	total := end.Sub(from).Seconds()
+	hosts := map[string]availability{}
+	for key, list := range events {
+		var a availability
+		failing := map[string]bool{}
+		span := func(start, stop time.Time) {
+			if start.Before(from) {
+				start = from
+			}
+			if !stop.After(start) {
+				return
+			}
+			d := stop.Sub(start)
+			if d > maxGap {
+				d = maxGap
+			}
+			if len(failing) == 0 {
+				a.Up += d.Seconds()
+			} else {
+				a.Down += d.Seconds()
+			}
+		}
+		for i, e := range list {
+			if i > 0 {
+				span(list[i-1].time, e.time)
+			}
+			if e.ok {
+				delete(failing, e.check)
+			} else {
+				failing[e.check] = true
+			}
+		}
+		span(list[len(list)-1].time, end)
+		a.Host = key
+		a.Unknown = total - a.Up - a.Down
+		a.Availability = percent(a.Up, a.Up+a.Down)
+		hosts[key] = a
+	}
+	return hosts, nil
+}
+
+func percent(part, whole float64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return part / whole * 100
+}
+
+// computeSLA reports the availability of the configured hosts in scope, and
+// of their groups as the average of the group's hosts.
+func computeSLA(from, to time.Time, scope groupScope) (slaReport, error) {
+	byHost, err := hostAvailability(from, to)
+	if err != nil {
+		return slaReport{}, err
+	}
+	report := slaReport{From: from, To: to, Hosts: []availability{}, Groups: []availability{}}
+	groups := map[string]*availability{}
+	counts := map[string]int{}
+	var order []string
+	for _, h := range configuredHosts() {
+		if !scope.allows(h.Group) {
+			continue
+		}
+		a, ok := byHost[strings.ToLower(h.Name)]
+		if !ok {
+			continue
+		}
+		a.Host, a.Group = h.Name, h.Group
+		report.Hosts = append(report.Hosts, a)
+		if h.Group == "" {
+			continue
+		}
+		g, ok := groups[h.Group]
+		if !ok {
+			g = &availability{Group: h.Group}
+			groups[h.Group] = g
+			order = append(order, h.Group)
+		}
+		g.Availability += a.Availability
+		g.Up += a.Up
+		g.Down += a.Down
+		g.Unknown += a.Unknown
+		counts[h.Group]++
+	}
+	sort.Strings(order)
+	for _, name := range order {
+		g := *groups[name]
+		g.Availability /= float64(counts[name])
+		report.Groups = append(report.Groups, g)
+	}
+	return report, nil
+}
+
+// slaPeriod reads the range of an availability request: period day, week
+// or month (the last 24 hours, 7 days or 30 days), or from and to as for
+// the history export.
+func slaPeriod(r *http.Request, now time.Time) (from, to time.Time, err error) {
+	to = now
+	if v := r.FormValue("to"); v != "" {
+		if to, err = parseTimeArg(v, now); err != nil {
+			return from, to, fmt.Errorf("to: %w", err)
+		}
+	}
+	if v := r.FormValue("from"); v != "" {
+		from, err = parseTimeArg(v, now)
+		if err != nil {
+			err = fmt.Errorf("from: %w", err)
+		}
+		return from, to, err
+	}
+	switch r.FormValue("period") {
+	case "", "day":
+		return to.Add(-24 * time.Hour), to, nil
+	case "week":
+		return to.AddDate(0, 0, -7), to, nil
+	case "month":
+		return to.AddDate(0, 0, -30), to, nil
+	}
+	return from, to, fmt.Errorf("period must be day, week or month")
+}
+
+// apiSLAHandler serves the availability of the hosts and groups.
+func apiSLAHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := slaPeriod(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	report, err := computeSLA(from, to, requestScope(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// formatSLAReport formats the availability of a period for Telegram.
+func formatSLAReport(title string, report slaReport) string {
+	var b strings.Builder
+	b.WriteString(title)
+	line := func(name string, a availability) {
+		fmt.Fprintf(&b, "\n%s: %.3f%%", name, a.Availability)
+		if a.Down > 0 {
+			fmt.Fprintf(&b, " (down %s)", formatSeconds(a.Down))
+		}
+		if a.Unknown > 0.01*(a.Up+a.Down+a.Unknown) {
+			fmt.Fprintf(&b, " (no data for %s)", formatSeconds(a.Unknown))
+		}
+	}
+	if len(report.Hosts) == 0 {
+		b.WriteString("\nNo check results in this period.")
+	}
+	for _, a := range report.Hosts {
+		line(a.Host, a)
+	}
+	if len(report.Groups) > 0 {
+		b.WriteString("\n\nGroups:")
+		for _, g := range report.Groups {
+			line(g.Group, g)
+		}
+	}
+	return b.String()
+}
+
+func formatSeconds(s float64) string {
+	d := time.Duration(s * float64(time.Second))
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return d.Round(time.Minute).String()
+}
+
+// previousMonth returns the calendar month before the one of now in loc.
+func previousMonth(now time.Time, loc *time.Location) (from, to time.Time) {
+	now = now.In(loc)
+	to = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	return to.AddDate(0, -1, 0), to
+}
+
+// runSLAReport sends the availability of the previous calendar month to the
+// main chat on sla.reportSchedule, by default at 09:00 on the first of every
+// month. Reports over a whole month need history.retention of at least 31
+// days.
+func runSLAReport(ctx context.Context) {
+	spec := conf().GetString("sla.reportSchedule")
+	if spec == "" {
+		spec = "0 9 1 * *"
+	}
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		slog.Error("Invalid sla.reportSchedule", "err", err)
+		return
+	}
+	if historyRetention() < 31*24*time.Hour {
+		slog.Warn("history.retention is shorter than a month, the monthly availability report will only cover that", "retention", historyRetention())
+	}
+	slog.Info("Sending monthly availability reports", "schedule", spec)
+	runScheduled(ctx, sched, false, func() {
+		from, to := previousMonth(time.Now(), displayLocation())
+		report, err := computeSLA(from, to, nil)
+		if err != nil {
+			slog.Error("Error computing availability", "err", err)
+			return
+		}
+		sendTelegramMessage(formatSLAReport("Availability "+from.Format("January 2006"), report))
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS samples (
+	host   TEXT NOT NULL COLLATE NOCASE,
+	metric TEXT NOT NULL,
+	time   INTEGER NOT NULL, -- Unix milliseconds
+	value  REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS samples_host_metric_time ON samples (host, metric, time);
+CREATE INDEX IF NOT EXISTS samples_time ON samples (time);
+CREATE TABLE IF NOT EXISTS samples_5m (
+	host   TEXT NOT NULL COLLATE NOCASE,
+	metric TEXT NOT NULL,
+	time   INTEGER NOT NULL, -- start of the bucket
+	avg    REAL NOT NULL,
+	min    REAL NOT NULL,
+	max    REAL NOT NULL,
+	count  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS samples_5m_host_metric_time ON samples_5m (host, metric, time);
+CREATE INDEX IF NOT EXISTS samples_5m_time ON samples_5m (time);
+CREATE TABLE IF NOT EXISTS samples_1h (
+	host   TEXT NOT NULL COLLATE NOCASE,
+	metric TEXT NOT NULL,
+	time   INTEGER NOT NULL,
+	avg    REAL NOT NULL,
+	min    REAL NOT NULL,
+	max    REAL NOT NULL,
+	count  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS samples_1h_host_metric_time ON samples_1h (host, metric, time);
+CREATE INDEX IF NOT EXISTS samples_1h_time ON samples_1h (time);
+CREATE TABLE IF NOT EXISTS results (
+	host    TEXT NOT NULL COLLATE NOCASE,
+	name    TEXT NOT NULL,
+	time    INTEGER NOT NULL,
+	ok      INTEGER NOT NULL,
+	message TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS results_host_name_time ON results (host, name, time);
+CREATE INDEX IF NOT EXISTS results_time ON results (time);
+CREATE TABLE IF NOT EXISTS alert_history (
+	time     INTEGER NOT NULL,
+	event    TEXT NOT NULL,
+	host     TEXT NOT NULL COLLATE NOCASE,
+	name     TEXT NOT NULL,
+	severity TEXT NOT NULL,
+	message  TEXT NOT NULL,
+	"by"     TEXT NOT NULL,
+	payload  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS alert_history_time ON alert_history (time);
+CREATE INDEX IF NOT EXISTS alert_history_host_name_time ON alert_history (host, name, time);
+CREATE TABLE IF NOT EXISTS incidents (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	host     TEXT NOT NULL COLLATE NOCASE,
+	started  INTEGER NOT NULL,
+	ended    INTEGER, -- NULL while ongoing
+	severity TEXT NOT NULL,
+	checks   TEXT NOT NULL -- comma-separated
+);
+CREATE INDEX IF NOT EXISTS incidents_host_started ON incidents (host, started);
+CREATE TABLE IF NOT EXISTS host_events (
+	time    INTEGER NOT NULL,
+	host    TEXT NOT NULL COLLATE NOCASE,
+	kind    TEXT NOT NULL,
+	message TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS host_events_host_time ON host_events (host, time);
+CREATE INDEX IF NOT EXISTS host_events_time ON host_events (time);
+CREATE TABLE IF NOT EXISTS state (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL -- JSON
+);
+CREATE TABLE IF NOT EXISTS leases (
+	name    TEXT PRIMARY KEY,
+	holder  TEXT NOT NULL,
+	expires INTEGER NOT NULL
+);
+`
+
+func openSQLite(path string) (*sqlStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes the writes of the check loops.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &sqlStore{db: db, dialect: "sqlite"}, nil
+}
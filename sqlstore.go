@@ -0,0 +1,532 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// allSamples reads the raw samples and both rollups as one table.
+const allSamples = `(
+	SELECT host, metric, time, value AS avg, value AS min, value AS max, 1 AS count FROM samples
+	UNION ALL SELECT host, metric, time, avg, min, max, count FROM samples_5m
+	UNION ALL SELECT host, metric, time, avg, min, max, count FROM samples_1h
+) AS all_samples`
+
+// sqlStore keeps the history in a SQL database: SQLite, or PostgreSQL,
+// which several monitors can share. The queries are written for SQLite and
+// run on PostgreSQL with numbered placeholders; its schema makes host names
+// case-insensitive like SQLite's NOCASE.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string // sqlite or postgres
+}
+
+// q adapts a query to the dialect, numbering the placeholders for
+// PostgreSQL.
+func (s *sqlStore) q(query string) string {
+	if s.dialect != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// timeRange turns a time range into Unix milliseconds, zero times being
+// unbounded.
+func timeRange(from, to time.Time) (int64, int64) {
+	start, end := int64(math.MinInt64), int64(math.MaxInt64)
+	if !from.IsZero() {
+		start = from.UnixMilli()
+	}
+	if !to.IsZero() {
+		end = to.UnixMilli()
+	}
+	return start, end
+}
+
+func (s *sqlStore) addSample(host, metric string, value float64, t time.Time) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO samples (host, metric, time, value) VALUES (?, ?, ?, ?)`),
+		host, metric, t.UnixMilli(), value)
+	return err
+}
+
+func (s *sqlStore) addResult(host string, r checkResult) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO results (host, name, time, ok, message) VALUES (?, ?, ?, ?, ?)`),
+		host, r.Check, r.Time.UnixMilli(), r.OK, r.Message)
+	return err
+}
+
+func (s *sqlStore) points(host, metric string, from time.Time) ([]point, error) {
+	rows, err := s.db.Query(s.q(`SELECT time, avg FROM `+allSamples+` WHERE host = ? AND metric = ? AND time >= ? ORDER BY time`),
+		host, metric, from.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []point
+	for rows.Next() {
+		var ms int64
+		var p point
+		if err := rows.Scan(&ms, &p.Value); err != nil {
+			return points, err
+		}
+		p.Time = time.UnixMilli(ms)
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (s *sqlStore) metrics(host string) ([]string, error) {
+	rows, err := s.db.Query(s.q(`SELECT DISTINCT metric FROM `+allSamples+` WHERE host = ?`), host)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s *sqlStore) stats(metric string, from, to time.Time) ([]metricStats, error) {
+	args := []interface{}{metric, from.UnixMilli(), to.UnixMilli()}
+	const where = ` WHERE metric = ? AND time >= ? AND time < ? GROUP BY host`
+	rows, err := s.db.Query(s.q(`SELECT host, SUM(count), SUM(avg * count) / SUM(count), MIN(min), MAX(max) FROM `+allSamples+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []metricStats
+	index := map[string]int{}
+	for rows.Next() {
+		var st metricStats
+		if err := rows.Scan(&st.Host, &st.Count, &st.Avg, &st.Min, &st.Max); err != nil {
+			return nil, err
+		}
+		index[strings.ToLower(st.Host)] = len(list)
+		list = append(list, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The first and last values are those at each host's minimum and
+	// maximum time.
+	for _, edge := range []struct {
+		fn    string
+		value func(st *metricStats) *float64
+	}{
+		{"MIN", func(st *metricStats) *float64 { return &st.First }},
+		{"MAX", func(st *metricStats) *float64 { return &st.Last }},
+	} {
+		rows, err := s.db.Query(s.q(`SELECT all_samples.host, all_samples.avg FROM `+allSamples+` JOIN (SELECT host, `+edge.fn+`(time) AS time FROM `+allSamples+where+`) AS edge
+			ON all_samples.host = edge.host AND all_samples.time = edge.time WHERE all_samples.metric = ?`), append(args, metric)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var host string
+			var value float64
+			if err := rows.Scan(&host, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if i, ok := index[strings.ToLower(host)]; ok {
+				*edge.value(&list[i]) = value
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func (s *sqlStore) hourly(host, metric string, from, to time.Time, offset int) ([24]hourStats, error) {
+	var hours [24]hourStats
+	rows, err := s.db.Query(s.q(`SELECT ((time / 1000 + ?) / 3600) % 24 AS hour, COUNT(*), AVG(value), AVG(value * value)
+		FROM samples WHERE host = ? AND metric = ? AND time >= ? AND time < ? GROUP BY hour`),
+		offset, host, metric, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return hours, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hour, count int
+		var mean, meanSquare float64
+		if err := rows.Scan(&hour, &count, &mean, &meanSquare); err != nil {
+			return hours, err
+		}
+		if hour >= 0 && hour < 24 {
+			hours[hour] = newHourStats(count, mean, meanSquare)
+		}
+	}
+	return hours, rows.Err()
+}
+
+func (s *sqlStore) size() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM samples`).Scan(&n)
+	return n, err
+}
+
+func (s *sqlStore) scanResults(query string, args ...interface{}) ([]hostResult, error) {
+	rows, err := s.db.Query(s.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []hostResult
+	for rows.Next() {
+		var r hostResult
+		var ms int64
+		if err := rows.Scan(&r.Host, &r.Check, &r.OK, &r.Message, &ms); err != nil {
+			return nil, err
+		}
+		r.Time = time.UnixMilli(ms)
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlStore) latestResults(from, to time.Time) ([]hostResult, error) {
+	start, end := timeRange(from, to)
+	return s.scanResults(`SELECT results.host, results.name, ok, message, results.time FROM results
+		JOIN (SELECT host, name, MAX(time) AS time FROM results WHERE time >= ? AND time < ? GROUP BY host, name) AS latest
+		ON results.host = latest.host AND results.name = latest.name AND results.time = latest.time`, start, end)
+}
+
+func (s *sqlStore) results(from, to time.Time) ([]hostResult, error) {
+	start, end := timeRange(from, to)
+	return s.scanResults(`SELECT host, name, ok, message, time FROM results WHERE time >= ? AND time < ? ORDER BY time`, start, end)
+}
+
+func (s *sqlStore) latestValues(metrics []string) ([]hostSample, error) {
+	if len(metrics) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(metrics))
+	for i, m := range metrics {
+		args[i] = m
+	}
+	rows, err := s.db.Query(s.q(`SELECT samples.host, samples.metric, value, samples.time FROM samples
+		JOIN (SELECT host, metric, MAX(time) AS time FROM samples WHERE metric IN (?`+strings.Repeat(", ?", len(metrics)-1)+`) GROUP BY host, metric) AS latest
+		ON samples.host = latest.host AND samples.metric = latest.metric AND samples.time = latest.time`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []hostSample
+	for rows.Next() {
+		var v hostSample
+		var ms int64
+		if err := rows.Scan(&v.Host, &v.Metric, &v.Value, &ms); err != nil {
+			return nil, err
+		}
+		v.Time = time.UnixMilli(ms)
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// rollupLock is the PostgreSQL advisory lock that keeps monitors sharing a
+// database from downsampling the same samples at once.
+const rollupLock = 0x636b6865616c7468
+
+func (s *sqlStore) rollup(tiers []rollupTier, now time.Time) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if s.dialect == "postgres" {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, int64(rollupLock)); err != nil {
+			return 0, err
+		}
+	}
+	var rolled int64
+	for _, t := range tiers {
+		cutoff := t.cutoff(now).UnixMilli()
+		ms := t.bucket.Milliseconds()
+		columns, aggregate := `avg, min, max, count`, `SUM(avg * count) / SUM(count), MIN(min), MAX(max), SUM(count)`
+		if t.raw {
+			columns, aggregate = `value`, `AVG(value), MIN(value), MAX(value), COUNT(*)`
+		}
+		if _, err := tx.Exec(s.q(`INSERT INTO `+t.to+` (host, metric, time, avg, min, max, count)
+			SELECT host, metric, bucket * ?, `+aggregate+`
+			FROM (SELECT host, metric, `+columns+`, time / ? AS bucket FROM `+t.from+` WHERE time < ?) AS old
+			GROUP BY host, metric, bucket`), ms, ms, cutoff); err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(s.q(`DELETE FROM `+t.from+` WHERE time < ?`), cutoff)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		rolled += n
+	}
+	return rolled, tx.Commit()
+}
+
+func (s *sqlStore) prune(table string, before time.Time) (int64, error) {
+	column := "time"
+	switch table {
+	case "incidents":
+		column = "ended"
+	case "samples", "samples_5m", "samples_1h", "results", "alert_history", "host_events":
+	default:
+		return 0, fmt.Errorf("unknown table %q", table)
+	}
+	res, err := s.db.Exec(s.q(`DELETE FROM `+table+` WHERE `+column+` < ?`), before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) addAlertEvent(r alertRecord) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO alert_history (time, event, host, name, severity, message, "by", payload) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		r.Time.UnixMilli(), r.Event, r.Host, r.Check, r.Severity, r.Message, r.By, string(r.Payload))
+	return err
+}
+
+func (s *sqlStore) alertEvents(f alertHistoryFilter) ([]alertRecord, error) {
+	query := `SELECT time, event, host, name, severity, message, "by", payload FROM alert_history WHERE 1 = 1`
+	var args []interface{}
+	if f.host != "" {
+		query += ` AND host = ?`
+		args = append(args, f.host)
+	}
+	if f.check != "" {
+		query += ` AND name = ?`
+		args = append(args, f.check)
+	}
+	if !f.from.IsZero() {
+		query += ` AND time >= ?`
+		args = append(args, f.from.UnixMilli())
+	}
+	if !f.to.IsZero() {
+		query += ` AND time <= ?`
+		args = append(args, f.to.UnixMilli())
+	}
+	rows, err := s.db.Query(s.q(query+` ORDER BY time`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []alertRecord{}
+	for rows.Next() {
+		var r alertRecord
+		var ms int64
+		var payload string
+		if err := rows.Scan(&ms, &r.Event, &r.Host, &r.Check, &r.Severity, &r.Message, &r.By, &payload); err != nil {
+			return nil, err
+		}
+		r.Time = time.UnixMilli(ms)
+		r.Payload = json.RawMessage(payload)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func (s *sqlStore) addHostEvent(e hostEvent) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO host_events (time, host, kind, message) VALUES (?, ?, ?, ?)`),
+		e.Time.UnixMilli(), e.Host, e.Kind, e.Message)
+	return err
+}
+
+func (s *sqlStore) hostEvents(host string, from, to time.Time) ([]hostEvent, error) {
+	query := `SELECT time, host, kind, message FROM host_events WHERE host = ?`
+	args := []interface{}{host}
+	if !from.IsZero() {
+		query += ` AND time >= ?`
+		args = append(args, from.UnixMilli())
+	}
+	if !to.IsZero() {
+		query += ` AND time <= ?`
+		args = append(args, to.UnixMilli())
+	}
+	rows, err := s.db.Query(s.q(query+` ORDER BY time`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []hostEvent
+	for rows.Next() {
+		var e hostEvent
+		var ms int64
+		if err := rows.Scan(&ms, &e.Host, &e.Kind, &e.Message); err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(ms)
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlStore) addIncident(inc *incident) error {
+	return s.db.QueryRow(s.q(`INSERT INTO incidents (host, started, severity, checks) VALUES (?, ?, ?, ?) RETURNING id`),
+		inc.Host, inc.Start.UnixMilli(), inc.Severity, strings.Join(inc.Checks, ",")).Scan(&inc.ID)
+}
+
+func (s *sqlStore) updateIncident(inc incident) error {
+	var ended sql.NullInt64
+	if inc.End != nil {
+		ended = sql.NullInt64{Int64: inc.End.UnixMilli(), Valid: true}
+	}
+	_, err := s.db.Exec(s.q(`UPDATE incidents SET severity = ?, checks = ?, ended = ? WHERE id = ?`),
+		inc.Severity, strings.Join(inc.Checks, ","), ended, inc.ID)
+	return err
+}
+
+func (s *sqlStore) incidents(f incidentFilter) ([]incident, error) {
+	where := `WHERE 1 = 1`
+	var args []interface{}
+	if f.id != 0 {
+		where += ` AND id = ?`
+		args = append(args, f.id)
+	}
+	if f.open {
+		where += ` AND ended IS NULL`
+	}
+	if f.host != "" {
+		where += ` AND host = ?`
+		args = append(args, f.host)
+	}
+	if !f.from.IsZero() {
+		where += ` AND (ended IS NULL OR ended >= ?)`
+		args = append(args, f.from.UnixMilli())
+	}
+	if !f.to.IsZero() {
+		where += ` AND started <= ?`
+		args = append(args, f.to.UnixMilli())
+	}
+	rows, err := s.db.Query(s.q(`SELECT id, host, started, ended, severity, checks FROM incidents `+where+` ORDER BY started`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []incident{}
+	for rows.Next() {
+		var inc incident
+		var started int64
+		var ended sql.NullInt64
+		var checks string
+		if err := rows.Scan(&inc.ID, &inc.Host, &started, &ended, &inc.Severity, &checks); err != nil {
+			return nil, err
+		}
+		inc.Start = time.UnixMilli(started)
+		if ended.Valid {
+			end := time.UnixMilli(ended.Int64)
+			inc.End = &end
+		}
+		inc.Checks = strings.Split(checks, ",")
+		list = append(list, inc)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlStore) putState(key string, value []byte) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO state (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`), key, string(value))
+	return err
+}
+
+func (s *sqlStore) getState(key string) ([]byte, error) {
+	var value string
+	err := s.db.QueryRow(s.q(`SELECT value FROM state WHERE key = ?`), key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return []byte(value), err
+}
+
+func (s *sqlStore) listState(prefix string) (map[string][]byte, error) {
+	rows, err := s.db.Query(s.q(`SELECT key, value FROM state WHERE substr(key, 1, ?) = ?`), len(prefix), prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := map[string][]byte{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = []byte(value)
+	}
+	return values, rows.Err()
+}
+
+func (s *sqlStore) deleteState(key string) error {
+	_, err := s.db.Exec(s.q(`DELETE FROM state WHERE key = ?`), key)
+	return err
+}
+
+func (s *sqlStore) claimLease(name, holder string, now, until time.Time) (string, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+	var previous string
+	var expires int64
+	err = tx.QueryRow(s.q(`SELECT holder, expires FROM leases WHERE name = ?`), name).Scan(&previous, &expires)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", false, err
+	}
+	if previous != "" && previous != holder && expires > now.UnixMilli() {
+		return previous, false, nil
+	}
+	// The condition is checked again, so of two monitors claiming an
+	// expired lease at once only one gets it.
+	res, err := tx.Exec(s.q(`INSERT INTO leases (name, holder, expires) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires = excluded.expires
+		WHERE leases.holder = excluded.holder OR leases.expires <= ?`),
+		name, holder, until.UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return "", false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", false, err
+	}
+	return previous, n > 0, tx.Commit()
+}
+
+func (s *sqlStore) releaseLease(name, holder string) error {
+	_, err := s.db.Exec(s.q(`UPDATE leases SET expires = 0 WHERE name = ? AND holder = ?`), name, holder)
+	return err
+}
+
+// backup copies a SQLite database with VACUUM INTO, which is consistent even
+// while the monitor is writing to it. PostgreSQL has its own tools.
+func (s *sqlStore) backup(path string) error {
+	if s.dialect == "postgres" {
+		return fmt.Errorf("the history is in PostgreSQL, back it up with pg_dump")
+	}
+	_, err := s.db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+func (s *sqlStore) close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// maxSSHSessions is how many check commands may run at once across all
+// hosts: ssh.maxSessions, default 20. Zero or less means no limit.
+func maxSSHSessions() int {
+	return conf().GetInt("ssh.maxSessions")
+}
+
+// sessionLimiter caps the check commands running at once, so hundreds of
+// hosts don't all open an ssh connection in the same second. The limit is
+// read on every acquire, so config reloads apply right away.
+type sessionLimiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	active  int
+	waiting int
+}
+
+var sshSessions = newSessionLimiter()
+
+func newSessionLimiter() *sessionLimiter {
+	l := &sessionLimiter{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits for a free session, or returns ctx's error if it is done
+// first.
+func (l *sessionLimiter) acquire(ctx context.Context) error {
+	// Wake the waiters when ctx is done, so they can give up.
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waiting++
+	sshSessionsGauge.WithLabelValues("waiting").Set(float64(l.waiting))
+	for max := maxSSHSessions(); max > 0 && l.active >= max && ctx.Err() == nil; max = maxSSHSessions() {
+		l.cond.Wait()
+	}
+	l.waiting--
+	sshSessionsGauge.WithLabelValues("waiting").Set(float64(l.waiting))
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.active++
+	sshSessionsGauge.WithLabelValues("active").Set(float64(l.active))
+	return nil
+}
+
+func (l *sessionLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	sshSessionsGauge.WithLabelValues("active").Set(float64(l.active))
+	l.cond.Broadcast()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// A check result is stale once it is more than twice the interval of the
+// loop that produces it old, e.g. while a loop is stuck or the health check
+// of a host fails before sampling its values. Stale results are marked in
+// the API and on the dashboard, and raise a "stale" warning of the host, so
+// no data isn't taken for healthy. Results of webhook alerts and of checks
+// that ride along with others, such as trends, aren't tracked.
+
+// staleCheck is the check of the alert about a host's stale results.
+const staleCheck = "stale"
+
+// healthResults are the results recorded by the health check.
+var healthResults = []string{"ssh", "parse", "cpu", "memory", "disk"}
+
+// resultLoop returns the name of the loop that records a host's results of
+// check, if it is tracked and enabled.
+func resultLoop(h Host, check string) (string, bool) {
+	if contains(healthResults, check) {
+		return "health/" + h.Name, checkEnabled(h, "health")
+	}
+	for _, c := range customChecks() {
+		if !strings.EqualFold(c.Name, check) || !c.appliesTo(h) {
+			continue
+		}
+		if c.Schedule != "" {
+			return c.Name + "/" + h.Name, true
+		}
+		return "custom/" + h.Name, true
+	}
+	return "", false
+}
+
+// staleAfter is how old a host's result of check may get, or 0 if it isn't
+// tracked.
+func staleAfter(h Host, check string, now time.Time) time.Duration {
+	name, ok := resultLoop(h, check)
+	if !ok {
+		return 0
+	}
+	interval, ok := loops.interval(name, now)
+	if !ok {
+		return 0
+	}
+	return 2 * interval
+}
+
+// markStale sets Stale on the results of a host that are too old. Results
+// count as no older than from, so those restored at startup get the time to
+// be refreshed.
+func markStale(h Host, list []checkResult, from, now time.Time) {
+	for i := range list {
+		limit := staleAfter(h, list[i].Check, now)
+		t := list[i].Time
+		if t.Before(from) {
+			t = from
+		}
+		list[i].Stale = limit > 0 && now.Sub(t) > limit
+	}
+}
+
+// checkStaleness raises the "stale" warning of every host with stale
+// results and resolves it once they are fresh again. A standby monitor
+// runs no checks, so it doesn't look.
+func checkStaleness(now time.Time) {
+	if !isLeader() {
+		return
+	}
+	for _, h := range configuredHosts() {
+		list := results.list(h.Name)
+		markStale(h, list, startedAt, now)
+		var stale []string
+		oldest := now
+		for _, r := range list {
+			if r.Stale {
+				stale = append(stale, r.Check)
+				if r.Time.Before(oldest) {
+					oldest = r.Time
+				}
+			}
+		}
+		if len(stale) == 0 || !checkEnabled(h, staleCheck) {
+			if _, active := alerts.get(h.Name + "/" + staleCheck); active {
+				clearAlert(h.Name, staleCheck)
+			}
+			continue
+		}
+		sort.Strings(stale)
+		raiseAlert(Alert{Host: h.Name, Address: h.Address, Check: staleCheck, Severity: SeverityWarning, Time: now,
+			Message: fmt.Sprintf("No new result of %s since %s", strings.Join(stale, ", "), oldest.In(displayLocation()).Format("Jan 2 15:04"))})
+	}
+}
+
+// runStalenessCheck looks for stale results every minute until ctx is done.
+func runStalenessCheck(ctx context.Context) {
+	runScheduled(ctx, cron.Every(time.Minute), false, func() { checkStaleness(time.Now()) })
+}
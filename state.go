@@ -0,0 +1,427 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The active alerts, with whether they were acknowledged, silenced or
+// escalated, are saved to the history store every 30 seconds and on
+// shutdown under the name of the monitor, history.instance (default the
+// hostname), and restored at startup, along with the time of each agent's
+// latest report. Silences added through the API are saved as they are added
+// and removed. Monitors sharing a PostgreSQL store pick up each other's
+// silences, and the acknowledgements and silences of alerts they have in
+// common, within 30 seconds.
+//
+// putState saves v as JSON under key.
+func putState(store historyStore, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.putState(key, data)
+}
+
+// getState decodes the value saved under key into v; a missing key leaves
+// v as it is.
+func getState(store historyStore, key string, v interface{}) error {
+	data, err := store.getState(key)
+	if err != nil || data == nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func historyInstance() string {
+	if name := conf().GetString("history.instance"); name != "" {
+		return name
+	}
+	if name, err := os.Hostname(); err == nil {
+		return name
+	}
+	return "checkhealth"
+}
+
+// saveState saves the active alerts of this monitor, and the time of the
+// latest report of each agent, so a captured report can't be replayed
+// after a restart.
+func saveState() error {
+	store := series.storage()
+	if store == nil {
+		return nil
+	}
+	if err := putState(store, "alerts/"+historyInstance(), alerts.list()); err != nil {
+		return err
+	}
+	return putState(store, "agents/"+historyInstance(), agents.lastReports())
+}
+
+// storeSilence saves a silence added through the API.
+func storeSilence(sil Silence) {
+	if store := series.storage(); store != nil {
+		if err := putState(store, "silence/"+sil.ID, sil); err != nil {
+			slog.Error("Error saving silence", "silence", sil.ID, "err", err)
+		}
+	}
+}
+
+// unstoreSilence deletes a silence removed through the API.
+func unstoreSilence(id string) {
+	if store := series.storage(); store != nil {
+		if err := store.deleteState("silence/" + id); err != nil {
+			slog.Error("Error deleting silence", "silence", id, "err", err)
+		}
+	}
+}
+
+// syncSilences makes the silences not from the config file those saved in
+// the store, deleting the ended ones. Silences that ended are left for
+// expire, which reports the alerts they covered.
+func syncSilences(store historyStore, now time.Time) (int, error) {
+	saved, err := store.listState("silence/")
+	if err != nil {
+		return 0, err
+	}
+	ids := map[string]bool{}
+	for key, data := range saved {
+		var sil Silence
+		if err := json.Unmarshal(data, &sil); err != nil {
+			return 0, fmt.Errorf("%s: %w", key, err)
+		}
+		if !now.Before(sil.End) {
+			store.deleteState(key)
+			continue
+		}
+		ids[sil.ID] = true
+		silences.add(sil)
+	}
+	for _, sil := range silences.list() {
+		if !sil.configured && !ids[sil.ID] && now.Before(sil.End) {
+			silences.remove(sil.ID)
+		}
+	}
+	return len(ids), nil
+}
+
+// syncAcks applies the acknowledgements and silences of alerts made on
+// other monitors sharing the store to the same alerts here.
+func syncAcks(store historyStore, now time.Time) error {
+	saved, err := store.listState("alerts/")
+	if err != nil {
+		return err
+	}
+	own := "alerts/" + historyInstance()
+	for key, data := range saved {
+		if key == own {
+			continue
+		}
+		var active []activeAlert
+		if err := json.Unmarshal(data, &active); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		for _, other := range active {
+			aa, ok := alerts.get(other.Key())
+			if !ok {
+				continue
+			}
+			if other.Acked && !aa.Acked {
+				alerts.ack(aa.Key())
+				slog.Debug("Alert was acknowledged by another monitor", "alert", aa.Key(), "instance", strings.TrimPrefix(key, "alerts/"))
+			}
+			if other.SilencedUntil.After(now) && other.SilencedUntil.After(aa.SilencedUntil) {
+				alerts.silence(aa.Key(), other.SilencedUntil)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreAgentReports picks up the time of the latest report of each agent
+// saved by any monitor sharing the store, as another may have been the
+// leader the agents reported to.
+func restoreAgentReports(store historyStore) error {
+	saved, err := store.listState("agents/")
+	if err != nil {
+		return err
+	}
+	for key, data := range saved {
+		var reports map[string]time.Time
+		if err := json.Unmarshal(data, &reports); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		for host, t := range reports {
+			agents.restoreLastReport(host, t)
+		}
+	}
+	return nil
+}
+
+// restoreState picks up the alerts saved by this monitor's last run and the
+// saved silences. Alerts that resolved while the monitor was stopped are
+// resolved by the first check of their host.
+func restoreState() error {
+	store := series.storage()
+	if store == nil {
+		return nil
+	}
+	var active []activeAlert
+	if err := getState(store, "alerts/"+historyInstance(), &active); err != nil {
+		return fmt.Errorf("alerts: %w", err)
+	}
+	for _, aa := range active {
+		alerts.restore(aa)
+	}
+	if err := restoreAgentReports(store); err != nil {
+		return fmt.Errorf("agent reports: %w", err)
+	}
+	n, err := syncSilences(store, time.Now())
+	if err != nil {
+		return fmt.Errorf("silences: %w", err)
+	}
+	if len(active) > 0 || n > 0 {
+		slog.Info("Restored active alerts and silences", "alerts", len(active), "silences", n)
+	}
+	return nil
+}
+
+// runStateSync saves the active alerts and picks up the silences and
+// acknowledgements of other monitors every 30 seconds.
+func runStateSync(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		store := series.storage()
+		if store == nil {
+			continue
+		}
+		now := time.Now()
+		if err := saveState(); err != nil {
+			slog.Error("Error saving alerts", "err", err)
+		}
+		if _, err := syncSilences(store, now); err != nil {
+			slog.Error("Error loading silences", "err", err)
+		}
+		if err := syncAcks(store, now); err != nil {
+			slog.Error("Error loading acknowledgements", "err", err)
+		}
+	}
+}
+
+// stateFile is a file of the persisted state, by its name in a state
+// archive.
+type stateFile struct {
+	name, path string
+}
+
+// stateFiles are the files that make up the persisted state, at their
+// configured paths.
+func stateFiles() []stateFile {
+	return []stateFile{
+		{"history.db", historyPath()},
+		{"audit.log", auditPath()},
+		{"outbox.json", queueFilePath()},
+		{"deadletter.log", deadLetterFilePath()},
+	}
+}
+
+// stateManifest is the first entry of a state archive.
+type stateManifest struct {
+	Format  int       `json:"format"`
+	Version string    `json:"version"` // of the checkhealth that wrote it
+	Backend string    `json:"backend"` // history.backend of history.db
+	Created time.Time `json:"created"`
+	Files   []string  `json:"files"`
+}
+
+const stateFormat = 1
+
+// exportState writes the history store, with the active alerts and
+// silences, and the audit log and delivery queue to a gzipped tar archive.
+// A SQLite database is copied consistently even while the monitor is
+// running; a bolt file is locked by the running monitor. A PostgreSQL
+// database is left to pg_dump.
+func exportState(path string) error {
+	tmp, err := os.MkdirTemp("", "checkhealth-state")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	snapshot := filepath.Join(tmp, "history.db")
+	shared := historyBackend() == "postgres"
+	if shared {
+		slog.Warn("The history is in PostgreSQL and not exported, back it up with pg_dump")
+	} else {
+		if err := openHistory(); err != nil {
+			return err
+		}
+		defer closeHistory()
+		if err := series.storage().backup(snapshot); err != nil {
+			return fmt.Errorf("copying %s: %w", historyPath(), err)
+		}
+	}
+
+	manifest := stateManifest{Format: stateFormat, Version: version, Backend: historyBackend(), Created: time.Now()}
+	var files []stateFile
+	for _, f := range stateFiles() {
+		if f.name == "history.db" {
+			if shared {
+				continue
+			}
+			f.path = snapshot
+		} else if _, err := os.Stat(f.path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		files = append(files, f)
+		manifest.Files = append(manifest.Files, f.name)
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0o600, Size: int64(len(data)), ModTime: manifest.Created}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := addToArchive(tw, f); err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func addToArchive(tw *tar.Writer, f stateFile) error {
+	in, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o600, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, in)
+	return err
+}
+
+// importState restores the files of a state archive to their configured
+// paths. Existing files are only replaced with force. The monitor must not
+// be running, or it would go on with, and later save, its own state.
+func importState(path string, force bool) ([]string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "manifest.json" {
+		return nil, fmt.Errorf("%s is not a checkhealth state archive", path)
+	}
+	var manifest stateManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading the manifest: %w", err)
+	}
+	if manifest.Format != stateFormat {
+		return nil, fmt.Errorf("%s has state format %d, this version reads %d", path, manifest.Format, stateFormat)
+	}
+	if contains(manifest.Files, "history.db") && manifest.Backend != historyBackend() {
+		return nil, fmt.Errorf("%s has a %s history, set history.backend to %s to import it", path, manifest.Backend, manifest.Backend)
+	}
+
+	targets := map[string]string{}
+	for _, f := range stateFiles() {
+		targets[f.name] = f.path
+	}
+	for _, name := range manifest.Files {
+		target, ok := targets[name]
+		if !ok {
+			return nil, fmt.Errorf("%s has an unknown file %q", path, name)
+		}
+		if _, err := os.Stat(target); err == nil && !force {
+			return nil, fmt.Errorf("%s already exists, use --force to replace it", target)
+		}
+	}
+
+	var restored []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, err
+		}
+		target, ok := targets[hdr.Name]
+		if !ok {
+			return restored, fmt.Errorf("%s has an unknown file %q", path, hdr.Name)
+		}
+		if err := extractFile(tr, target); err != nil {
+			return restored, fmt.Errorf("%s: %w", target, err)
+		}
+		if hdr.Name == "history.db" {
+			// A write-ahead log left by the replaced database would be
+			// applied to the imported one.
+			os.Remove(target + "-wal")
+			os.Remove(target + "-shm")
+		}
+		restored = append(restored, target)
+	}
+	return restored, nil
+}
+
+// extractFile writes r to path through a temporary file, so an interrupted
+// import doesn't leave a truncated file.
+func extractFile(r io.Reader, path string) error {
+	tmp := path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
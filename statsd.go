@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// statsdExporter sends every sample as gauges, and every check result as a
+// 0/1 gauge, to a StatsD server over UDP. In DogStatsD mode the host and
+// group are tags and check results are service checks; plain StatsD has no
+// tags, so they become part of the metric name instead.
+type statsdExporter struct {
+	conn   net.Conn
+	prefix string
+	dog    bool
+	tags   []string
+}
+
+func newStatsdExporter(cfg *viper.Viper) (sampleExporter, error) {
+	addr := cfg.GetString("address")
+	if addr == "" {
+		return nil, errors.New("address is required")
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	prefix := cfg.GetString("prefix")
+	if !cfg.IsSet("prefix") {
+		prefix = "checkhealth"
+	}
+	return &statsdExporter{
+		conn:   conn,
+		prefix: prefix,
+		dog:    cfg.GetBool("dogstatsd"),
+		tags:   cfg.GetStringSlice("tags"),
+	}, nil
+}
+
+var statsdUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// name joins the prefix and parts into a metric name, replacing characters
+// StatsD doesn't allow.
+func (e *statsdExporter) name(parts ...string) string {
+	if e.prefix != "" {
+		parts = append([]string{e.prefix}, parts...)
+	}
+	for i, p := range parts {
+		parts[i] = statsdUnsafe.ReplaceAllString(p, "_")
+	}
+	return strings.Join(parts, ".")
+}
+
+func (e *statsdExporter) tagSuffix(h Host) string {
+	tags := append([]string{"host:" + h.Name}, e.tags...)
+	if h.Group != "" {
+		tags = append(tags, "group:"+h.Group)
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+func (e *statsdExporter) send(lines []string) {
+	// Keep datagrams well below common MTUs.
+	var packet string
+	for _, line := range lines {
+		if packet != "" && len(packet)+1+len(line) > 1400 {
+			e.write(packet)
+			packet = ""
+		}
+		if packet != "" {
+			packet += "\n"
+		}
+		packet += line
+	}
+	if packet != "" {
+		e.write(packet)
+	}
+}
+
+func (e *statsdExporter) write(packet string) {
+	if _, err := e.conn.Write([]byte(packet)); err != nil {
+		slog.Error("Sending to statsd failed", "err", err)
+	}
+}
+
+func (e *statsdExporter) Export(s metricSample) {
+	names := make([]string, 0, len(s.Values))
+	for name := range s.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		value := fmt.Sprintf("%g|g", s.Values[name])
+		if e.dog {
+			lines = append(lines, e.name(name)+":"+value+e.tagSuffix(s.Host))
+		} else {
+			lines = append(lines, e.name(s.Host.Name, name)+":"+value)
+		}
+	}
+	e.send(lines)
+}
+
+func (e *statsdExporter) ExportResult(h Host, check string, ok bool, message string, t time.Time) {
+	up := 0
+	if ok {
+		up = 1
+	}
+	if !e.dog {
+		e.send([]string{fmt.Sprintf("%s:%d|g", e.name(h.Name, "check", check), up)})
+		return
+	}
+	status := 0
+	if !ok {
+		status = 2
+	}
+	sc := fmt.Sprintf("_sc|%s|%d|d:%d|h:%s%s", e.name("check", check), status, t.Unix(), h.Name, e.tagSuffix(h))
+	if message != "" {
+		// Service check messages may not contain newlines.
+		sc += "|m:" + strings.ReplaceAll(message, "\n", " ")
+	}
+	e.send([]string{
+		fmt.Sprintf("%s:%d|g%s", e.name("check", check), up, e.tagSuffix(h)+",check:"+check),
+		sc,
+	})
+}
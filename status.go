@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// publicHost is a host as shown on the public status page: a public name
+// and its state, nothing that identifies the machine.
+type publicHost struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type publicStatus struct {
+	Title   string       `json:"title"`
+	State   string       `json:"state"`
+	Hosts   []publicHost `json:"hosts"`
+	Updated time.Time    `json:"updated"`
+}
+
+// publicName is how a host appears on the status page: its entry in
+// statusPage.names, else "Node" and its position in the host list.
+func publicName(h Host, i int) string {
+	for name, public := range conf().GetStringMapString("statusPage.names") {
+		if strings.EqualFold(name, h.Name) {
+			return public
+		}
+	}
+	return fmt.Sprintf("Node %d", i+1)
+}
+
+// currentPublicStatus summarizes every host for the status page. The
+// overall state is the worst of the hosts'.
+func currentPublicStatus() publicStatus {
+	title := conf().GetString("statusPage.title")
+	if title == "" {
+		title = "Status"
+	}
+	s := publicStatus{Title: title, State: "ok", Updated: time.Now()}
+	active := alerts.list()
+	rank := map[string]int{"ok": 0, "degraded": 1, "down": 2}
+	for i, h := range configuredHosts() {
+		state := hostState(h, active)
+		s.Hosts = append(s.Hosts, publicHost{Name: publicName(h, i), State: state})
+		if rank[state] > rank[s.State] {
+			s.State = state
+		}
+	}
+	return s
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="60"><title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 40em; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: 6px 12px; border-bottom: 1px solid #ddd; }
+.ok { color: #2a7; } .degraded { color: #c80; } .down { color: #c22; }
+</style></head><body>
+<h1>{{.Title}} <span class="{{.State}}">{{.State}}</span></h1>
+<table>{{range .Hosts}}<tr><td>{{.Name}}</td><td class="{{.State}}">{{.State}}</td></tr>
+{{end}}</table>
+<p><small>Updated {{.Updated.Format "2006-01-02 15:04:05 MST"}}</small></p>
+</body></html>
+`))
+
+// statusPageHandler serves the public status page, without authentication
+// when statusPage.enabled is set.
+func statusPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, currentPublicStatus()); err != nil {
+		slog.Error("Error rendering status page", "err", err)
+	}
+}
+
+func statusJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(currentPublicStatus())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// historyStore keeps the history: metric samples and their rollups, check
+// results, the alert history, incidents, the hosts' timeline events and the
+// saved alerts and silences.
+// history.backend selects the implementation: sqlite (the default), bolt,
+// a single bbolt file that needs no cgo, so the monitor can be built with
+// CGO_ENABLED=0, or postgres, which several monitors can share. Host names
+// match case-insensitively; zero times are unbounded.
+type historyStore interface {
+	addSample(host, metric string, value float64, t time.Time) error
+	addResult(host string, r checkResult) error
+	// points returns a host's values of a metric since from, the rollups
+	// as their averages.
+	points(host, metric string, from time.Time) ([]point, error)
+	metrics(host string) ([]string, error)
+	stats(metric string, from, to time.Time) ([]metricStats, error)
+	// hourly computes the statistics of a host's samples of a metric per
+	// hour of the day, at offset seconds east of UTC.
+	hourly(host, metric string, from, to time.Time, offset int) ([24]hourStats, error)
+	// size is the number of samples not yet rolled up.
+	size() (int, error)
+	// latestResults returns the latest result of each host and check
+	// between from and to.
+	latestResults(from, to time.Time) ([]hostResult, error)
+	// results returns the results between from and to by time.
+	results(from, to time.Time) ([]hostResult, error)
+	// latestValues returns each host's latest sample of the metrics.
+	latestValues(metrics []string) ([]hostSample, error)
+	rollup(tiers []rollupTier, now time.Time) (int64, error)
+	// prune deletes what is older than before from a table: samples,
+	// samples_5m, samples_1h, results, alert_history, host_events or
+	// incidents, which are pruned by when they ended.
+	prune(table string, before time.Time) (int64, error)
+
+	addAlertEvent(r alertRecord) error
+	alertEvents(f alertHistoryFilter) ([]alertRecord, error)
+	// addIncident stores a new incident and sets its ID.
+	addIncident(inc *incident) error
+	// updateIncident stores the severity, checks and end of an incident.
+	updateIncident(inc incident) error
+	incidents(f incidentFilter) ([]incident, error)
+
+	addHostEvent(e hostEvent) error
+	// hostEvents returns a host's events between from and to by time.
+	hostEvents(host string, from, to time.Time) ([]hostEvent, error)
+
+	putState(key string, value []byte) error
+	// getState returns nil for a key that was never saved.
+	getState(key string) ([]byte, error)
+	// listState returns the saved values whose keys start with prefix.
+	listState(prefix string) (map[string][]byte, error)
+	deleteState(key string) error
+	// claimLease makes holder the holder of the named lease until until,
+	// unless another holder has it past now. It returns the holder before
+	// the claim, the one that keeps it if the claim fails.
+	claimLease(name, holder string, now, until time.Time) (previous string, held bool, err error)
+	// releaseLease makes the named lease expire if holder has it. The
+	// holder is kept, so the next one knows whom it took over from.
+	releaseLease(name, holder string) error
+
+	// backup writes a consistent copy of the store to path.
+	backup(path string) error
+	close() error
+}
+
+// hostResult is a stored check result.
+type hostResult struct {
+	Host string
+	checkResult
+}
+
+// hostSample is a stored metric value.
+type hostSample struct {
+	Host, Metric string
+	point
+}
+
+// storageBackends are the values of history.backend.
+var storageBackends = []string{"sqlite", "bolt", "postgres"}
+
+func historyBackend() string {
+	return historyBackendIn(conf())
+}
+
+func historyBackendIn(cfg *viper.Viper) string {
+	if b := cfg.GetString("history.backend"); b != "" {
+		return strings.ToLower(b)
+	}
+	return "sqlite"
+}
+
+func openStore(backend, path string) (historyStore, error) {
+	switch backend {
+	case "sqlite":
+		return openSQLite(path)
+	case "bolt":
+		return openBolt(path)
+	case "postgres":
+		return openPostgres(path)
+	}
+	return nil, fmt.Errorf("unknown history backend %q", backend)
+}
+
+// newHourStats computes the statistics of an hour from the count, mean and
+// mean of the squares of its values.
+func newHourStats(count int, mean, meanSquare float64) hourStats {
+	return hourStats{Count: count, Mean: mean, StdDev: math.Sqrt(math.Max(0, meanSquare-mean*mean))}
+}
+
+// hourOfDay is the hour of the day of a Unix millisecond time at offset
+// seconds east of UTC.
+func hourOfDay(ms int64, offset int) int {
+	return int((ms/1000+int64(offset))/3600) % 24
+}
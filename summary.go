@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// fleetSummary is the scheduled status report: how many hosts are monitored,
+// the degraded hosts per group and the alerts that are currently active.
+func fleetSummary() string {
+	return fmt.Sprintf("Fleet summary %s: %s", time.Now().In(displayLocation()).Format(timestampLayout), fleetOverview())
+}
+
+// fleetOverview is the healthy hosts, the degraded hosts per group and the
+// active alerts.
+func fleetOverview() string {
+	active := alerts.list()
+	degraded := map[string]bool{}
+	for _, aa := range active {
+		degraded[strings.ToLower(aa.Host)] = true
+	}
+	hosts := configuredHosts()
+	healthy := 0
+	for _, h := range hosts {
+		if !degraded[strings.ToLower(h.Name)] {
+			healthy++
+		}
+	}
+
+	var b strings.Builder
+	loc := displayLocation()
+	fmt.Fprintf(&b, "%d/%d hosts healthy", healthy, len(hosts))
+	for _, g := range groupStatuses() {
+		fmt.Fprintf(&b, "\n%s", g)
+	}
+	if len(active) == 0 {
+		b.WriteString("\nNo active alerts.")
+	} else {
+		fmt.Fprintf(&b, "\n\nActive alerts (%d):", len(active))
+		for _, aa := range active {
+			fmt.Fprintf(&b, "\n%s (since %s)", aa.Alert, aa.Since.In(loc).Format("Jan 2 15:04"))
+		}
+	}
+	return b.String()
+}
+
+// runSummary sends the fleet summary to the main chat on summary.schedule,
+// e.g. "0 9 * * *" for every morning.
+func runSummary(ctx context.Context) {
+	spec := conf().GetString("summary.schedule")
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		slog.Error("Invalid summary.schedule", "err", err)
+		return
+	}
+	slog.Info("Sending fleet summaries", "schedule", spec)
+	runScheduled(ctx, sched, false, func() {
+		sendTelegramMessage(fleetSummary())
+	})
+}
+
+// summaryReport is an entry of summary.reports: a message sent on schedule
+// to chatID (default telegramChatID) that covers the last period with the
+// chosen sections.
+type summaryReport struct {
+	Name     string
+	Schedule string
+	Period   string // e.g. 24h or 7d
+	ChatID   int64
+	ThreadID int
+	Sections []string // fleet, top, alerts, disk and checks; all by default
+	Top      int      // hosts listed per metric in top, default 3
+	Checks   []string // numeric custom checks for the checks section, e.g. missed blocks
+}
+
+// summarySections are the sections a report can have, in their order.
+var summarySections = []string{"fleet", "top", "alerts", "disk", "checks"}
+
+func summaryReports() []summaryReport {
+	return summaryReportsIn(conf())
+}
+
+func summaryReportsIn(cfg *viper.Viper) []summaryReport {
+	var reports []summaryReport
+	if err := cfg.UnmarshalKey("summary.reports", &reports); err != nil {
+		slog.Error("Error reading summary.reports", "err", err)
+	}
+	for i := range reports {
+		if reports[i].Period == "" {
+			reports[i].Period = "24h"
+		}
+		if len(reports[i].Sections) == 0 {
+			reports[i].Sections = summarySections
+		}
+		if reports[i].Top <= 0 {
+			reports[i].Top = 3
+		}
+		if reports[i].ChatID == 0 {
+			reports[i].ChatID = cfg.GetInt64("telegramChatID")
+		}
+	}
+	return reports
+}
+
+func (r summaryReport) has(section string) bool {
+	return contains(r.Sections, section)
+}
+
+// text builds the report for the period that ends at now.
+func (r summaryReport) text(now time.Time) string {
+	period, _ := parseLookback(r.Period)
+	from := now.Add(-period)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s report %s (last %s)", r.Name, now.In(displayLocation()).Format(timestampLayout), r.Period)
+	section := func(lines ...string) {
+		b.WriteString("\n")
+		for _, line := range lines {
+			b.WriteString("\n" + line)
+		}
+	}
+	for _, name := range summarySections {
+		if !r.has(name) {
+			continue
+		}
+		var lines []string
+		var err error
+		switch name {
+		case "fleet":
+			lines = []string{"Fleet: " + fleetOverview()}
+		case "top":
+			lines, err = topConsumers(from, now, r.Top)
+		case "alerts":
+			lines, err = alertCountLines(from, now, r.Top)
+		case "disk":
+			lines, err = diskGrowth(from, now)
+		case "checks":
+			lines, err = checkValueLines(r.Checks, from, now)
+		}
+		if err != nil {
+			slog.Error("Error building report section", "report", r.Name, "section", name, "err", err)
+			lines = []string{fmt.Sprintf("(%s unavailable)", name)}
+		}
+		if len(lines) > 0 {
+			section(lines...)
+		}
+	}
+	return b.String()
+}
+
+// topConsumers lists the hosts with the highest average CPU, memory and
+// disk usage.
+func topConsumers(from, to time.Time, n int) ([]string, error) {
+	var lines []string
+	for _, metric := range []string{"cpu", "memory", "disk"} {
+		stats, err := series.stats(metric, from, to)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(stats, func(i, j int) bool { return stats[i].Avg > stats[j].Avg })
+		var parts []string
+		for i, s := range stats {
+			if i == n {
+				break
+			}
+			parts = append(parts, fmt.Sprintf("%s %.1f%% (max %.1f%%)", s.Host, s.Avg, s.Max))
+		}
+		if len(parts) > 0 {
+			lines = append(lines, fmt.Sprintf("Top %s: %s", metricNames[metric], strings.Join(parts, ", ")))
+		}
+	}
+	return lines, nil
+}
+
+// alertCountLines counts the alerts fired, resolved and acknowledged, and
+// names the checks that fired most.
+func alertCountLines(from, to time.Time, n int) ([]string, error) {
+	records, err := queryAlertHistory(alertHistoryFilter{from: from, to: to})
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	fired := map[string]int{}
+	for _, rec := range records {
+		counts[rec.Event]++
+		if rec.Event == "fired" {
+			counts[rec.Severity]++
+			fired[rec.Host+"/"+rec.Check]++
+		}
+	}
+	line := fmt.Sprintf("Alerts: %d fired", counts["fired"])
+	if counts["fired"] > 0 {
+		line += fmt.Sprintf(" (%d critical, %d warning)", counts["critical"], counts["warning"])
+	}
+	line += fmt.Sprintf(", %d resolved, %d acknowledged", counts["resolved"], counts["acked"])
+	lines := []string{line}
+
+	keys := make([]string, 0, len(fired))
+	for key := range fired {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if fired[keys[i]] != fired[keys[j]] {
+			return fired[keys[i]] > fired[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	var parts []string
+	for i, key := range keys {
+		if i == n {
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", key, fired[key]))
+	}
+	if len(parts) > 0 {
+		lines = append(lines, "Most frequent: "+strings.Join(parts, ", "))
+	}
+	return lines, nil
+}
+
+// diskGrowth lists how much each host's disk usage changed, fastest growing
+// first.
+func diskGrowth(from, to time.Time) ([]string, error) {
+	stats, err := series.stats("disk", from, to)
+	if err != nil || len(stats) == 0 {
+		return nil, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Last-stats[i].First > stats[j].Last-stats[j].First })
+	parts := make([]string, 0, len(stats))
+	for _, s := range stats {
+		parts = append(parts, fmt.Sprintf("%s %+.1f%% (%.1f%% now)", s.Host, s.Last-s.First, s.Last))
+	}
+	return []string{"Disk growth: " + strings.Join(parts, ", ")}, nil
+}
+
+// checkValueLines shows the latest value of numeric custom checks, such as
+// a validator's missed blocks, with the change over the period.
+func checkValueLines(checks []string, from, to time.Time) ([]string, error) {
+	var lines []string
+	for _, check := range checks {
+		stats, err := series.stats(check, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(stats) == 0 {
+			lines = append(lines, check+": no values")
+			continue
+		}
+		sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+		parts := make([]string, 0, len(stats))
+		for _, s := range stats {
+			parts = append(parts, fmt.Sprintf("%s %s (%+g, min %s, max %s)", s.Host, formatValue(s.Last), s.Last-s.First, formatValue(s.Min), formatValue(s.Max)))
+		}
+		lines = append(lines, check+": "+strings.Join(parts, ", "))
+	}
+	return lines, nil
+}
+
+// runSummaryReports sends each of summary.reports on its schedule.
+func runSummaryReports(ctx context.Context) {
+	for _, r := range summaryReports() {
+		sched, err := parseSchedule(r.Schedule)
+		if err != nil {
+			slog.Error("Invalid summary report schedule", "report", r.Name, "err", err)
+			continue
+		}
+		slog.Info("Sending report", "report", r.Name, "schedule", r.Schedule)
+		go runScheduled(ctx, sched, false, func() {
+			queue.enqueue(outboundMessage{ChatID: r.ChatID, ThreadID: r.ThreadID, Text: r.text(time.Now())})
+		})
+	}
+}
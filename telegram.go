@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/spf13/cast"
+)
+
+func sendTelegramMessage(message string) {
+	sendTelegramHostMessage("", message)
+}
+
+// sendTelegramHostMessage sends to the main chat, into the host's forum topic
+// when one is configured.
+func sendTelegramHostMessage(host, message string) {
+	queue.enqueue(outboundMessage{
+		ChatID:   conf().GetInt64("telegramChatID"),
+		ThreadID: telegramTopic(host, conf().GetInt("telegramThreadID")),
+		Text:     message,
+	})
+}
+
+// sendTelegramAlert sends an alert into the host's topic of the chat (or
+// threadID when the host has none), with its time shown in loc. Critical
+// alerts get inline buttons that let the on-call acknowledge or temporarily
+// silence them from the chat.
+func sendTelegramAlert(chatID int64, threadID int, loc *time.Location, a Alert) {
+	queue.enqueue(outboundMessage{
+		ChatID:   chatID,
+		ThreadID: telegramTopic(a.Host, threadID),
+		Text:     a.Text(loc),
+		AlertKey: a.Key(),
+		Resolved: a.Resolved,
+		Buttons:  a.Severity >= SeverityCritical && !a.Resolved,
+	})
+}
+
+// telegramTopic looks up the forum topic (message_thread_id) configured for
+// a host under telegramTopics.
+func telegramTopic(host string, fallback int) int {
+	for name, id := range conf().GetStringMap("telegramTopics") {
+		if strings.EqualFold(name, host) {
+			if topic, err := cast.ToIntE(id); err == nil {
+				return topic
+			}
+		}
+	}
+	return fallback
+}
+
+// telegramClient is the bot client shared by every send and the update
+// poller. Making one calls getMe, so it is made once and only made again
+// when telegramBotToken changes; while Telegram is unreachable, it is
+// retried at the next use.
+var telegramClient = struct {
+	sync.Mutex
+	bot   *tgbotapi.BotAPI
+	token string
+}{}
+
+// telegramRequestTimeout bounds each request to the Telegram API, including
+// the long polls for updates.
+const telegramRequestTimeout = 30 * time.Second
+
+func telegramBot() (*tgbotapi.BotAPI, error) {
+	telegramClient.Lock()
+	defer telegramClient.Unlock()
+	token := conf().GetString("telegramBotToken")
+	if telegramClient.bot != nil && telegramClient.token == token {
+		return telegramClient.bot, nil
+	}
+	bot, err := tgbotapi.NewBotAPIWithClient(token, tgbotapi.APIEndpoint, &http.Client{Timeout: telegramRequestTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Telegram: %w", err)
+	}
+	telegramClient.bot, telegramClient.token = bot, token
+	return bot, nil
+}
+
+func deliverTelegram(m outboundMessage) error {
+	bot, err := telegramBot()
+	if err != nil {
+		return err
+	}
+	_, err = bot.MakeRequest("sendMessage", m.params())
+	return err
+}
+
+// telegramTokenPath is the part of a Telegram API URL with the bot token.
+var telegramTokenPath = regexp.MustCompile(`/bot[^/]*`)
+
+// redactToken is the text of an error of a Telegram API request without the
+// bot token. The library puts the token into the request URL, so a failed
+// request, e.g. a timeout, returns a *url.Error that carries it.
+func redactToken(err error) string {
+	msg := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		msg = strings.ReplaceAll(msg, urlErr.URL, telegramTokenPath.ReplaceAllString(urlErr.URL, "/bot<token>"))
+	}
+	if token := conf().GetString("telegramBotToken"); token != "" {
+		msg = strings.ReplaceAll(msg, token, "<token>")
+	}
+	return msg
+}
+
+// telegramRetryAfter is how long Telegram asked to wait before sending
+// again, when it rate-limited a request.
+func telegramRetryAfter(err error) time.Duration {
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+		return time.Duration(tgErr.RetryAfter) * time.Second
+	}
+	return 0
+}
+
+// telegramRejected reports whether Telegram refused a message for good,
+// e.g. for an unknown chat or a bot removed from it, so retrying is
+// pointless.
+func telegramRejected(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && (tgErr.Code == http.StatusBadRequest || tgErr.Code == http.StatusForbidden)
+}
+
+// runTelegramUpdates listens for presses of the inline alert buttons.
+func runTelegramUpdates() {
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = int((telegramRequestTimeout - 10*time.Second).Seconds())
+	u.AllowedUpdates = []string{"callback_query"}
+
+	for {
+		// Telegram hands each update to a single poller, so only the
+		// leader polls.
+		changed := leaderChanged()
+		if !isLeader() {
+			<-changed
+			continue
+		}
+		bot, err := telegramBot()
+		if err != nil {
+			slog.Warn("Failed to get Telegram updates, retrying in 30s", "err", redactToken(err))
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		updates, err := bot.GetUpdates(u)
+		if err != nil {
+			slog.Warn("Failed to get Telegram updates, retrying in 3s", "err", redactToken(err))
+			time.Sleep(3 * time.Second)
+			continue
+		}
+		for _, update := range updates {
+			if update.UpdateID < u.Offset {
+				continue
+			}
+			u.Offset = update.UpdateID + 1
+			if update.CallbackQuery != nil {
+				handleCallback(bot, update.CallbackQuery)
+			}
+		}
+	}
+}
+
+func handleCallback(bot *tgbotapi.BotAPI, q *tgbotapi.CallbackQuery) {
+	action, key, ok := strings.Cut(q.Data, ":")
+	if !ok {
+		return
+	}
+
+	var reply, note string
+	switch action {
+	case "ack":
+		if !alerts.ack(key) {
+			reply = "Alert is no longer active"
+			break
+		}
+		reply = "Acknowledged"
+		note = fmt.Sprintf("Acknowledged by %s", q.From.UserName)
+		recordAck(key, "telegram:"+q.From.UserName)
+	case "silence":
+		until := time.Now().Add(time.Hour)
+		if !alerts.silence(key, until) {
+			reply = "Alert is no longer active"
+			break
+		}
+		reply = "Silenced for 1h"
+		note = fmt.Sprintf("Silenced until %s by %s", localClock(until, displayLocation()), q.From.UserName)
+	default:
+		return
+	}
+
+	if _, err := bot.Request(tgbotapi.NewCallback(q.ID, reply)); err != nil {
+		slog.Error("Error answering Telegram callback", "err", redactToken(err))
+	}
+	if note == "" || q.Message == nil {
+		return
+	}
+	slog.Info("Alert button pressed", "alert", key, "action", action, "note", note)
+	recordAudit(auditEvent{Event: action, Alert: key, Channel: "telegram", Result: note})
+	edit := tgbotapi.NewEditMessageText(q.Message.Chat.ID, q.Message.MessageID, q.Message.Text+"\n\n"+note)
+	if _, err := bot.Send(edit); err != nil {
+		slog.Error("Error updating Telegram alert message", "alert", key, "err", redactToken(err))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testBotToken = "123456:ABC-secret"
+
+func TestRedactToken(t *testing.T) {
+	useConfig(t, "telegramBotToken: \""+testBotToken+"\"\n")
+	requestErr := &url.Error{Op: "Post", URL: "https://api.telegram.org/bot" + testBotToken + "/sendMessage", Err: context.DeadlineExceeded}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"request", requestErr, `Post "https://api.telegram.org/bot<token>/sendMessage": context deadline exceeded`},
+		{"wrapped", fmt.Errorf("connecting to Telegram: %w", requestErr), `connecting to Telegram: Post "https://api.telegram.org/bot<token>/sendMessage": context deadline exceeded`},
+		{"old token", &url.Error{Op: "Post", URL: "https://api.telegram.org/bot1:old/getMe", Err: context.Canceled}, `Post "https://api.telegram.org/bot<token>/getMe": context canceled`},
+		{"token in text", errors.New("bad token " + testBotToken), "bad token <token>"},
+		{"other", errors.New("chat not found"), "chat not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactToken(tt.err)
+			if got != tt.want {
+				t.Errorf("redactToken = %q, want %q", got, tt.want)
+			}
+			if strings.Contains(got, "secret") {
+				t.Errorf("%q still has the token", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Threshold holds the warning and critical levels for one metric, in
+// percent. A zero level is disabled. Once raised, an alert only clears when
+// the value drops to Clear or below, if set, so values hovering around the
+// warning level don't alert and resolve on every cycle.
+type Threshold struct {
+	Warning  float64 `json:"warning"`
+	Critical float64 `json:"critical"`
+	Clear    float64 `json:"clear"`
+}
+
+var metricNames = map[string]string{
+	"cpu":    "CPU usage",
+	"memory": "Memory usage",
+	"disk":   "Disk usage",
+}
+
+// thresholdsFor returns the thresholds for each metric on a host: the
+// built-in default of 80% warning, overridden by the global thresholds block,
+// then by the threshold profile of the host's group, and then by the host's
+// entry under hostThresholds and the thresholds of its hosts entry.
+func thresholdsFor(h Host) map[string]Threshold {
+	thresholds := map[string]Threshold{}
+	for metric := range metricNames {
+		thresholds[metric] = Threshold{Warning: 80}
+	}
+	applyThresholds(thresholds, conf().Sub("thresholds"))
+	applyThresholds(thresholds, groupSetting(h.Group, "thresholds"))
+	for name := range conf().GetStringMap("hostThresholds") {
+		if strings.EqualFold(name, h.Name) {
+			applyThresholds(thresholds, conf().Sub("hostThresholds."+name))
+		}
+	}
+	if h.Thresholds != nil {
+		v := viper.New()
+		for metric, levels := range h.Thresholds {
+			for level, value := range levels {
+				v.Set(metric+"."+level, value)
+			}
+		}
+		applyThresholds(thresholds, v)
+	}
+	return thresholds
+}
+
+func applyThresholds(thresholds map[string]Threshold, cfg *viper.Viper) {
+	if cfg == nil {
+		return
+	}
+	for metric := range metricNames {
+		if !cfg.IsSet(metric) {
+			continue
+		}
+		t := thresholds[metric]
+		if cfg.IsSet(metric + ".warning") {
+			t.Warning = cfg.GetFloat64(metric + ".warning")
+		}
+		if cfg.IsSet(metric + ".critical") {
+			t.Critical = cfg.GetFloat64(metric + ".critical")
+		}
+		if cfg.IsSet(metric + ".clear") {
+			t.Clear = cfg.GetFloat64(metric + ".clear")
+		}
+		thresholds[metric] = t
+	}
+}
+
+// checkThreshold raises or clears the alert for one metric on a host.
+func checkThreshold(h Host, metric string, value float64, t Threshold) {
+	var severity Severity
+	var level float64
+	switch {
+	case t.Critical > 0 && value > t.Critical:
+		severity, level = SeverityCritical, t.Critical
+	case t.Warning > 0 && value > t.Warning:
+		severity, level = SeverityWarning, t.Warning
+	default:
+		if _, active := alerts.get(h.Name + "/" + metric); active && t.Clear > 0 && value > t.Clear {
+			slog.Debug("Above the clear level, keeping the alert", "host", h.Name, "check", metric, "value", value, "clear", t.Clear)
+			return
+		}
+		clearAlert(h.Name, metric)
+		return
+	}
+	raiseAlert(Alert{
+		Host:     h.Name,
+		Address:  h.Address,
+		Check:    metric,
+		Severity: severity,
+		Message:  fmt.Sprintf("%s %.2f%%%s is above the %s threshold of %.0f%%", metricNames[metric], value, results.change(h.Name, metric, value, time.Now()), strings.ToLower(severity.String()), level),
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostEvent is an entry of a host's timeline. Reboots ("reboot"), services
+// starting, stopping or restarting ("service") and watched files changing
+// ("file") are stored as they are noticed; the timeline adds the alerts of
+// the host from the alert history, with their event (fired, resolved or
+// acked) as the kind.
+type hostEvent struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Kind     string    `json:"kind"`
+	Check    string    `json:"check,omitempty"`
+	Severity string    `json:"severity,omitempty"`
+	Message  string    `json:"message"`
+}
+
+// hostFacts is what was last seen of a host, to notice changes. It is kept
+// in the history store, so changes while the monitor was stopped are
+// noticed too.
+type hostFacts struct {
+	Boot     time.Time         `json:"boot,omitempty"`
+	Services map[string]string `json:"services,omitempty"` // unit -> invocation ID, empty if not running
+	Files    map[string]string `json:"files,omitempty"`    // path -> SHA-256, empty if missing
+}
+
+type factStore struct {
+	mu    sync.Mutex
+	hosts map[string]*hostFacts
+}
+
+var facts = &factStore{hosts: map[string]*hostFacts{}}
+
+// update calls fn with the facts of a host, loading them from the history
+// store the first time, and saves them if fn reports a change.
+func (s *factStore) update(host string, fn func(f *hostFacts) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(host)
+	store := series.storage()
+	f, ok := s.hosts[key]
+	if !ok {
+		f = &hostFacts{}
+		if store != nil {
+			if err := getState(store, "timeline/"+key, f); err != nil {
+				slog.Error("Error loading timeline facts", "host", host, "err", err)
+			}
+		}
+		s.hosts[key] = f
+	}
+	if fn(f) && store != nil {
+		if err := putState(store, "timeline/"+key, f); err != nil {
+			slog.Error("Error saving timeline facts", "host", host, "err", err)
+		}
+	}
+}
+
+// recordHostEvent adds an event to a host's timeline.
+func recordHostEvent(host, kind, message string, t time.Time) {
+	slog.Debug("Host event", "host", host, "kind", kind, "message", message)
+	store := series.storage()
+	if store == nil {
+		return
+	}
+	if err := store.addHostEvent(hostEvent{Time: t, Host: host, Kind: kind, Message: message}); err != nil {
+		slog.Error("Error recording host event", "host", host, "kind", kind, "err", err)
+	}
+}
+
+var uptimePattern = regexp.MustCompile(`up\s+(?:(\d+)\s+days?,\s*)?(?:(\d+):(\d+)|(\d+)\s+min)?`)
+
+// parseUptime reads how long a host has been up from the output of uptime,
+// e.g. "10:02:30 up 5 days,  3:04,  2 users, ...", to the minute.
+func parseUptime(s string) (time.Duration, bool) {
+	m := uptimePattern.FindStringSubmatch(s)
+	if m == nil || m[1] == "" && m[2] == "" && m[4] == "" {
+		return 0, false
+	}
+	n := func(s string) time.Duration {
+		v, _ := strconv.Atoi(s)
+		return time.Duration(v)
+	}
+	return n(m[1])*24*time.Hour + n(m[2])*time.Hour + n(m[3])*time.Minute + n(m[4])*time.Minute, true
+}
+
+// noteUptime records a reboot when a host's boot time moved forward. The
+// uptime is only accurate to the minute, so smaller moves are ignored.
+func noteUptime(h Host, uptime string, now time.Time) {
+	up, ok := parseUptime(uptime)
+	if !ok {
+		return
+	}
+	boot := now.Add(-up)
+	facts.update(h.Name, func(f *hostFacts) bool {
+		if f.Boot.IsZero() {
+			f.Boot = boot
+			return true
+		}
+		if boot.Sub(f.Boot) < 2*time.Minute {
+			return false
+		}
+		recordHostEvent(h.Name, "reboot", fmt.Sprintf("Rebooted (previous boot %s)", f.Boot.In(displayLocation()).Format("Jan 2 15:04")), boot)
+		f.Boot = boot
+		return true
+	})
+}
+
+// timelineScript prints the invocation IDs of the watched services, which
+// change whenever they start, and the checksums of the watched files.
+// Missing units and files print nothing rather than failing the command.
+func timelineScript(services, files []string) string {
+	var b strings.Builder
+	if len(services) > 0 {
+		b.WriteString("systemctl show -p Id -p InvocationID -p ActiveEnterTimestamp")
+		for _, s := range services {
+			b.WriteString(" " + shellQuote(s))
+		}
+		b.WriteString(" 2>/dev/null; ")
+	}
+	b.WriteString("echo ==")
+	if len(files) > 0 {
+		b.WriteString("; sha256sum")
+		for _, f := range files {
+			b.WriteString(" " + shellQuote(f))
+		}
+		b.WriteString(" 2>/dev/null")
+	}
+	b.WriteString("; true")
+	return b.String()
+}
+
+// unitState is a service as printed by systemctl show.
+type unitState struct {
+	invocation, since string
+}
+
+// parseTimelineOutput reads the output of timelineScript.
+func parseTimelineOutput(output string) (units map[string]unitState, sums map[string]string) {
+	units, sums = map[string]unitState{}, map[string]string{}
+	before, after, _ := strings.Cut(output, "==\n")
+	for _, block := range strings.Split(before, "\n\n") {
+		fields := map[string]string{}
+		for _, line := range strings.Split(block, "\n") {
+			if k, v, ok := strings.Cut(line, "="); ok {
+				fields[k] = v
+			}
+		}
+		if id := fields["Id"]; id != "" {
+			units[strings.TrimSuffix(id, ".service")] = unitState{fields["InvocationID"], fields["ActiveEnterTimestamp"]}
+		}
+	}
+	for _, line := range strings.Split(after, "\n") {
+		if sum, path, ok := strings.Cut(line, "  "); ok {
+			sums[path] = sum
+		}
+	}
+	return units, sums
+}
+
+// watchHost records the watched services of a host that started, stopped or
+// restarted and the watched files that changed since the last check.
+func watchHost(ctx context.Context, h Host, services, files []string) {
+	start := time.Now()
+	output, err := runHostCommand(ctx, h, remoteCommand(h, h.checkCommand("timeline", timelineScript(services, files))))
+	observeCheck(h.Name, "timeline", start, err)
+	if err != nil {
+		slog.Debug("Timeline check failed", "host", h.Name, "check", "timeline", "err", err)
+		return
+	}
+	units, sums := parseTimelineOutput(output)
+	now := time.Now()
+	facts.update(h.Name, func(f *hostFacts) bool {
+		changed := false
+		if f.Services == nil {
+			f.Services = map[string]string{}
+		}
+		for _, name := range services {
+			name = strings.TrimSuffix(name, ".service")
+			u := units[name]
+			previous, seen := f.Services[name]
+			if seen && previous == u.invocation {
+				continue
+			}
+			if seen {
+				switch {
+				case u.invocation == "":
+					recordHostEvent(h.Name, "service", name+" stopped", now)
+				case previous == "":
+					recordHostEvent(h.Name, "service", fmt.Sprintf("%s started (%s)", name, u.since), now)
+				default:
+					recordHostEvent(h.Name, "service", fmt.Sprintf("%s restarted (%s)", name, u.since), now)
+				}
+			}
+			f.Services[name] = u.invocation
+			changed = true
+		}
+		if f.Files == nil {
+			f.Files = map[string]string{}
+		}
+		for _, path := range files {
+			sum := sums[path]
+			previous, seen := f.Files[path]
+			if seen && previous == sum {
+				continue
+			}
+			if seen {
+				switch {
+				case sum == "":
+					recordHostEvent(h.Name, "file", path+" was removed", now)
+				case previous == "":
+					recordHostEvent(h.Name, "file", path+" was created", now)
+				default:
+					recordHostEvent(h.Name, "file", path+" changed", now)
+				}
+			}
+			f.Files[path] = sum
+			changed = true
+		}
+		return changed
+	})
+}
+
+// runTimelineCheck is the "timeline" check type: it watches
+// timeline.services and timeline.files on a host.
+func runTimelineCheck(ctx context.Context, h Host) {
+	services, files := conf().GetStringSlice("timeline.services"), conf().GetStringSlice("timeline.files")
+	if (len(services) > 0 || len(files) > 0) && checkEnabled(h, "timeline") {
+		watchHost(ctx, h, services, files)
+	}
+}
+
+// hostTimeline returns the events and alerts of a host between from and to,
+// oldest first.
+func hostTimeline(host string, from, to time.Time) ([]hostEvent, error) {
+	store := series.storage()
+	if store == nil {
+		return nil, nil
+	}
+	list, err := store.hostEvents(host, from, to)
+	if err != nil {
+		return nil, err
+	}
+	records, err := store.alertEvents(alertHistoryFilter{host: host, from: from, to: to})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		list = append(list, hostEvent{Time: r.Time, Host: r.Host, Kind: r.Event, Check: r.Check, Severity: r.Severity, Message: r.Message})
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list, nil
+}
+
+// apiTimelineHandler serves a host's timeline between from and to (the
+// last 24 hours by default).
+func apiTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	h, ok := scopedHost(r)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no host %q", r.PathValue("name")), http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	from, to := now.Add(-24*time.Hour), now
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.FormValue(name); v != "" {
+			parsed, err := parseTimeArg(v, now)
+			if err != nil {
+				http.Error(w, name+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	list, err := hostTimeline(h.Name, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []hostEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// timestampLayout is how times are shown in alerts and reports.
+const timestampLayout = "2006-01-02 15:04 MST"
+
+// displayLocation is the timezone times are shown in: the top-level
+// timezone setting, or the monitor's local zone.
+func displayLocation() *time.Location {
+	return loadLocation(conf().GetString("timezone"), time.Local)
+}
+
+// loadLocation loads a zone name, falling back to def if it is empty or
+// unknown.
+func loadLocation(name string, def *time.Location) *time.Location {
+	if name == "" {
+		return def
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		slog.Error("Unknown timezone, using the default", "timezone", name, "default", def, "err", err)
+		return def
+	}
+	return loc
+}
+
+// localClock formats a time of day in loc, e.g. for "since 14:05".
+func localClock(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("15:04")
+}
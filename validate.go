@@ -0,0 +1,556 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// configProblem is one finding of the config validation pass. Line is the
+// line of the offending key in the config file, or 0 if it isn't in there.
+type configProblem struct {
+	Key     string
+	Line    int
+	Message string
+}
+
+func (p configProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", p.Line, p.Key, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+type validator struct {
+	cfg      *viper.Viper
+	lines    map[string]int // lower-cased dotted key -> line
+	problems []configProblem
+}
+
+func (v *validator) addf(key, format string, args ...interface{}) {
+	line := 0
+	for k := strings.ToLower(key); k != ""; {
+		if l, ok := v.lines[k]; ok {
+			line = l
+			break
+		}
+		i := strings.LastIndex(k, ".")
+		if i < 0 {
+			break
+		}
+		k = k[:i]
+	}
+	v.problems = append(v.problems, configProblem{Key: key, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateConfig checks a loaded configuration for mistakes that would
+// otherwise only surface as confusing failures during a check cycle.
+func validateConfig(cfg *viper.Viper) []configProblem {
+	v := &validator{cfg: cfg, lines: map[string]int{}}
+	if data, err := os.ReadFile(v.cfg.ConfigFileUsed()); err == nil {
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err == nil {
+			collectLines(&root, "", v.lines)
+		}
+	}
+
+	if v.cfg.GetString("telegramBotToken") == "" {
+		v.addf("telegramBotToken", "is required")
+	}
+	if v.cfg.GetInt64("telegramChatID") == 0 {
+		v.addf("telegramChatID", "is required")
+	}
+
+	commands := v.cfg.GetStringSlice("SSHCommands")
+	for i, command := range commands {
+		v.validateSSHCommand(fmt.Sprintf("SSHCommands.%d", i), command)
+	}
+	var hosts []Host
+	if err := v.cfg.UnmarshalKey("hosts", &hosts); err != nil {
+		v.addf("hosts", "%v", err)
+	}
+	if len(commands)+len(hosts) == 0 && !v.cfg.IsSet("inventory") {
+		v.addf("hosts", "no hosts configured")
+	}
+	for i, h := range hosts {
+		key := fmt.Sprintf("hosts.%d", i)
+		if h.Command == "" && h.Address == "" && !h.Agent {
+			v.addf(key, "command or address is required")
+		}
+		if h.Agent && h.Name == "" {
+			v.addf(key+".name", "is required for an agent host, the agent reports under it")
+		}
+		if h.Agent && v.cfg.GetString("agents.secret") == "" {
+			v.addf(key+".agent", "needs agents.secret to check the agent's reports")
+		}
+		v.validateSSHCommand(key+".command", h.Command)
+		for check := range h.Checks {
+			v.validateCheckName(key+".checks."+check, check)
+		}
+		for metric := range h.Thresholds {
+			if _, ok := metricNames[metric]; !ok {
+				v.addf(key+".thresholds."+metric, "unknown metric, expected one of cpu, memory, disk")
+			}
+		}
+		for check, spec := range h.CheckIntervals {
+			if _, err := parseSchedule(spec); err != nil {
+				v.addf(key+".checkIntervals."+check, "%v", err)
+			}
+		}
+		for _, name := range h.Channels {
+			if !v.cfg.IsSet("channels." + name) {
+				v.addf(key+".channels", "unknown channel %q", name)
+			}
+		}
+	}
+	if path := v.cfg.GetString("inventory.file"); path != "" {
+		inv := viper.New()
+		inv.SetConfigFile(path)
+		if err := inv.ReadInConfig(); err != nil {
+			v.addf("inventory.file", "%v", err)
+		} else if err := inv.UnmarshalKey("hosts", new([]Host)); err != nil {
+			v.addf("inventory.file", "%s: %v", path, err)
+		}
+	}
+	if v.cfg.IsSet("inventory.aws") {
+		if _, err := exec.LookPath("aws"); err != nil {
+			v.addf("inventory.aws", "the aws CLI is required for EC2 discovery: %v", err)
+		}
+	}
+	if cfg := v.cfg.Sub("inventory.ansible"); cfg != nil {
+		if _, err := discoverAnsible(cfg); err != nil {
+			v.addf("inventory.ansible.file", "%v", err)
+		}
+	}
+	if _, err := discoveredHostCommand(sshDefaultsIn(v.cfg, ""), Host{}); err != nil {
+		v.addf("ssh.command", "%v", err)
+	}
+	if v.cfg.IsSet("inventory.consul") && v.cfg.GetString("inventory.consul.service") == "" {
+		v.addf("inventory.consul.service", "is required")
+	}
+	for source := range inventoryProviders {
+		cfg := v.cfg.Sub("inventory." + source)
+		if cfg == nil {
+			continue
+		}
+		if _, err := discoveredHostCommand(cfg, Host{}); err != nil {
+			v.addf("inventory."+source+".command", "%v", err)
+		}
+		v.validateDuration("inventory." + source + ".refresh")
+	}
+	seen := map[string]bool{}
+	for i, c := range customChecksIn(v.cfg) {
+		key := fmt.Sprintf("customChecks.%d", i)
+		if field, err := c.validate(); err != nil {
+			v.addf(key+"."+field, "%v", err)
+		}
+		if seen[strings.ToLower(c.Name)] || isBuiltinCheck(c.Name) {
+			v.addf(key+".name", "check %q is already defined", c.Name)
+		}
+		seen[strings.ToLower(c.Name)] = true
+	}
+	for i, c := range conditionsIn(v.cfg) {
+		key := fmt.Sprintf("conditions.%d", i)
+		if field, err := c.validate(); err != nil {
+			v.addf(key+"."+field, "%v", err)
+		}
+		if seen[strings.ToLower(c.Name)] || isBuiltinCheck(c.Name) {
+			v.addf(key+".name", "check %q is already defined", c.Name)
+		}
+		seen[strings.ToLower(c.Name)] = true
+	}
+	for check := range v.cfg.GetStringMap("dependencies") {
+		key := "dependencies." + check
+		v.validateCheckName(key, check)
+		for _, dep := range v.cfg.GetStringSlice(key) {
+			v.validateCheckName(key, dep[strings.LastIndex(dep, "/")+1:])
+		}
+	}
+	for name := range v.cfg.GetStringMap("groups") {
+		v.validateThresholds("groups." + name + ".thresholds")
+		for check := range v.cfg.GetStringMap("groups." + name + ".checks") {
+			v.validateCheckName("groups."+name+".checks."+check, check)
+		}
+		for check := range v.cfg.GetStringMap("groups." + name + ".checkIntervals") {
+			v.validateSchedule("groups." + name + ".checkIntervals." + check)
+		}
+		for _, channel := range v.cfg.GetStringSlice("groups." + name + ".channels") {
+			if !v.cfg.IsSet("channels." + channel) {
+				v.addf("groups."+name+".channels", "unknown channel %q", channel)
+			}
+		}
+	}
+	for i, r := range routesIn(v.cfg) {
+		for _, name := range r.Channels {
+			if !v.cfg.IsSet("channels." + name) {
+				v.addf(fmt.Sprintf("routes.%d.channels", i), "unknown channel %q", name)
+			}
+		}
+	}
+
+	v.validateThresholds("thresholds")
+	for name := range v.cfg.GetStringMap("hostThresholds") {
+		v.validateThresholds("hostThresholds." + name)
+	}
+
+	for _, metric := range v.cfg.GetStringSlice("forecast.metrics") {
+		if !contains(forecastMetrics, metric) {
+			v.addf("forecast.metrics", "unknown metric %q, expected one of %s", metric, strings.Join(forecastMetrics, ", "))
+		}
+	}
+
+	for _, metric := range v.cfg.GetStringSlice("anomalies.metrics") {
+		if anomalyMetrics[metric] == "" {
+			v.addf("anomalies.metrics", "unknown metric %q, expected one of %s", metric, strings.Join(anomalyMetricNames(), ", "))
+		}
+	}
+
+	for i, check := range v.cfg.GetStringSlice("sla.checks") {
+		v.validateCheckName(fmt.Sprintf("sla.checks.%d", i), check)
+	}
+	v.validateSchedule("sla.reportSchedule")
+
+	if _, err := parseLogLevel(v.cfg.GetString("log.level")); err != nil {
+		v.addf("log.level", "unknown level %q, expected debug, info, warn or error", v.cfg.GetString("log.level"))
+	}
+	if format := strings.ToLower(v.cfg.GetString("log.format")); format != "" && format != "text" && format != "json" {
+		v.addf("log.format", "unknown format %q, expected text or json", format)
+	}
+	if v.cfg.GetInt("log.maxSize") < 0 || v.cfg.GetInt("log.maxBackups") < 0 {
+		v.addf("log", "maxSize and maxBackups can't be negative")
+	}
+	for _, key := range []string{"delivery.minBackoff", "delivery.maxBackoff", "log.rotate", "flapping.window", "forecast.window", "forecast.horizon", "anomalies.window", "sla.maxGap",
+		"history.rollups.fiveMinute", "history.rollups.hourly", "checkJitter", "ha.lease", "agents.staleAfter"} {
+		v.validateDuration(key)
+	}
+	for i, path := range v.cfg.GetStringSlice("timeline.files") {
+		if !strings.HasPrefix(path, "/") {
+			v.addf(fmt.Sprintf("timeline.files.%d", i), "%q must be an absolute path", path)
+		}
+	}
+	if !contains(storageBackends, historyBackendIn(v.cfg)) {
+		v.addf("history.backend", "unknown backend %q, expected one of %s", historyBackendIn(v.cfg), strings.Join(storageBackends, ", "))
+	}
+	if v.cfg.GetBool("ha.enabled") && historyBackendIn(v.cfg) == "bolt" {
+		v.addf("ha.enabled", "needs a history store the monitors share, set history.backend to postgres")
+	}
+	if v.cfg.GetBool("agent.allowAnyCommand") && len(v.cfg.GetStringSlice("agent.commands")) > 0 {
+		v.addf("agent.allowAnyCommand", "runs any command, agent.commands would be ignored; set only one of them")
+	}
+	if v.cfg.IsSet("ha.lease") && v.cfg.GetDuration("ha.lease") < 3*time.Second {
+		v.addf("ha.lease", "must be at least 3s")
+	}
+	if rollupsEnabledIn(v.cfg) {
+		if fiveMinuteRetentionIn(v.cfg) < historyRetentionIn(v.cfg) {
+			v.addf("history.rollups.fiveMinute", "must be at least history.retention (%s)", historyRetentionIn(v.cfg))
+		}
+		if hourlyRetentionIn(v.cfg) < fiveMinuteRetentionIn(v.cfg) {
+			v.addf("history.rollups.hourly", "must be at least history.rollups.fiveMinute (%s)", fiveMinuteRetentionIn(v.cfg))
+		}
+	}
+	for name := range v.cfg.GetStringMap("checkIntervals") {
+		if _, ok := checkRunners[name]; !ok && name != "default" {
+			v.addf("checkIntervals."+name, "unknown check type")
+			continue
+		}
+		v.validateSchedule("checkIntervals." + name)
+	}
+	for name := range v.cfg.GetStringMap("checkTimeouts") {
+		_, known := checkRunners[name]
+		for _, c := range customChecksIn(v.cfg) {
+			known = known || strings.EqualFold(c.Name, name)
+		}
+		if !known && name != "default" {
+			v.addf("checkTimeouts."+name, "unknown check type or custom check")
+			continue
+		}
+		v.validateDuration("checkTimeouts." + name)
+	}
+	for check := range v.cfg.GetStringMap("consecutiveFailures") {
+		if v.cfg.GetInt("consecutiveFailures."+check) < 1 {
+			v.addf("consecutiveFailures."+check, "must be at least 1")
+		}
+	}
+	for _, key := range []string{"digest.window", "summary.schedule"} {
+		v.validateSchedule(key)
+	}
+	for i, r := range summaryReportsIn(v.cfg) {
+		key := fmt.Sprintf("summary.reports.%d", i)
+		if r.Name == "" {
+			v.addf(key+".name", "is required")
+		}
+		if _, err := parseSchedule(r.Schedule); err != nil {
+			v.addf(key+".schedule", "%v", err)
+		}
+		if d, err := parseLookback(r.Period); err != nil || d <= 0 {
+			v.addf(key+".period", "%q is not a duration such as 24h or 7d", r.Period)
+		}
+		for _, s := range r.Sections {
+			if !contains(summarySections, s) {
+				v.addf(key+".sections", "unknown section %q, expected one of %s", s, strings.Join(summarySections, ", "))
+			}
+		}
+		for j, check := range r.Checks {
+			v.validateCheckName(fmt.Sprintf("%s.checks.%d", key, j), check)
+		}
+	}
+
+	if _, err := parseQuietHours(v.cfg.Sub("quietHours")); err != nil {
+		v.addf("quietHours", "%v", err)
+	}
+	v.validateTimezone("timezone")
+	for name := range v.cfg.GetStringMap("channels") {
+		if _, err := channelByNameIn(v.cfg, name); err != nil {
+			v.addf("channels."+name, "%v", err)
+		}
+		v.validateTimezone("channels." + name + ".timezone")
+	}
+	for i, step := range escalationChainIn(v.cfg) {
+		key := fmt.Sprintf("escalation.%d", i)
+		if step.After <= 0 {
+			v.addf(key+".after", "must be a positive duration")
+		}
+		for _, name := range step.Channels {
+			if !v.cfg.IsSet("channels." + name) {
+				v.addf(key+".channels", "unknown channel %q", name)
+			}
+		}
+	}
+
+	for name := range v.cfg.GetStringMap("exporters") {
+		if _, err := newExporterIn(v.cfg, name); err != nil {
+			v.addf("exporters."+name, "%v", err)
+		}
+	}
+	if v.cfg.IsSet("heartbeat") {
+		if u, err := url.Parse(v.cfg.GetString("heartbeat.url")); err != nil || u.Scheme == "" || u.Host == "" {
+			v.addf("heartbeat.url", "must be an absolute URL")
+		}
+		if loop := v.cfg.GetString("heartbeat.loop"); loop != "" {
+			found := checkRunners[loop] != nil
+			for _, c := range customChecksIn(v.cfg) {
+				found = found || (c.Schedule != "" && strings.EqualFold(c.Name, loop))
+			}
+			if !found {
+				v.addf("heartbeat.loop", "no check loop %q", loop)
+			}
+		}
+	}
+	if v.cfg.IsSet("pushgateway") {
+		if u, err := url.Parse(v.cfg.GetString("pushgateway.url")); err != nil || u.Scheme == "" || u.Host == "" {
+			v.addf("pushgateway.url", "must be an absolute URL")
+		}
+	}
+	if v.cfg.IsSet("grafana") {
+		if u, err := url.Parse(v.cfg.GetString("grafana.url")); err != nil || u.Scheme == "" || u.Host == "" {
+			v.addf("grafana.url", "must be an absolute URL")
+		}
+		if v.cfg.GetString("grafana.token") == "" {
+			v.addf("grafana.token", "is required to write annotations")
+		}
+	}
+	if addr := v.cfg.GetString("http.listen"); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			v.addf("http.listen", "%v", err)
+		}
+	}
+	cert, key := v.cfg.GetString("http.tls.cert"), v.cfg.GetString("http.tls.key")
+	if (cert == "") != (key == "") {
+		v.addf("http.tls", "cert and key must be set together")
+	} else if cert != "" {
+		if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+			v.addf("http.tls", "%v", err)
+		}
+	}
+	for i, s := range v.cfg.GetStringSlice("http.allowIPs") {
+		if _, err := parseAllowIP(s); err != nil {
+			v.addf(fmt.Sprintf("http.allowIPs.%d", i), "%v", err)
+		}
+	}
+	for user, password := range v.cfg.GetStringMapString("http.auth.users") {
+		if password == "" {
+			v.addf("http.auth.users."+user, "password is empty")
+		}
+	}
+	for i, s := range authScopesIn(v.cfg) {
+		key := fmt.Sprintf("http.auth.scoped.%d", i)
+		if len(s.Groups) == 0 {
+			v.addf(key+".groups", "a scoped token or user needs at least one group")
+		}
+		if s.Token == "" && (s.User == "" || s.Password == "") {
+			v.addf(key, "needs a token, or a user and password")
+		}
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool { return v.problems[i].Line < v.problems[j].Line })
+	return v.problems
+}
+
+// collectLines records the line of every mapping key and sequence item,
+// addressed the way viper addresses them (e.g. "sshcommands.1").
+func collectLines(n *yaml.Node, prefix string, lines map[string]int) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			collectLines(c, prefix, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := strings.ToLower(n.Content[i].Value)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			lines[key] = n.Content[i].Line
+			collectLines(n.Content[i+1], key, lines)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			key := fmt.Sprintf("%s.%d", prefix, i)
+			lines[key] = c.Line
+			collectLines(c, key, lines)
+		}
+	}
+}
+
+var looksLikeIP = regexp.MustCompile(`^[0-9.]+$|:`)
+
+func (v *validator) validateSSHCommand(key, command string) {
+	if !strings.Contains(command, "ssh") {
+		return // not an ssh command, e.g. a local check
+	}
+	host, identity := parseSSHCommand(command)
+	if identity != "" {
+		v.validateKeyFile(key, identity)
+	}
+	if host == "" {
+		v.addf(key, "ssh command has no target host")
+	} else if looksLikeIP.MatchString(host) && net.ParseIP(strings.Trim(host, "[]")) == nil {
+		v.addf(key, "ssh target %q is not a valid IP address", host)
+	}
+}
+
+func (v *validator) validateKeyFile(key, path string) {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		v.addf(key, "identity file %s is not readable: %v", path, err)
+		return
+	}
+	f.Close()
+}
+
+func (v *validator) validateThresholds(key string) {
+	cfg := v.cfg.Sub(key)
+	if cfg == nil {
+		return
+	}
+	for metric := range cfg.AllSettings() {
+		if _, ok := metricNames[metric]; !ok {
+			v.addf(key+"."+metric, "unknown metric, expected one of cpu, memory, disk")
+			continue
+		}
+		warning, critical, clear := cfg.GetFloat64(metric+".warning"), cfg.GetFloat64(metric+".critical"), cfg.GetFloat64(metric+".clear")
+		for level, value := range map[string]float64{"warning": warning, "critical": critical, "clear": clear} {
+			if value < 0 || value > 100 {
+				v.addf(key+"."+metric+"."+level, "must be a percentage between 0 and 100")
+			}
+		}
+		if warning > 0 && critical > 0 && critical < warning {
+			v.addf(key+"."+metric, "critical level %.0f is below warning level %.0f", critical, warning)
+		}
+		if clear > 0 && warning > 0 && clear >= warning {
+			v.addf(key+"."+metric+".clear", "clear level %.0f must be below warning level %.0f", clear, warning)
+		}
+	}
+}
+
+func (v *validator) validateDuration(key string) {
+	if !v.cfg.IsSet(key) {
+		return
+	}
+	raw := v.cfg.GetString(key)
+	if _, err := time.ParseDuration(raw); err != nil {
+		v.addf(key, "%q is not a duration such as 30s or 5m", raw)
+	}
+}
+
+func isBuiltinCheck(check string) bool {
+	for _, name := range append([]string{"ssh", "parse", overrunCheck}, hostChecks...) {
+		if strings.EqualFold(name, check) {
+			return true
+		}
+	}
+	for _, metric := range forecastMetrics {
+		if strings.EqualFold(trendCheck(metric), check) {
+			return true
+		}
+	}
+	for metric := range anomalyMetrics {
+		if strings.EqualFold(anomalyCheck(metric), check) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) validateCheckName(key, check string) {
+	if isBuiltinCheck(check) {
+		return
+	}
+	for _, c := range customChecksIn(v.cfg) {
+		if strings.EqualFold(c.Name, check) {
+			return
+		}
+	}
+	for _, c := range conditionsIn(v.cfg) {
+		if strings.EqualFold(c.Name, check) {
+			return
+		}
+	}
+	v.addf(key, "unknown check, expected one of %s, a custom check or a condition", strings.Join(hostChecks, ", "))
+}
+
+func (v *validator) validateTimezone(key string) {
+	if name := v.cfg.GetString(key); name != "" {
+		if _, err := time.LoadLocation(name); err != nil {
+			v.addf(key, "unknown timezone %q", name)
+		}
+	}
+}
+
+func (v *validator) validateSchedule(key string) {
+	if !v.cfg.IsSet(key) {
+		return
+	}
+	if _, err := parseSchedule(v.cfg.GetString(key)); err != nil {
+		v.addf(key, "%v", err)
+	}
+}
+
+// runValidate implements the "validate" command.
+func runValidate() int {
+	problems := validateConfig(conf())
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK\n", conf().ConfigFileUsed())
+		return 0
+	}
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", conf().ConfigFileUsed(), p)
+	}
+	return 1
+}
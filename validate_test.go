@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	const base = `
+telegramBotToken: "x"
+telegramChatID: 1
+`
+	const host = `
+hosts:
+  - name: a
+    command: "ssh user@10.0.0.1 uptime"
+`
+	tests := []struct {
+		name   string
+		config string
+		want   []string // the keys of the problems
+	}{
+		{"valid", base + host, nil},
+		{"no token or chat", host, []string{"telegramBotToken", "telegramChatID"}},
+		{"no hosts", base, []string{"hosts"}},
+		{"legacy SSHCommands", base + "SSHCommands: [\"ssh user@10.0.0.1 uptime\"]\n", nil},
+		{"host without command", base + "hosts:\n  - name: a\n", []string{"hosts.0"}},
+		{"local command", base + "hosts:\n  - name: a\n    command: uptime\n", nil},
+		{"bad ssh address", base + "hosts:\n  - name: a\n    command: \"ssh user@10.0.0.300 uptime\"\n", []string{"hosts.0.command"}},
+		{"ssh without target", base + "hosts:\n  - name: a\n    command: \"ssh -p 22\"\n", []string{"hosts.0.command"}},
+		{"group without settings", base + "hosts:\n  - name: a\n    command: uptime\n    group: validators\n", nil},
+		{"group with settings", base + host + "groups:\n  validators:\n    thresholds:\n      cpu:\n        warning: 90\n", nil},
+		{"agent host", base + "hosts:\n  - name: a\n    agent: true\nagents:\n  secret: s\n", nil},
+		{"agent host without secret", base + "hosts:\n  - name: a\n    agent: true\n", []string{"hosts.0.agent"}},
+		{"agent host without name", base + "hosts:\n  - agent: true\nagents:\n  secret: s\n", []string{"hosts.0.name"}},
+		{"agent running any command", base + host + "agent:\n  allowAnyCommand: true\n", nil},
+		{"agent allowlist and any command", base + host + "agent:\n  commands: [uptime]\n  allowAnyCommand: true\n", []string{"agent.allowAnyCommand"}},
+		{"checks turned off", base + "hosts:\n  - name: a\n    command: uptime\n    checks:\n      disk: false\n", nil},
+		{"unknown host check", base + "hosts:\n  - name: a\n    command: uptime\n    checks:\n      nope: false\n", []string{"hosts.0.checks.nope"}},
+		{"unknown group check", base + host + "groups:\n  validators:\n    checks:\n      nope: false\n", []string{"groups.validators.checks.nope"}},
+		{"group thresholds", base + host + "groups:\n  validators:\n    thresholds:\n      cpu:\n        warning: 120\n", []string{"groups.validators.thresholds.cpu.warning"}},
+		{"unknown route channel", base + host + "routes:\n  - group: validators\n    channels: [oncall]\n", []string{"routes.0.channels"}},
+		{"bad duration", base + host + "digest:\n  window: soon\n", []string{"digest.window"}},
+		{"check interval", base + host + "checkIntervals:\n  health: 5m\n  default: 1m\n", nil},
+		{"bad check interval", base + host + "checkIntervals:\n  health: often\n", []string{"checkIntervals.health"}},
+		{"unknown check type", base + host + "checkIntervals:\n  nope: 1m\n", []string{"checkIntervals.nope"}},
+		{"bad jitter", base + host + "checkJitter: soon\n", []string{"checkJitter"}},
+		{"bad quiet hours", base + host + "quietHours:\n  start: \"25:00\"\n  end: \"08:00\"\n", []string{"quietHours"}},
+		{"threshold out of range", base + host + "thresholds:\n  cpu:\n    warning: 120\n", []string{"thresholds.cpu.warning"}},
+		{"critical below warning", base + host + "thresholds:\n  disk:\n    warning: 90\n    critical: 80\n", []string{"thresholds.disk"}},
+		{"unknown metric", base + host + "thresholds:\n  swap:\n    warning: 80\n", []string{"thresholds.swap"}},
+		{"custom check", base + host + "customChecks:\n  - name: peers\n    command: \"true\"\n    pattern: \"peers: (\\\\d+)\"\n", nil},
+		{"custom check parser", base + host + "customChecks:\n  - name: peers\n    command: \"true\"\n    parser: xml\n", []string{"customChecks.0.parser"}},
+		{"custom check without path", base + host + "customChecks:\n  - name: peers\n    command: \"true\"\n    parser: json\n", []string{"customChecks.0.path"}},
+		{"custom check named like a builtin", base + host + "customChecks:\n  - name: cpu\n    command: \"true\"\n    parser: exitcode\n", []string{"customChecks.0.name"}},
+		{"custom check turned off", base + "hosts:\n  - name: a\n    command: uptime\n    checks:\n      peers: false\ncustomChecks:\n  - name: peers\n    command: \"true\"\n    parser: exitcode\n", nil},
+		{"unknown escalation channel", base + host + "escalation:\n  - after: 10m\n    channels: [oncall]\n", []string{"escalation.0.channels"}},
+		{"escalation without delay", base + host + "channels:\n  oncall:\n    type: telegram\n    chatID: 2\nescalation:\n  - channels: [oncall]\n", []string{"escalation.0.after"}},
+		{"bad allowed IP", base + host + "http:\n  allowIPs: [\"10.0.0.0/33\"]\n", []string{"http.allowIPs.0"}},
+		{"user without password", base + host + "http:\n  auth:\n    users:\n      ops: \"\"\n", []string{"http.auth.users.ops"}},
+		{"listen address", base + host + "http:\n  listen: \":8080\"\n", nil},
+		{"bad listen address", base + host + "http:\n  listen: \"8080\"\n", []string{"http.listen"}},
+		{"tls cert without key", base + host + "http:\n  tls:\n    cert: cert.pem\n", []string{"http.tls"}},
+		{"unreadable tls cert", base + host + "http:\n  tls:\n    cert: /nonexistent/cert.pem\n    key: /nonexistent/key.pem\n", []string{"http.tls"}},
+		{"scoped token", base + host + "http:\n  auth:\n    scoped:\n      - token: t\n        groups: [g]\n", nil},
+		{"scoped token without groups", base + host + "http:\n  auth:\n    scoped:\n      - token: t\n", []string{"http.auth.scoped.0.groups"}},
+		{"scoped user without password", base + host + "http:\n  auth:\n    scoped:\n      - user: u\n        groups: [g]\n", []string{"http.auth.scoped.0"}},
+		{"bolt backend", base + host + "history:\n  backend: bolt\n", nil},
+		{"unknown backend", base + host + "history:\n  backend: mysql\n", []string{"history.backend"}},
+		{"ha with bolt", base + host + "ha:\n  enabled: true\nhistory:\n  backend: bolt\n", []string{"ha.enabled"}},
+		{"short ha lease", base + host + "ha:\n  lease: 1s\n", []string{"ha.lease"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, p := range validateConfig(testConfig(t, tt.config)) {
+				got = append(got, p.Key)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
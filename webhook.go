@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incomingAlert is an alert received from another system, before it enters
+// the same pipeline as the monitor's own alerts.
+type incomingAlert struct {
+	Host     string    `json:"host"`
+	Group    string    `json:"group"`
+	Check    string    `json:"check"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Resolved bool      `json:"resolved"`
+	Time     time.Time `json:"time"`
+}
+
+// externalHosts are the hosts named by incoming alerts that aren't in the
+// host list, with the group they were given, so routes by group apply to
+// them as well. Hosts not named for externalHostTTL are forgotten, and at
+// most maxExternalHosts are kept, so a sender can't grow the list forever.
+var externalHosts = struct {
+	sync.Mutex
+	hosts map[string]externalHostEntry
+}{hosts: map[string]externalHostEntry{}}
+
+type externalHostEntry struct {
+	group string
+	seen  time.Time
+}
+
+const (
+	externalHostTTL  = 7 * 24 * time.Hour
+	maxExternalHosts = 10000
+)
+
+func externalHost(name string) (Host, bool) {
+	externalHosts.Lock()
+	defer externalHosts.Unlock()
+	e, ok := externalHosts.hosts[strings.ToLower(name)]
+	return Host{Name: name, Group: e.group}, ok
+}
+
+// rememberExternalHost records the group an incoming alert gave a host,
+// dropping expired hosts and, when the list is full, the one not named for
+// the longest time.
+func rememberExternalHost(name, group string, now time.Time) {
+	externalHosts.Lock()
+	defer externalHosts.Unlock()
+
+	var oldest string
+	for n, e := range externalHosts.hosts {
+		if now.Sub(e.seen) > externalHostTTL {
+			delete(externalHosts.hosts, n)
+		} else if oldest == "" || e.seen.Before(externalHosts.hosts[oldest].seen) {
+			oldest = n
+		}
+	}
+	key := strings.ToLower(name)
+	if _, ok := externalHosts.hosts[key]; !ok && len(externalHosts.hosts) >= maxExternalHosts {
+		delete(externalHosts.hosts, oldest)
+	}
+	externalHosts.hosts[key] = externalHostEntry{group: group, seen: now}
+}
+
+// isCritical maps another system's severity to ours: the names in
+// webhook.criticalSeverities (default critical, page and emergency) are
+// critical, anything else is a warning.
+func isCritical(severity string) bool {
+	names := conf().GetStringSlice("webhook.criticalSeverities")
+	if len(names) == 0 {
+		names = []string{"critical", "page", "emergency"}
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, severity) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstLabel(labels map[string]string, names ...string) string {
+	for _, name := range names {
+		if v := labels[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// alertmanagerPayload is the webhook body of Prometheus Alertmanager, also
+// sent by Grafana's unified alerting.
+type alertmanagerPayload struct {
+	Alerts []struct {
+		Status      string            `json:"status"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+		StartsAt    time.Time         `json:"startsAt"`
+		EndsAt      time.Time         `json:"endsAt"`
+	} `json:"alerts"`
+}
+
+func parseAlertmanager(body []byte, source string) ([]incomingAlert, error) {
+	var p alertmanagerPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	var incoming []incomingAlert
+	for _, a := range p.Alerts {
+		in := incomingAlert{
+			Host:     firstLabel(a.Labels, "host", "instance", "job"),
+			Group:    a.Labels["group"],
+			Check:    a.Labels["alertname"],
+			Severity: a.Labels["severity"],
+			Message:  firstLabel(a.Annotations, "summary", "description", "message"),
+			Resolved: a.Status == "resolved",
+			Time:     a.StartsAt,
+		}
+		if in.Resolved {
+			in.Time = a.EndsAt
+		}
+		if in.Host == "" {
+			in.Host = source
+		}
+		incoming = append(incoming, in)
+	}
+	return incoming, nil
+}
+
+// grafanaLegacyPayload is the webhook body of Grafana's legacy alerting.
+type grafanaLegacyPayload struct {
+	State    string            `json:"state"`
+	RuleName string            `json:"ruleName"`
+	Title    string            `json:"title"`
+	Message  string            `json:"message"`
+	Tags     map[string]string `json:"tags"`
+}
+
+func parseGrafana(body []byte) ([]incomingAlert, error) {
+	var probe struct {
+		Alerts json.RawMessage `json:"alerts"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, err
+	}
+	if probe.Alerts != nil {
+		return parseAlertmanager(body, "grafana")
+	}
+	var p grafanaLegacyPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	if p.State != "alerting" && p.State != "ok" {
+		return nil, nil // no_data, paused or pending
+	}
+	in := incomingAlert{
+		Host:     firstLabel(p.Tags, "host", "instance"),
+		Group:    p.Tags["group"],
+		Check:    p.RuleName,
+		Severity: p.Tags["severity"],
+		Message:  p.Message,
+		Resolved: p.State == "ok",
+	}
+	if in.Message == "" {
+		in.Message = p.Title
+	}
+	if in.Host == "" {
+		in.Host = "grafana"
+	}
+	return []incomingAlert{in}, nil
+}
+
+// parseCustom accepts one incomingAlert or a list of them.
+func parseCustom(body []byte) ([]incomingAlert, error) {
+	var incoming []incomingAlert
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		err := json.Unmarshal(body, &incoming)
+		return incoming, err
+	}
+	var in incomingAlert
+	err := json.Unmarshal(body, &in)
+	return []incomingAlert{in}, err
+}
+
+var webhookParsers = map[string]func([]byte) ([]incomingAlert, error){
+	"alertmanager": func(body []byte) ([]incomingAlert, error) { return parseAlertmanager(body, "alertmanager") },
+	"grafana":      parseGrafana,
+	"custom":       parseCustom,
+}
+
+// receiveAlert feeds an incoming alert into the same pipeline as the
+// monitor's own: dependencies, deduplication, acknowledgements, silences,
+// routing and escalation. A source can't take over or resolve an alert of
+// the monitor's own checks, e.g. ssh, or of another source.
+func receiveAlert(in incomingAlert, source string) {
+	_, known := hostByName(in.Host)
+	aa, active := alerts.get(in.Host + "/" + in.Check)
+	// Without an alert to resolve, only the checks of hosts that webhooks
+	// alone report on can be marked as passed.
+	if active && aa.Source != source || in.Resolved && !active && known {
+		slog.Debug("Ignoring webhook alert for a check the source didn't raise", "source", source, "host", in.Host, "check", in.Check, "resolved", in.Resolved)
+		return
+	}
+	if in.Resolved {
+		clearAlert(in.Host, in.Check)
+		return
+	}
+	if !known {
+		rememberExternalHost(in.Host, in.Group, time.Now())
+	}
+	a := Alert{Host: in.Host, Check: in.Check, Message: in.Message, Time: in.Time, Source: source}
+	if isCritical(in.Severity) {
+		a.Severity = SeverityCritical
+	}
+	if a.Message == "" {
+		a.Message = in.Check + " firing"
+	}
+	raiseAlert(a)
+}
+
+// webhookHandler receives alerts from Alertmanager, Grafana or custom
+// scripts on /api/v1/webhooks/{source}. A standby monitor turns them away,
+// so the sender retries, or sends them to the leader.
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLeader() {
+		http.Error(w, fmt.Sprintf("standing by, send to the leader %s", currentLeader()), http.StatusServiceUnavailable)
+		return
+	}
+	source := r.PathValue("source")
+	parse, ok := webhookParsers[source]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown source %q, use alertmanager, grafana or custom", source), http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	incoming, err := parse(body)
+	if err != nil {
+		http.Error(w, "parsing alerts: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, in := range incoming {
+		if in.Host == "" || in.Check == "" {
+			http.Error(w, "every alert needs a host and a check", http.StatusBadRequest)
+			return
+		}
+	}
+	for _, in := range incoming {
+		slog.Debug("Received webhook alert", "source", source, "host", in.Host, "check", in.Check, "resolved", in.Resolved)
+		receiveAlert(in, source)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
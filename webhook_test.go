@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReceiveAlertLeavesOtherAlerts(t *testing.T) {
+	useConfig(t, "hosts:\n  - name: web1\n")
+	tests := []struct {
+		name   string
+		active Alert
+		in     incomingAlert
+	}{
+		{"resolve of our check", Alert{Host: "web1", Check: "ssh"}, incomingAlert{Host: "web1", Check: "ssh", Resolved: true}},
+		{"resolve of another source", Alert{Host: "web1", Check: "backup", Source: "grafana"}, incomingAlert{Host: "web1", Check: "backup", Resolved: true}},
+		{"alert on our check", Alert{Host: "web1", Check: "ssh"}, incomingAlert{Host: "web1", Check: "ssh", Severity: "critical"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts.restore(activeAlert{Alert: tt.active, Since: time.Now()})
+			t.Cleanup(func() { alerts.clear(tt.active.Key()) })
+
+			receiveAlert(tt.in, "custom")
+			aa, ok := alerts.get(tt.active.Key())
+			if !ok || aa.Alert != tt.active {
+				t.Errorf("active alert %+v (%v), want %+v", aa.Alert, ok, tt.active)
+			}
+		})
+	}
+}
+
+func TestRememberExternalHost(t *testing.T) {
+	t.Cleanup(func() {
+		externalHosts.Lock()
+		externalHosts.hosts = map[string]externalHostEntry{}
+		externalHosts.Unlock()
+	})
+
+	start := time.Now()
+	rememberExternalHost("old", "db", start)
+	rememberExternalHost("DB1", "db", start.Add(time.Hour))
+	if h, ok := externalHost("db1"); !ok || h.Group != "db" {
+		t.Errorf("externalHost(db1) = %+v, %v", h, ok)
+	}
+
+	rememberExternalHost("db2", "db", start.Add(externalHostTTL+time.Minute))
+	if _, ok := externalHost("old"); ok {
+		t.Error("expired host still known")
+	}
+	if _, ok := externalHost("db1"); !ok {
+		t.Error("host seen within the TTL forgotten")
+	}
+
+	now := start.Add(externalHostTTL)
+	for i := len(externalHosts.hosts); i < maxExternalHosts+1; i++ {
+		rememberExternalHost(fmt.Sprintf("h%d", i), "", now)
+	}
+	if n := len(externalHosts.hosts); n != maxExternalHosts {
+		t.Errorf("%d hosts kept, want %d", n, maxExternalHosts)
+	}
+	if _, ok := externalHost("db1"); ok {
+		t.Error("least recently seen host kept over the limit")
+	}
+}